@@ -1,40 +1,115 @@
-package main
-
-import (
-	"../labrpc"
-	"../models"
-	"fmt"
-)
-
-// main is an example about how to create a cluster, visit the cluster from outside it, and inject some errors to the
-// cluster. We will test your implementation using similar approaches.
-func main() {
-	// set up a network and a cluster
-	clusterName := "MyCluster"
-	network := labrpc.MakeNetwork()
-	c := models.NewCluster(3, network, clusterName)
-
-	// create a client and connect to the cluster
-	clientName := "ClientA"
-	cli := network.MakeEnd(clientName)
-	network.Connect(clientName, c.Name)
-	network.Enable(clientName, true)
-
-	// send a request to the cluster
-	fmt.Println("Sending a greet to the cluster...")
-	reply := ""
-	cli.Call("Cluster.SayHello", clientName, &reply)
-	fmt.Println("The coordinator returns a reply:")
-	fmt.Println(reply)
-
-	// disable some nodes and resend the request
-	fmt.Println()
-	fmt.Println("Sending a greet with two nodes disabled")
-	network.DeleteServer("Node1")
-	network.DeleteServer("Node2")
-	reply = ""
-	cli.Call("Cluster.SayHello", clientName, &reply)
-	fmt.Println("The coordinator returns a reply:")
-	fmt.Println(reply)
-}
-
+package main
+
+import (
+	"../labrpc"
+	"../models"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// main is an example about how to create a cluster, visit the cluster from outside it, and inject some errors to the
+// cluster. We will test your implementation using similar approaches.
+// Given -schema, and -rules or -hash, it builds a table instead, imports the files given with -import into it and
+// exports it to the file given with -export, the formats of the files being given by their extensions, e.g.,
+//
+//	go run HelloWorld.go -schema student.json -rules rules.json -import student.csv -export student.jsonl
+//	go run HelloWorld.go -schema student.json -hash hashing.json -import student.csv
+func main() {
+	schemaPath := flag.String("schema", "", "the JSON file of the schema of the table to build")
+	rulesPath := flag.String("rules", "", "the JSON file of the partition rules of the table")
+	hashPath := flag.String("hash", "", "the JSON file of the hash partitioning of the table, instead of -rules")
+	importPath := flag.String("import", "", "a CSV or JSONL file to import into the table")
+	exportPath := flag.String("export", "", "a CSV or JSONL file to export the table to")
+	flag.Parse()
+	if *schemaPath != "" {
+		transfer(*schemaPath, *rulesPath, *hashPath, *importPath, *exportPath)
+		return
+	}
+
+	// set up a network and a cluster
+	clusterName := "MyCluster"
+	network := labrpc.MakeNetwork()
+	c := models.NewCluster(3, network, clusterName)
+
+	// create a client and connect to the cluster
+	clientName := "ClientA"
+	cli := network.MakeEnd(clientName)
+	network.Connect(clientName, c.Name)
+	network.Enable(clientName, true)
+
+	// send a request to the cluster
+	fmt.Println("Sending a greet to the cluster...")
+	reply := ""
+	cli.Call("Cluster.SayHello", clientName, &reply)
+	fmt.Println("The coordinator returns a reply:")
+	fmt.Println(reply)
+
+	// disable some nodes and resend the request
+	fmt.Println()
+	fmt.Println("Sending a greet with two nodes disabled")
+	network.DeleteServer("Node1")
+	network.DeleteServer("Node2")
+	reply = ""
+	cli.Call("Cluster.SayHello", clientName, &reply)
+	fmt.Println("The coordinator returns a reply:")
+	fmt.Println(reply)
+}
+
+// transfer builds a table on a new cluster, partitioned by either rules or a hash partitioning, imports a file into it
+// and exports it to another file, either file being optional.
+func transfer(schemaPath, rulesPath, hashPath, importPath, exportPath string) {
+	if (rulesPath == "") == (hashPath == "") {
+		log.Fatal("either -rules or -hash is needed")
+	}
+	schema := models.TableSchema{}
+	readJSON(schemaPath, &schema)
+	params := []interface{}{schema, []byte(nil)}
+	if rulesPath != "" {
+		rules, err := ioutil.ReadFile(rulesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		params[1] = rules
+	}
+	if hashPath != "" {
+		hashing := models.HashPartitioning{}
+		readJSON(hashPath, &hashing)
+		params = append(params, hashing)
+	}
+
+	c := models.NewCluster(3, labrpc.MakeNetwork(), "MyCluster")
+	reply := ""
+	if c.BuildTable(params, &reply); reply[0] != '0' {
+		log.Fatalf("cannot build %s: %s", schema.TableName, reply[2:])
+	}
+	if importPath != "" {
+		result, err := c.ImportFile(schema.TableName, importPath)
+		if err != nil {
+			log.Fatalf("cannot import %s: %v", importPath, err)
+		}
+		fmt.Printf("%d rows imported from %s\n", result.Inserted, importPath)
+		for _, failure := range result.Failures {
+			fmt.Printf("row %d not imported: %s\n", failure.Row, failure.Reason)
+		}
+	}
+	if exportPath != "" {
+		if err := c.ExportFile(schema.TableName, exportPath); err != nil {
+			log.Fatalf("cannot export %s: %v", exportPath, err)
+		}
+		fmt.Printf("%s exported to %s\n", schema.TableName, exportPath)
+	}
+}
+
+// readJSON decodes a JSON file into v, exiting if it cannot.
+func readJSON(path string, v interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Fatalf("cannot read %s: %v", path, err)
+	}
+}
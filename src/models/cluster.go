@@ -1,15 +1,16 @@
 package models
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"../labgob"
 	"../labrpc"
-	"github.com/google/uuid"
 )
 
 // Cluster consists of a group of nodes to manage distributed tables defined in models/table.go.
@@ -20,16 +21,49 @@ import (
 type Cluster struct {
 	// the identifiers of each node, we use simple numbers like "1,2,3" to register the nodes in the network
 	// needless to say, each identifier should be unique
-	nodeIds      []string
-	tableName2id map[string][]string
-	// how many rules does this table have (How many copies of this table can a node have at most)
-	tableName2num map[string]int
+	// it is guarded by mu and replaced rather than modified when nodes are added or decommissioned, see nodeList
+	nodeIds []string
 	// the network that the cluster works on. It is not actually using the network interface, but a network simulator
 	// using SEDA (google it if you have not heard about it), which allows us (and you) to inject some network failures
 	// during tests. Do remember that network failures should always be concerned in a distributed environment.
 	network *labrpc.Network
 	// the Name of the cluster, also used as a network address of the cluster coordinator in the network above
 	Name string
+	// whether BuildTable refuses the partition rules leaving some values of the partitioning columns held by no
+	// fragment, which are otherwise reported as warnings, see ValidatePartitionRules. It must be set before the cluster
+	// serves requests.
+	RejectGaps bool
+	// mu is the catalog lock guarding the maps below. Client requests are dispatched concurrently by the network, so
+	// the lock is held only while the catalog is read or modified, never across an RPC to a node.
+	mu sync.RWMutex
+	// writeMu is held shared by every write for its whole duration, and exclusively by the operations that must not
+	// interleave with writes, e.g., copying the rows a restarted node missed from its replicas.
+	writeMu sync.RWMutex
+	// the directory under which each node keeps its durable tables, empty if the nodes are in-memory only
+	dataDir string
+	// the running incarnation of each node and the persister it saves its state into, guarded by mu. They belong to the
+	// simulated machines rather than to the coordinator, and are only used to restart the nodes.
+	nodes      map[string]*Node
+	persisters map[string]*Persister
+	// the schema of each table as given to BuildTable, without the hidden id column, guarded by mu
+	tableSchemas map[string]*TableSchema
+	// the sequence and the counters of the ids of the rows of each table, guarded by mu
+	tableIds map[string]*rowIds
+	// table -> the primary key and unique constraints of the table in the order of TableSchema.uniqueKeys -> the
+	// encoded values of a key -> the id of the row holding them, guarded by mu. As every write goes through the
+	// coordinator, the keys are checked here once for all the horizontal fragments of the table.
+	uniqueKeyIds map[string][]map[string]int64
+	// the fragments of each table in the order of their numbers, as defined by the partition rules, guarded by mu
+	tableFragments map[string][]fragment
+	// the hash partitioning of the tables partitioned by hash rather than by rules, guarded by mu
+	tableHashing map[string]*HashPartitioning
+	// the new partitioning of the tables being repartitioned by AlterPartitioning, which every write of the table goes
+	// to as well until the switch, guarded by mu
+	pendingLayouts map[string]*layout
+	// what the failure detector knows about each node, and the channel stopping it if it runs in the background,
+	// guarded by mu
+	health       map[string]*nodeHealth
+	detectorDone chan struct{}
 }
 
 // NewCluster creates a Cluster with the given number of nodes and register the nodes to the given network.
@@ -44,34 +78,48 @@ type Cluster struct {
 // the lab, a "Node" is responsible for processing distributed affairs but a "Server" simply receives messages from the
 // net work.
 func NewCluster(nodeNum int, network *labrpc.Network, clusterName string) *Cluster {
+	// in-memory nodes start from empty persisters, which cannot fail
+	c, _ := NewClusterWithDataDir(nodeNum, network, clusterName, "")
+	return c
+}
+
+// NewClusterWithDataDir creates a Cluster like NewCluster, and each node keeps its durable tables under a sub-directory
+// of dataDir named by its identifier, so that fragments created with the "durable" storage survive restarts.
+// If dataDir is empty, the nodes can only hold in-memory fragments. An error is returned if a node cannot recover the
+// tables under its directory, in which case the nodes already started are closed and removed from the network.
+func NewClusterWithDataDir(nodeNum int, network *labrpc.Network, clusterName string,
+	dataDir string) (*Cluster, error) {
 	labgob.Register(TableSchema{})
 	labgob.Register(Row{})
+	labgob.Register([]Row{})
 	labgob.Register(Predicate{})
 	labgob.Register(json.Number(""))
-	tableName2id := make(map[string][]string)
-	tableName2num := make(map[string]int)
+	labgob.Register([]interface{}{})
+	labgob.Register(HashPartitioning{})
+	labgob.Register(ColumnSchema{})
+	labgob.Register([][]Row{})
+	labgob.Register([]int64{})
 	nodeIds := make([]string, nodeNum)
-	nodeNamePrefix := "Node"
+	// create a cluster with the nodes and the network
+	c := &Cluster{nodeIds: nodeIds, network: network, Name: clusterName, dataDir: dataDir,
+		nodes: make(map[string]*Node), persisters: make(map[string]*Persister),
+		tableSchemas: make(map[string]*TableSchema), tableIds: make(map[string]*rowIds),
+		uniqueKeyIds: make(map[string][]map[string]int64), tableFragments: make(map[string][]fragment),
+		tableHashing: make(map[string]*HashPartitioning), pendingLayouts: make(map[string]*layout),
+		health: make(map[string]*nodeHealth)}
 	for i := 0; i < nodeNum; i++ {
 		// identify the nodes with "Node0", "Node1", ...
-		node := NewNode(nodeNamePrefix + strconv.Itoa(i))
-		nodeIds[i] = node.Identifier
-		// use go reflection to extract the methods in a Node object and make them as a service.
-		// a service can be viewed as a list of methods that a server provides.
-		// due to the limitation of the framework, the extracted method must only have two parameters, and the first one
-		// is the actual argument list, while the second one is the reference to the result.
-		// NOTICE, a REFERENCE should be passed to the method instead of a value
-		nodeService := labrpc.MakeService(node)
-		// create a server, a server is responsible for receiving requests and dispatching them
-		server := labrpc.MakeServer()
-		// add the service to the server so the server can provide the services
-		server.AddService(nodeService)
-		// register the server to the network as "Node0", "Node1", ...
-		network.AddServer(nodeIds[i], server)
+		nodeIds[i] = nodeNamePrefix + strconv.Itoa(i)
+		c.persisters[nodeIds[i]] = MakePersister()
+		if err := c.startNode(nodeIds[i]); err != nil {
+			for _, started := range nodeIds[:i] {
+				c.nodes[started].Close()
+				network.DeleteServer(started)
+			}
+			return nil, fmt.Errorf("cannot start %s: %v", nodeIds[i], err)
+		}
 	}
 
-	// create a cluster with the nodes and the network
-	c := &Cluster{nodeIds: nodeIds, network: network, Name: clusterName, tableName2id: tableName2id, tableName2num: tableName2num}
 	// create a coordinator for the cluster to receive external requests, the steps are similar to those above.
 	// notice that we use the reference of the cluster as the name of the coordinator server,
 	// and the names can be more than strings.
@@ -79,7 +127,48 @@ func NewCluster(nodeNum int, network *labrpc.Network, clusterName string) *Clust
 	server := labrpc.MakeServer()
 	server.AddService(clusterService)
 	network.AddServer(clusterName, server)
-	return c
+	return c, nil
+}
+
+// startNode creates an incarnation of the node with the state in its persister and binds it to the server with the
+// same name in the network, replacing the previous incarnation if there is one.
+func (c *Cluster) startNode(nodeId string) error {
+	nodeDataDir := ""
+	if c.dataDir != "" {
+		nodeDataDir = filepath.Join(c.dataDir, nodeId)
+	}
+	c.mu.RLock()
+	persister := c.persisters[nodeId]
+	c.mu.RUnlock()
+	node, err := MakeNode(nodeId, nodeDataDir, persister)
+	if err != nil {
+		return err
+	}
+	// use go reflection to extract the methods in a Node object and make them as a service.
+	// a service can be viewed as a list of methods that a server provides.
+	// due to the limitation of the framework, the extracted method must only have two parameters, and the first one
+	// is the actual argument list, while the second one is the reference to the result.
+	// NOTICE, a REFERENCE should be passed to the method instead of a value
+	nodeService := labrpc.MakeService(node)
+	// create a server, a server is responsible for receiving requests and dispatching them
+	server := labrpc.MakeServer()
+	// add the service to the server so the server can provide the services
+	server.AddService(nodeService)
+	// register the server to the network as "Node0", "Node1", ...
+	c.network.AddServer(nodeId, server)
+	c.mu.Lock()
+	c.nodes[nodeId] = node
+	c.mu.Unlock()
+	return nil
+}
+
+// nodeEnd returns a client end connected to the given node.
+func (c *Cluster) nodeEnd(nodeId string) *labrpc.ClientEnd {
+	endName := "InternalClient" + nodeId
+	end := c.network.MakeEnd(endName)
+	c.network.Connect(endName, nodeId)
+	c.network.Enable(endName, true)
+	return end
 }
 
 // SayHello is an example to show how the coordinator communicates with other nodes in the cluster.
@@ -88,7 +177,7 @@ func NewCluster(nodeNum int, network *labrpc.Network, clusterName string) *Clust
 // one is a reference to the return value. The caller must ensure that the reference is valid (not nil).
 func (c *Cluster) SayHello(visitor string, reply *string) {
 	endNamePrefix := "InternalClient"
-	for _, nodeId := range c.nodeIds {
+	for _, nodeId := range c.nodeList() {
 		// create a client (end) to each node
 		// the name of the client should be unique, so we use the name of each node for it
 		endName := endNamePrefix + nodeId
@@ -111,8 +200,15 @@ func (c *Cluster) SayHello(visitor string, reply *string) {
 }
 
 // Join all tables in the given list using NATURAL JOIN (join on the common columns), and return the joined result
-// as a list of rows and set it to reply.
+// as a list of rows and set it to reply. Two tables partitioned by hash on the same key are joined bucket by bucket,
+// or like any other tables if a bucket cannot be read from any of its replicas.
 func (c *Cluster) Join(tableNames []string, reply *Dataset) {
+	if len(tableNames) == 2 && c.colocated(tableNames[0], tableNames[1]) {
+		if dataset, err := c.joinBuckets(tableNames[0], tableNames[1]); err == nil {
+			*reply = dataset
+			return
+		}
+	}
 
 	// 开始根据节点连接数据
 	result_rows := make([]Row, 0)
@@ -126,48 +222,29 @@ func (c *Cluster) Join(tableNames []string, reply *Dataset) {
 		// 获取完整的表头
 		tableName1 := tableNames[0]
 		tableName2 := tableNames[1]
-		table1_ids := c.tableName2id[tableName1]
-		table2_ids := c.tableName2id[tableName2]
-		endNamePrefix := "InternalClient"
-		for _, nodeId := range c.nodeIds {
-			endName := endNamePrefix + nodeId
-			end := c.network.MakeEnd(endName)
-			c.network.Connect(endName, nodeId)
-			c.network.Enable(endName, true)
-			if len(table1_columns) != 0 && len(table2_columns) != 0 {
-				break
-			}
-			if len(table1_columns) == 0 {
-				for i := 0; i < c.tableName2num[tableName1]; i++ {
-					end.Call("Node.GetFullSchema", tableName1+"|"+strconv.Itoa(i), &table1_columns)
-				}
-			}
-			if len(table2_columns) == 0 {
-				for i := 0; i < c.tableName2num[tableName2]; i++ {
-					end.Call("Node.GetFullSchema", tableName2+"|"+strconv.Itoa(i), &table2_columns)
-				}
-			}
+		c.mu.RLock()
+		if schema, ok := c.tableSchemas[tableName1]; ok {
+			table1_columns = append(table1_columns, schema.ColumnSchemas...)
+		}
+		if schema, ok := c.tableSchemas[tableName2]; ok {
+			table2_columns = append(table2_columns, schema.ColumnSchemas...)
 		}
+		c.mu.RUnlock()
 
 		createJoinSchema([]interface{}{table1_columns, table2_columns}, &newColumns, &same_columns1, &same_columns2)
 
-		if len(same_columns1) != 0 {
+		// the rows of both tables are assembled from their fragments, in the order they are inserted
+		table1_rows, err1 := c.scanRows(tableName1)
+		table2_rows, err2 := c.scanRows(tableName2)
+		if len(same_columns1) != 0 && err1 == nil && err2 == nil {
 			need_join := true
-			for _, id1 := range table1_ids {
-				lineOfTable1 := getLineByid(c, tableName1, id1, table1_columns)
-				if lineOfTable1.Schema.TableName == "" {
-					continue
-				}
-				for _, id2 := range table2_ids {
-					lineOfTable2 := getLineByid(c, tableName2, id2, table2_columns)
-					if lineOfTable2.Schema.TableName == "" {
-						continue
-					}
-					subRow1 := lineOfTable1.Rows[0]
-					subRow2 := lineOfTable2.Rows[0]
+			for _, row1 := range table1_rows {
+				for _, row2 := range table2_rows {
+					subRow1 := append(Row(nil), row1[:len(table1_columns)]...)
+					subRow2 := row2[:len(table2_columns)]
 					join_data := true
 					for i := 0; i < len(same_columns1); i++ {
-						if subRow1[same_columns1[i]] != subRow2[same_columns2[i]] {
+						if !ValuesEqual(subRow1[same_columns1[i]], subRow2[same_columns2[i]]) {
 							join_data = false
 							break
 						}
@@ -213,7 +290,7 @@ func createJoinSchema(args []interface{}, newColumns *[]ColumnSchema, same_colum
 
 	for ind1, col1 := range table_schemas1 {
 		for ind2, col2 := range table_schemas2 {
-			if col1 == col2 {
+			if sameColumn(col1, col2) {
 				sameColumns1 = append(sameColumns1, ind1)
 				sameColumns2 = append(sameColumns2, ind2)
 				break
@@ -237,72 +314,105 @@ func createJoinSchema(args []interface{}, newColumns *[]ColumnSchema, same_colum
 	*same_columns2 = sameColumns2
 }
 
-func getLineByid(c *Cluster, tableName string, id string, fullSchema []ColumnSchema) Dataset {
-	endNamePrefix := "InternalClient"
-
-	resultColumns := make([]ColumnSchema, 0)
-	var resultRow Row
-	Rows := make([]Row, 1)
-	ret_tablename := ""
-	for _, nodeId := range c.nodeIds {
-		endName := endNamePrefix + nodeId
-		end := c.network.MakeEnd(endName)
-		c.network.Connect(endName, nodeId)
-		c.network.Enable(endName, true)
-
-		line := Dataset{}
-		find := false
-		for i := 0; i < c.tableName2num[tableName]; i++ {
-			end.Call("Node.ScanLineData", []interface{}{tableName+"|"+strconv.Itoa(i), id}, &line)
-			if line.Schema.TableName != "" && len(line.Rows) > 0 && len(line.Rows[0]) > 0 {
-				find = true
-				break
-			}
-		}
-		if !find {
-			continue
-		}
-
-		ret_tablename = tableName
-		resultColumns = append(resultColumns, line.Schema.ColumnSchemas[1:]...)
-		resultRow = append(resultRow, line.Rows[0][1:]...)
+// BuildTable builds a distributed table from its schema and its partition rules, and creates its fragments on the
+// nodes. The name of a table cannot contain "|", which separates the name of a fragment from its number. Writes are
+// blocked while the fragments are created, so that a table being dropped does not take them away. If a fragment
+// cannot be created, the ones created are dropped and the table is not built.
+// params: schema TableSchema, rules []byte, hashing HashPartitioning (optional, see HashPartitioning)
+func (c *Cluster) BuildTable(params []interface{}, reply *string) {
+	schema := params[0].(TableSchema)
+	if isVirtualTable(schema.TableName) {
+		*reply = "1 cannot build a table in " + InformationSchema
+		return
 	}
-
-	for _, col1 := range fullSchema {
-		for j, col2 := range resultColumns {
-			if col1 == col2 {
-				Rows[0] = append(Rows[0], resultRow[j])
-				break
-			}
+	if strings.Contains(schema.TableName, "|") {
+		*reply = "1 the name of a table cannot contain |"
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	keys, err := schema.uniqueKeys()
+	if err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	declared := schema
+	declared.ColumnSchemas = append([]ColumnSchema(nil), schema.ColumnSchemas...)
+	var hashing *HashPartitioning
+	if len(params) > 2 {
+		h := params[2].(HashPartitioning)
+		hashing = &h
+	}
+	fragmentRules, err := c.fragmentRules(&declared, params[1].([]byte), hashing)
+	if err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	if err := declared.bindChecks(); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.tableSchemas[schema.TableName]; ok {
+		c.mu.Unlock()
+		*reply = "1 table " + schema.TableName + " already exists"
+		return
+	}
+	_, err = declared.foreignKeys(func(tableName string) *TableSchema {
+		if tableName == declared.TableName {
+			return &declared
 		}
+		return c.lookupSchema(tableName)
+	})
+	if err != nil {
+		c.mu.Unlock()
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	c.tableIds[schema.TableName] = newRowIds()
+	c.tableSchemas[schema.TableName] = &declared
+	if hashing != nil {
+		c.tableHashing[schema.TableName] = hashing
 	}
-	resultSet := Dataset{}
-	if len(Rows) > 0 {
-		resultSet.Schema = TableSchema{TableName: ret_tablename, ColumnSchemas: fullSchema}
-		resultSet.Rows = Rows
+	c.uniqueKeyIds[schema.TableName] = make([]map[string]int64, len(keys))
+	for i := range keys {
+		c.uniqueKeyIds[schema.TableName][i] = make(map[string]int64)
 	}
+	c.mu.Unlock()
 
-	return resultSet
+	fragments, err := c.createFragments(&declared, fragmentRules, 0)
+	if err != nil {
+		// the table is taken out of the catalog, so that it can be built again once the nodes are reachable
+		c.mu.Lock()
+		delete(c.tableIds, schema.TableName)
+		delete(c.tableSchemas, schema.TableName)
+		delete(c.tableHashing, schema.TableName)
+		delete(c.uniqueKeyIds, schema.TableName)
+		c.mu.Unlock()
+		c.dropFragments(fragments)
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.tableFragments[schema.TableName] = fragments
+	c.mu.Unlock()
+	*reply = "0 OK"
 }
 
-func (c *Cluster) BuildTable(params []interface{}, reply *string) {
-	schema := params[0].(TableSchema)
-	schema.ColumnSchemas = append(schema.ColumnSchemas, ColumnSchema{Name: "id", DataType: TypeString})
-	rules := make(map[string]Rule)
-	c.tableName2id[schema.TableName] = make([]string, 0)
-
-	decoder := json.NewDecoder(bytes.NewReader(params[1].([]byte)))
-	decoder.UseNumber()
-	decoder.Decode(&rules)
-	c.tableName2num[schema.TableName] = len(rules)
-
-	nodeNamePrefix := "Node"
+// createFragments creates the fragments defined by the given rules on their nodes, numbering them from first, and
+// returns them in the order of the rules. If an error is returned, so are the fragments created so far, each with the
+// nodes it was created on.
+func (c *Cluster) createFragments(declared *TableSchema, fragmentRules []fragmentRule, first int) ([]fragment, error) {
+	schema := *declared
+	schema.ColumnSchemas = append(append([]ColumnSchema(nil), declared.ColumnSchemas...),
+		ColumnSchema{Name: idColumnName, DataType: idDataType})
 	endNamePrefix := "InternalClient"
-	i := 0
-	for key, value := range rules {
-		ts := &TableSchema{TableName: schema.TableName + "|" + strconv.Itoa(i), ColumnSchemas: make([]ColumnSchema, 0)}
-		i++
-		ts.ColumnSchemas = append(ts.ColumnSchemas, ColumnSchema{Name: "id", DataType: TypeString})
+	fragments := make([]fragment, 0, len(fragmentRules))
+	for i, fr := range fragmentRules {
+		key, value := fr.nodes, fr.rule
+		ts := &TableSchema{TableName: schema.TableName + "|" + strconv.Itoa(first+i),
+			ColumnSchemas: make([]ColumnSchema, 0)}
+		ts.ColumnSchemas = append(ts.ColumnSchemas, ColumnSchema{Name: idColumnName, DataType: idDataType})
 		for _, columnName := range value.Column {
 			for _, cs := range schema.ColumnSchemas {
 				if cs.Name == columnName {
@@ -312,6 +422,18 @@ func (c *Cluster) BuildTable(params []interface{}, reply *string) {
 			}
 		}
 
+		storage, err := ParseStorage(value.Storage)
+		if err != nil {
+			return fragments, err
+		}
+		if value.Default {
+			value.Predicate = Predicate{}
+		}
+		if err := value.Predicate.bind(declared.ColumnSchemas); err != nil {
+			return fragments, err
+		}
+		f := fragment{name: ts.TableName, key: key, columns: value.Column, predicate: value.Predicate,
+			isDefault: value.Default, bucket: fr.bucket}
 		nodeIds := strings.Split(key, "|")
 		for _, nodeId := range nodeIds {
 			nodeName := nodeNamePrefix + nodeId
@@ -319,34 +441,206 @@ func (c *Cluster) BuildTable(params []interface{}, reply *string) {
 			end := c.network.MakeEnd(endName)
 			c.network.Connect(endName, nodeName)
 			c.network.Enable(endName, true)
-			end.Call("Node.RPCCreateTable", []interface{}{ts, value.Predicate, schema}, reply)
-			if (*reply)[0] != '0' {
-				return
+			reply := ""
+			if !end.Call("Node.RPCCreateTable", []interface{}{ts, value.Predicate, schema, storage}, &reply) {
+				return append(fragments, f), errors.New(nodeName + " is unreachable")
 			}
+			if reply[0] != '0' {
+				return append(fragments, f), errors.New(reply[2:])
+			}
+			f.nodes = append(f.nodes, nodeName)
 		}
+		fragments = append(fragments, f)
 	}
+	return fragments, nil
 }
 
+// FragmentWrite inserts a row into a distributed table, each fragment of the table taking the columns and the rows
+// it is defined with. A client retrying a write whose reply it did not receive gives the token of the write, which
+// inserts the row only once, see InsertOnce.
+// params: tableName string, row Row, token string (optional)
 func (c *Cluster) FragmentWrite(params []interface{}, reply *string) {
+	token := ""
+	if len(params) > 2 {
+		token = params[2].(string)
+	}
+	if err := c.InsertOnce(params[0].(string), params[1].(Row), token); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// Insert inserts a row into a distributed table like FragmentWrite does, a row shorter than the schema taking the
+// defaults of the missing columns. Each value is converted into the canonical Go type of its column before the row is
+// sent to the fragments, and a row having a value that does not conform to the type of its column is rejected.
+// The row is routed by the coordinator (see Route), and each fragment it is routed to receives only its columns, on its
+// replicas only. The row is given the next id of the table, and is read only once each of the fragments stores it on
+// at least one replica; otherwise the row is removed from the fragments that stored it and an error is returned.
+// A *ConstraintError is returned if the row violates a constraint of the table, and a *RoutingError if it cannot be
+// routed, in which case nothing is written. While the table is repartitioned, the row must also be routable to the new
+// fragments, which it is written to as well.
+func (c *Cluster) Insert(tableName string, row Row) error {
+	return c.InsertOnce(tableName, row, "")
+}
+
+// InsertOnce inserts a row into a distributed table like Insert does, once for a given token, e.g., the token a client
+// gives to a write and to its retries: the row is not inserted again if a row was inserted with the token, even if it
+// has since been removed, and an error is returned while the row is being inserted. A write that fails may be retried
+// with its token. An empty token is not remembered, and only the last maxWriteTokens tokens of a table are.
+func (c *Cluster) InsertOnce(tableName string, row Row, token string) error {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+	c.mu.Lock()
+	schema, ok := c.tableSchemas[tableName]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("no such table %s", tableName)
+	}
+	if done, err := c.checkToken(tableName, token); done {
+		c.mu.Unlock()
+		return err
+	}
+	id := c.reserveId(tableName)
+	prepared, fragments, keys, err := c.prepareRow(schema, row, id)
+	if err != nil {
+		c.releaseId(tableName, id)
+	} else if token != "" {
+		c.tableIds[tableName].remember(token, id)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the replicas that stored the row, a node that is down simply misses the row, and copies it from the other
+	// replicas after a restart
+	stored := make(map[string][]string)
+	var missed *fragment
+	for i := range fragments {
+		fragmentRow := fragments[i].project(schema, prepared, id)
+		for _, nodeId := range fragments[i].nodes {
+			replyMsg := ""
+			if c.nodeEnd(nodeId).Call("Node.RPCInsert", []interface{}{fragments[i].name, fragmentRow}, &replyMsg) &&
+				replyMsg[0] == '0' {
+				stored[fragments[i].name] = append(stored[fragments[i].name], nodeId)
+			}
+		}
+		if len(stored[fragments[i].name]) == 0 {
+			missed = &fragments[i]
+			break
+		}
+	}
+	if missed != nil {
+		c.discardRow(tableName, id, keys, stored, token)
+		return fmt.Errorf("Not Insert: no replica of %s stored the row", missed.name)
+	}
+	c.mu.Lock()
+	c.commitId(tableName, id, true)
+	c.mu.Unlock()
+	return nil
+}
+
+// discardRow removes a row that is not inserted from the replicas that stored it, and releases its keys and its id,
+// and the token of its write if it is not empty.
+func (c *Cluster) discardRow(tableName string, id int64, keys []string, stored map[string][]string, token string) {
+	missed := make([]replica, 0)
+	for name, nodeIds := range stored {
+		for _, nodeId := range nodeIds {
+			replyMsg := ""
+			if !c.nodeEnd(nodeId).Call("Node.RPCDeleteRows", []interface{}{name, []int64{id}}, &replyMsg) ||
+				replyMsg[0] != '0' {
+				missed = append(missed, replica{name, nodeId})
+			}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range missed {
+		c.markRemoved(tableName, []int64{id}, r)
+	}
+	c.releaseKeys(tableName, keys)
+	c.commitId(tableName, id, false)
+	if ids, ok := c.tableIds[tableName]; ok && token != "" {
+		ids.remember(token, 0)
+	}
+}
+
+// prepareRow completes, normalizes and checks a row to insert into a table, routes it, and reserves its keys for the
+// given id. It returns the row and the fragments it is routed to, and the reserved keys, to be released if the row is
+// not stored in the end. The caller must hold c.mu.
+func (c *Cluster) prepareRow(schema *TableSchema, row Row, id int64) (Row, []fragment, []string, error) {
+	tableName := schema.TableName
+	row, err := schema.completeRow(row)
+	if err == nil {
+		row, err = schema.normalizeRow(row)
+	}
+	if err == nil {
+		err = schema.checkColumns(row)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fragments, err := c.routeLocked(tableName, row)
+	if pending := c.pendingLayouts[tableName]; err == nil && pending != nil && pending.fragments != nil {
+		// the row is written to the new fragments as well, so that no row is lost at the switch
+		var extra []fragment
+		if extra, err = route(schema, pending.fragments, pending.hashing, row); err == nil {
+			fragments = append(fragments, extra...)
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys, err := c.reserveKeys(tableName, row, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the keys of the row are reserved first, so that a row may reference itself
+	if err := c.checkForeignKeys(tableName, row); err != nil {
+		c.releaseKeys(tableName, keys)
+		return nil, nil, nil, err
+	}
+	return row, fragments, keys, nil
+}
+
+// CreateIndex creates an index on a column of a distributed table, on every replica of the fragments holding the
+// column. The kind of the index is "hash" or "btree", see ParseIndexKind.
+// params: tableName string, column string, kind string
+func (c *Cluster) CreateIndex(params []interface{}, reply *string) {
 	tableName := params[0].(string)
-	row := params[1].(Row)
-	uuid := uuid.New().String()
-	c.tableName2id[tableName] = append(c.tableName2id[tableName], uuid)
-	row = append(row, uuid)
-	*reply = "1 Not Insert"
+	column := params[1].(string)
+	kind, err := ParseIndexKind(params[2].(string))
+	if err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	c.mu.RLock()
+	_, ok := c.tableSchemas[tableName]
+	fragments := append([]fragment(nil), c.tableFragments[tableName]...)
+	c.mu.RUnlock()
+	if !ok {
+		*reply = "1 no such table"
+		return
+	}
 
-	endNamePrefix := "InternalClient"
-	for _, nodeId := range c.nodeIds {
-		endName := endNamePrefix + nodeId
-		end := c.network.MakeEnd(endName)
-		c.network.Connect(endName, nodeId)
-		c.network.Enable(endName, true)
-		replyMsg := ""
-		for i := 0; i < c.tableName2num[tableName]; i++ {
-			end.Call("Node.RPCInsert", []interface{}{tableName + "|" + strconv.Itoa(i), row}, &replyMsg)
-			if replyMsg[0] == '0' {
-				*reply = "0 OK"
+	*reply = "1 no such column " + column
+	for _, f := range fragments {
+		// every fragment holds the ids
+		if column != idColumnName && !containsString(f.columns, column) {
+			continue
+		}
+		for _, nodeId := range f.nodes {
+			replyMsg := ""
+			if !c.nodeEnd(nodeId).Call("Node.RPCCreateIndex", []interface{}{f.name, column, kind}, &replyMsg) {
+				*reply = "1 " + nodeId + " is unreachable"
+				return
+			}
+			if replyMsg[0] != '0' {
+				*reply = replyMsg
+				return
 			}
+			*reply = "0 OK"
 		}
 	}
 }
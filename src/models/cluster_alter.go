@@ -0,0 +1,307 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the alterations of AlterTable
+const (
+	AlterAddColumn  = "ADD COLUMN"
+	AlterDropColumn = "DROP COLUMN"
+)
+
+// AlterTable adds a column to a distributed table or drops one of its columns, see AddColumn and DropColumn.
+// params: tableName string, action string (AlterAddColumn or AlterDropColumn), column ColumnSchema (only the name is
+// used to drop a column), alongside string (optional, see AddColumn)
+func (c *Cluster) AlterTable(params []interface{}, reply *string) {
+	tableName := params[0].(string)
+	column := params[2].(ColumnSchema)
+	var err error
+	switch action := params[1].(string); action {
+	case AlterAddColumn:
+		alongside := ""
+		if len(params) > 3 {
+			alongside = params[3].(string)
+		}
+		err = c.AddColumn(tableName, column, alongside)
+	case AlterDropColumn:
+		err = c.DropColumn(tableName, column.Name)
+	default:
+		err = errors.New("unknown alteration " + action)
+	}
+	if err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// AddColumn appends a column to the schema of a distributed table. The rows already in the table take the default of
+// the column, and are checked against its NOT NULL and CHECK constraints, so that a column rejecting its default can
+// only be added to an empty table. The column is held by the fragments holding the column alongside, or the first
+// column of the table if alongside is empty, so that each row still has each column in exactly one of the fragments
+// it is routed to; every fragment of a table partitioned by hash holds it. Writes are blocked during the alteration.
+func (c *Cluster) AddColumn(tableName string, column ColumnSchema, alongside string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	schema, fragments, hashed, err := c.alterableTable(tableName)
+	if err != nil {
+		return err
+	}
+	if column.Name == "" || column.Name == idColumnName || schema.ColumnIndex(column.Name) >= 0 {
+		return fmt.Errorf("cannot add column %q to %s", column.Name, tableName)
+	}
+	if column.Default, err = NormalizeValue(column.Default, column.DataType); err != nil {
+		return fmt.Errorf("the default of %s: %v", column.Name, err)
+	}
+	if alongside == "" {
+		alongside = schema.ColumnSchemas[0].Name
+	}
+	if schema.ColumnIndex(alongside) < 0 {
+		return fmt.Errorf("no such column %s in %s", alongside, tableName)
+	}
+	declared := *schema
+	declared.ColumnSchemas = append(append([]ColumnSchema(nil), schema.ColumnSchemas...), column)
+	if err := declared.bindChecks(); err != nil {
+		return err
+	}
+	rows, err := c.scanRows(tableName)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := declared.checkColumns(append(append(Row(nil), row[:len(schema.ColumnSchemas)]...),
+			column.Default)); err != nil {
+			return err
+		}
+	}
+
+	altered := make([]fragment, len(fragments))
+	for i, f := range fragments {
+		altered[i] = f
+		if hashed || containsString(f.columns, alongside) {
+			altered[i].columns = append(append([]string(nil), f.columns...), column.Name)
+		}
+	}
+	return c.alterFragments(schema, fragments, &declared, altered)
+}
+
+// DropColumn removes a column from the schema of a distributed table and from the fragments holding it, together
+// with its values. A column cannot be dropped if it is the only column of the table, if it is in a key of the table,
+// a foreign key or the partition key, or if a CHECK of another column or a partition rule refers to it. Writes are
+// blocked during the alteration.
+func (c *Cluster) DropColumn(tableName string, column string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	schema, fragments, _, err := c.alterableTable(tableName)
+	if err != nil {
+		return err
+	}
+	if schema.ColumnIndex(column) < 0 {
+		return fmt.Errorf("no such column %s in %s", column, tableName)
+	}
+	if err := c.checkDroppable(schema, fragments, column); err != nil {
+		return fmt.Errorf("cannot drop column %s of %s: %v", column, tableName, err)
+	}
+
+	declared := *schema
+	declared.ColumnSchemas = make([]ColumnSchema, 0, len(schema.ColumnSchemas)-1)
+	for _, cs := range schema.ColumnSchemas {
+		if cs.Name != column {
+			declared.ColumnSchemas = append(declared.ColumnSchemas, cs)
+		}
+	}
+	if err := declared.bindChecks(); err != nil {
+		return err
+	}
+	altered := make([]fragment, len(fragments))
+	for i, f := range fragments {
+		altered[i] = f
+		altered[i].columns = make([]string, 0, len(f.columns))
+		for _, name := range f.columns {
+			if name != column {
+				altered[i].columns = append(altered[i].columns, name)
+			}
+		}
+	}
+	return c.alterFragments(schema, fragments, &declared, altered)
+}
+
+// alterableTable returns the schema and the fragments of a table, and whether it is partitioned by hash, or an error
+// if there is no such table or it is being repartitioned.
+func (c *Cluster) alterableTable(tableName string) (*TableSchema, []fragment, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	schema, ok := c.tableSchemas[tableName]
+	if !ok {
+		return nil, nil, false, fmt.Errorf("no such table %s", tableName)
+	}
+	if _, busy := c.pendingLayouts[tableName]; busy {
+		return nil, nil, false, fmt.Errorf("cannot alter %s while it is repartitioned", tableName)
+	}
+	return schema, append([]fragment(nil), c.tableFragments[tableName]...), c.tableHashing[tableName] != nil, nil
+}
+
+// checkDroppable returns why a column of a table cannot be dropped, or nil if it can, see DropColumn.
+func (c *Cluster) checkDroppable(schema *TableSchema, fragments []fragment, column string) error {
+	if len(schema.ColumnSchemas) == 1 {
+		return errors.New("it is the only column")
+	}
+	if containsString(schema.PrimaryKey, column) {
+		return errors.New("it is in the " + ConstraintPrimaryKey)
+	}
+	for _, group := range schema.Unique {
+		if containsString(group, column) {
+			return errors.New("it is in a " + ConstraintUnique + " constraint")
+		}
+	}
+	for _, fk := range schema.ForeignKeys {
+		if containsString(fk.Columns, column) {
+			return errors.New("it is in a " + ConstraintForeignKey)
+		}
+	}
+	for _, cs := range schema.ColumnSchemas {
+		if _, ok := cs.check()[column]; ok && cs.Name != column {
+			return fmt.Errorf("the %s of %s refers to it", ConstraintCheck, cs.Name)
+		}
+	}
+	for _, f := range fragments {
+		if containsString(f.predicate.columns(), column) {
+			return fmt.Errorf("the partition rule of %s refers to it", f.name)
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if hashing := c.tableHashing[schema.TableName]; hashing != nil && containsString(hashing.Key, column) {
+		return errors.New("it is in the partition key")
+	}
+	for childName, child := range c.tableSchemas {
+		for _, fk := range child.ForeignKeys {
+			refColumns := fk.RefColumns
+			if len(refColumns) == 0 {
+				refColumns = schema.PrimaryKey
+			}
+			if fk.RefTable == schema.TableName && containsString(refColumns, column) {
+				return fmt.Errorf("a %s of %s refers to it", ConstraintForeignKey, childName)
+			}
+		}
+	}
+	return nil
+}
+
+// alterFragments rewrites every replica of the fragments of a table from the old declared schema and fragments to the
+// new ones, which differ in their columns only, and then switches the catalog to them. The caller must hold writeMu
+// exclusively. Every replica must be reachable; if one of them fails to be rewritten, the replicas already rewritten
+// are rewritten back, and the catalog is left as it was. The rows of a replica losing a column are read before it is
+// rewritten, so that the values of the column are restored then.
+func (c *Cluster) alterFragments(schema *TableSchema, fragments []fragment, declared *TableSchema,
+	altered []fragment) error {
+	for _, f := range fragments {
+		for _, nodeId := range f.nodes {
+			reply := ""
+			if !c.nodeEnd(nodeId).Call("Node.Heartbeat", "", &reply) {
+				return fmt.Errorf("cannot alter %s while %s is unreachable", schema.TableName, nodeId)
+			}
+		}
+	}
+
+	type replica struct {
+		f      int
+		nodeId string
+	}
+	done := make([]replica, 0)
+	saved := make(map[replica][]Row)
+	var err error
+	for i, f := range altered {
+		for _, nodeId := range f.nodes {
+			r := replica{i, nodeId}
+			if len(f.columns) < len(fragments[i].columns) {
+				if saved[r], err = c.replicaRows(f.name, nodeId); err != nil {
+					break
+				}
+			}
+			if err = c.alterReplica(declared, f, nodeId); err != nil {
+				break
+			}
+			done = append(done, r)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		for _, r := range done {
+			if c.alterReplica(schema, fragments[r.f], r.nodeId) == nil && saved[r] != nil {
+				c.mergeRows(fragments[r.f].name, r.nodeId, saved[r])
+			}
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.tableSchemas[schema.TableName] = declared
+	c.tableFragments[schema.TableName] = altered
+	c.mu.Unlock()
+	return nil
+}
+
+// alterReplica rewrites the replica of a fragment on a node to the columns of the fragment, in the types given by the
+// declared schema of the table.
+func (c *Cluster) alterReplica(declared *TableSchema, f fragment, nodeId string) error {
+	fullSchema := *declared
+	fullSchema.ColumnSchemas = append(append([]ColumnSchema(nil), declared.ColumnSchemas...),
+		ColumnSchema{Name: idColumnName, DataType: idDataType})
+	schema := TableSchema{TableName: f.name, ColumnSchemas: []ColumnSchema{{Name: idColumnName, DataType: idDataType}}}
+	for _, column := range f.columns {
+		schema.ColumnSchemas = append(schema.ColumnSchemas, declared.ColumnSchemas[declared.ColumnIndex(column)])
+	}
+	reply := ""
+	if !c.nodeEnd(nodeId).Call("Node.RPCAlterTable", []interface{}{schema, fullSchema}, &reply) {
+		return errors.New(nodeId + " is unreachable")
+	}
+	if reply[0] != '0' {
+		return fmt.Errorf("cannot alter %s on %s: %s", f.name, nodeId, reply[2:])
+	}
+	return nil
+}
+
+// replicaRows returns all the rows of the replica of a fragment on a node, read one range of ids at a time.
+func (c *Cluster) replicaRows(fragment string, nodeId string) ([]Row, error) {
+	end := c.nodeEnd(nodeId)
+	rows := make([]Row, 0)
+	for after := int64(0); ; {
+		batch := Dataset{}
+		if !end.Call("Node.RPCScanBatch", []interface{}{fragment, after, transferBatchSize}, &batch) {
+			return nil, errors.New(nodeId + " is unreachable")
+		}
+		if batch.Schema.TableName == "" {
+			return nil, fmt.Errorf("%s does not hold %s", nodeId, fragment)
+		}
+		rows = append(rows, batch.Rows...)
+		if len(batch.Rows) < transferBatchSize {
+			return rows, nil
+		}
+		after = rowId(batch.Rows[len(batch.Rows)-1][0])
+	}
+}
+
+// mergeRows merges rows into the replica of a fragment on a node, see Table.MergeRows, a batch of rows at a time.
+func (c *Cluster) mergeRows(fragment string, nodeId string, rows []Row) error {
+	end := c.nodeEnd(nodeId)
+	for start := 0; start < len(rows); start += transferBatchSize {
+		batch := rows[start:]
+		if len(batch) > transferBatchSize {
+			batch = batch[:transferBatchSize]
+		}
+		reply := ""
+		if !end.Call("Node.RPCMergeRows", []interface{}{fragment, batch}, &reply) {
+			return errors.New(nodeId + " is unreachable")
+		}
+		if reply[0] != '0' {
+			return fmt.Errorf("cannot merge rows into %s on %s: %s", fragment, nodeId, reply[2:])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestAlterTable(t *testing.T) {
+	c, _, cli := newTestCluster(3, "Alter")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "grade", DataType: TypeDouble},
+	}, PrimaryKey: []string{"sid"}}
+	reply := ""
+	if err := buildTestTable(cli, schema, []byte(`{
+		"0": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "name"]},
+		"1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["grade"]},
+		"2|0": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "name", "grade"]}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := c.Insert("student", Row{i, "s", float64(i)}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+
+	// the rows already in the table take the default, and the column is held alongside grade
+	age := ColumnSchema{Name: "age", DataType: TypeInt32, NotNull: true, Default: 18}
+	cli.Call("Cluster.AlterTable", []interface{}{"student", AlterAddColumn, age, "grade"}, &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot add column: %v", reply)
+	}
+	for _, f := range c.tableFragments["student"] {
+		if holds := containsString(f.columns, "grade"); holds != containsString(f.columns, "age") {
+			t.Errorf("expected age alongside grade, actual %v in %s", f.columns, f.name)
+		}
+		for _, nodeId := range f.nodes {
+			dataset := Dataset{}
+			c.nodes[nodeId].ScanTable(f.name, &dataset)
+			if len(dataset.Schema.ColumnSchemas) != len(f.columns)+1 {
+				t.Errorf("expected the columns %v in %s on %s, actual %v", f.columns, f.name, nodeId,
+					dataset.Schema.ColumnSchemas)
+			}
+		}
+	}
+	if err := c.Insert("student", Row{6, "s", 1.0, 20}); err != nil {
+		t.Fatalf("cannot insert a row with the new column: %v", err)
+	}
+	selected, err := c.Select("student", Predicate{"age": {{Op: "=", Val: 18}}})
+	if err != nil || len(selected.Rows) != 6 || len(selected.Schema.ColumnSchemas) != 4 {
+		t.Errorf("expected the 6 old rows with age 18, actual %v %v (%v)", selected.Schema.ColumnSchemas,
+			selected.Rows, err)
+	}
+	if selected, err = c.Select("student", Predicate{"age": {{Op: "=", Val: 20}}}); err != nil ||
+		len(selected.Rows) != 1 {
+		t.Errorf("expected the new row with age 20, actual %v (%v)", selected.Rows, err)
+	}
+
+	for _, invalid := range []ColumnSchema{
+		{Name: "age", DataType: TypeInt32},
+		{Name: "id", DataType: TypeString},
+		// the rows already in the table would violate the constraint
+		{Name: "email", DataType: TypeString, NotNull: true},
+		{Name: "rank", DataType: TypeInt32, Default: "first"},
+	} {
+		cli.Call("Cluster.AlterTable", []interface{}{"student", AlterAddColumn, invalid}, &reply)
+		if reply == "0 OK" {
+			t.Errorf("column %v should not be added", invalid)
+		}
+	}
+	cli.Call("Cluster.AlterTable", []interface{}{"student", AlterAddColumn, ColumnSchema{Name: "email",
+		DataType: TypeString}, "unknown"}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a column should not be added alongside an unknown column")
+	}
+
+	// the columns that keys or rules refer to cannot be dropped
+	for _, column := range []string{"sid", "grade", "unknown"} {
+		cli.Call("Cluster.AlterTable", []interface{}{"student", AlterDropColumn, ColumnSchema{Name: column}}, &reply)
+		if reply == "0 OK" {
+			t.Errorf("column %s should not be dropped", column)
+		}
+	}
+	cli.Call("Cluster.AlterTable", []interface{}{"student", AlterDropColumn, ColumnSchema{Name: "name"}}, &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot drop column: %v", reply)
+	}
+	if selected, err = c.Select("student", Predicate{}); err != nil || len(selected.Rows) != 7 ||
+		selected.Schema.ColumnIndex("name") >= 0 || selected.Rows[0][2] != int32(18) {
+		t.Errorf("expected 7 rows without name, actual %v %v (%v)", selected.Schema.ColumnSchemas, selected.Rows,
+			err)
+	}
+	if err := c.Insert("student", Row{7, 4.0}); err != nil {
+		t.Errorf("cannot insert after the drop: %v", err)
+	}
+
+	course := TableSchema{TableName: "course", ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}},
+		ForeignKeys: []ForeignKey{{Columns: []string{"sid"}, RefTable: "student"}}}
+	if err := buildTestTable(cli, course, []byte(`{"1": {"predicate": {}, "column": ["sid"]}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DropColumn("course", "sid"); err == nil {
+		t.Errorf("the only column of a table should not be dropped")
+	}
+}
+
+func TestAlterTableRollback(t *testing.T) {
+	c, _, cli := setupRecoveryCluster(t, "", "memory")
+	for i := 0; i < 3; i++ {
+		if err := c.Insert("student", Row{i, "s" + string(rune('0'+i))}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+
+	// Node1 lost its replica, the replica on Node0 is rewritten back with the values of the dropped column
+	if err := c.nodes["Node1"].dropTable("student|0"); err != nil {
+		t.Fatal(err.Error())
+	}
+	reply := ""
+	cli.Call("Cluster.AlterTable", []interface{}{"student", AlterDropColumn, ColumnSchema{Name: "name"}}, &reply)
+	if reply == "0 OK" {
+		t.Fatalf("the column should not be dropped without the replica on Node1")
+	}
+	dataset := Dataset{}
+	c.nodes["Node0"].ScanTable("student|0", &dataset)
+	if len(dataset.Rows) != 3 || len(dataset.Schema.ColumnSchemas) != 3 {
+		t.Fatalf("expected 3 rows with the name, actual %v %v", dataset.Schema.ColumnSchemas, dataset.Rows)
+	}
+	for i, row := range dataset.Rows {
+		if row[2] != "s"+string(rune('0'+i)) {
+			t.Errorf("the name of row %d should be restored, actual %v", i, row)
+		}
+	}
+}
+
+func TestAlterDurableTable(t *testing.T) {
+	c, _, cli := setupRecoveryCluster(t, t.TempDir(), "durable")
+	for i := 0; i < 3; i++ {
+		if err := c.Insert("student", Row{i, "s"}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+	reply := ""
+	cli.Call("Cluster.AlterTable", []interface{}{"student", AlterAddColumn,
+		ColumnSchema{Name: "age", DataType: TypeInt32, Default: 18}}, &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot add column: %v", reply)
+	}
+	if err := c.Insert("student", Row{3, "s", 20}); err != nil {
+		t.Fatalf("cannot insert a row with the new column: %v", err)
+	}
+
+	// the rewritten files are recovered with the new column
+	cli.Call("Cluster.RestartNode", "Node0", &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot restart node: %v", reply)
+	}
+	dataset := Dataset{}
+	c.nodes["Node0"].ScanTable("student|0", &dataset)
+	if len(dataset.Rows) != 4 || len(dataset.Schema.ColumnSchemas) != 4 || dataset.Rows[0][3] != int32(18) ||
+		dataset.Rows[3][3] != int32(20) {
+		t.Errorf("expected 4 rows with the age, actual %v %v", dataset.Schema.ColumnSchemas, dataset.Rows)
+	}
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// how many rows BulkInsert checks and sends to the nodes at a time
+const bulkBatchSize = 4096
+
+// BulkInsertResult is the outcome of BulkInsert.
+type BulkInsertResult struct {
+	// the number of rows inserted
+	Inserted int
+	// the rows that are not inserted, in order
+	Failures []RowFailure
+}
+
+// RowFailure is a row rejected by BulkInsert.
+type RowFailure struct {
+	// the position of the row in the given rows
+	Row    int
+	Reason string
+}
+
+// BulkInsert inserts many rows into a distributed table, each of them like FragmentWrite does, see InsertRowsOnce.
+// params: tableName string, rows []Row, token string (optional)
+func (c *Cluster) BulkInsert(params []interface{}, reply *BulkInsertResult) {
+	token := ""
+	if len(params) > 2 {
+		token = params[2].(string)
+	}
+	*reply = c.InsertRowsOnce(params[0].(string), params[1].([]Row), token)
+}
+
+// InsertRows inserts rows into a distributed table like Insert does, bulkBatchSize rows at a time: the rows of a batch
+// are checked and routed at once, and then each node receives the rows of the batch for all its fragments in a single
+// RPC. Each row is inserted or rejected on its own; a row is rejected if it violates a constraint of the table,
+// including with the rows before it, if it cannot be routed, or if a fragment it is routed to stores it on no replica,
+// in which case it is removed from the other fragments, and so are the rows after it referencing it by a foreign key.
+// The inserted rows keep their order.
+func (c *Cluster) InsertRows(tableName string, rows []Row) BulkInsertResult {
+	return c.InsertRowsOnce(tableName, rows, "")
+}
+
+// InsertRowsOnce inserts rows into a distributed table like InsertRows does, once for a given token: each row is
+// inserted like InsertOnce does, with the token followed by "/" and the position of the row, so that the rows inserted
+// by a call are counted as inserted by a call with the same token, and the others are inserted.
+func (c *Cluster) InsertRowsOnce(tableName string, rows []Row, token string) BulkInsertResult {
+	result := BulkInsertResult{Failures: make([]RowFailure, 0)}
+	for start := 0; start < len(rows); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		c.insertBatch(tableName, rows[start:end], start, token, &result)
+	}
+	return result
+}
+
+// bulkRow is a row of a batch of InsertRows that passed the checks.
+type bulkRow struct {
+	// the position of the row in the rows given to InsertRows
+	position int
+	// the row as it is checked, and its id
+	row       Row
+	id        int64
+	token     string
+	keys      []string
+	fragments []fragment
+	// the fragments that stored the row on some replica, and these replicas
+	stored map[string][]string
+}
+
+// nodeBatch is what a node receives from a batch of InsertRows: the rows of each of its fragments, and the bulk rows
+// they come from.
+type nodeBatch struct {
+	tableNames []string
+	rows       [][]Row
+	owners     [][]int
+}
+
+func (b *nodeBatch) add(tableName string, fragmentRow Row, owner int) {
+	i := 0
+	for i < len(b.tableNames) && b.tableNames[i] != tableName {
+		i++
+	}
+	if i == len(b.tableNames) {
+		b.tableNames = append(b.tableNames, tableName)
+		b.rows = append(b.rows, nil)
+		b.owners = append(b.owners, nil)
+	}
+	b.rows[i] = append(b.rows[i], fragmentRow)
+	b.owners[i] = append(b.owners[i], owner)
+}
+
+// insertBatch inserts a batch of InsertRowsOnce, the first row of the batch being at the given position in all the
+// rows.
+func (c *Cluster) insertBatch(tableName string, rows []Row, first int, token string, result *BulkInsertResult) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+	failures := make([]RowFailure, 0)
+	accepted := make([]bulkRow, 0, len(rows))
+	// the rows inserted, including by an earlier call with the same token
+	inserted := 0
+	batches := make(map[string]*nodeBatch)
+	c.mu.Lock()
+	schema, ok := c.tableSchemas[tableName]
+	for i, row := range rows {
+		if !ok {
+			failures = append(failures, RowFailure{Row: first + i, Reason: "no such table " + tableName})
+			continue
+		}
+		rowToken := ""
+		if token != "" {
+			rowToken = token + "/" + strconv.Itoa(first+i)
+		}
+		if seen, err := c.checkToken(tableName, rowToken); seen {
+			if err != nil {
+				failures = append(failures, RowFailure{Row: first + i, Reason: err.Error()})
+			} else {
+				inserted++
+			}
+			continue
+		}
+		id := c.reserveId(tableName)
+		row, fragments, keys, err := c.prepareRow(schema, row, id)
+		if err != nil {
+			c.releaseId(tableName, id)
+			failures = append(failures, RowFailure{Row: first + i, Reason: err.Error()})
+			continue
+		}
+		if rowToken != "" {
+			c.tableIds[tableName].remember(rowToken, id)
+		}
+		for _, f := range fragments {
+			fragmentRow := f.project(schema, row, id)
+			for _, nodeId := range f.nodes {
+				if batches[nodeId] == nil {
+					batches[nodeId] = &nodeBatch{}
+				}
+				batches[nodeId].add(f.name, fragmentRow, len(accepted))
+			}
+		}
+		accepted = append(accepted, bulkRow{position: first + i, row: row, id: id, token: rowToken, keys: keys,
+			fragments: fragments, stored: make(map[string][]string)})
+	}
+	// the foreign keys of the table referencing the table itself, whose keys may be those of the rows of the batch
+	selfReferences := make([]foreignKey, 0)
+	if ok {
+		fks, _ := schema.foreignKeys(c.lookupSchema)
+		for _, fk := range fks {
+			if fk.RefTable == tableName {
+				selfReferences = append(selfReferences, fk)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	// the nodes are sent their rows in parallel, a node that is down simply misses the rows like with Insert
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for nodeId, batch := range batches {
+		wg.Add(1)
+		go func(nodeId string, batch *nodeBatch) {
+			defer wg.Done()
+			results := make([][]string, 0)
+			if !c.nodeEnd(nodeId).Call("Node.RPCInsertBatch", []interface{}{batch.tableNames, batch.rows},
+				&results) || len(results) != len(batch.tableNames) {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for i, tableName := range batch.tableNames {
+				for j, owner := range batch.owners[i] {
+					if j < len(results[i]) && results[i][j] == "" {
+						accepted[owner].stored[tableName] = append(accepted[owner].stored[tableName], nodeId)
+					}
+				}
+			}
+		}(nodeId, batch)
+	}
+	wg.Wait()
+
+	// the keys of the rows that are not inserted, so that the rows after them referencing them are not either
+	discarded := make(map[int]map[string]bool)
+	for _, r := range accepted {
+		reason := ""
+		for _, f := range r.fragments {
+			if len(r.stored[f.name]) == 0 {
+				reason = fmt.Sprintf("no replica of %s stored the row", f.name)
+				break
+			}
+		}
+		for i := 0; i < len(selfReferences) && reason == ""; i++ {
+			if values := selfReferences[i].values(r.row); discarded[selfReferences[i].refKey][encodeKey(values)] {
+				reason = (&ConstraintError{Table: tableName, Constraint: ConstraintForeignKey,
+					Columns: selfReferences[i].Columns, Values: values, Reason: "referenced row not inserted"}).Error()
+			}
+		}
+		if reason == "" {
+			c.mu.Lock()
+			c.commitId(tableName, r.id, true)
+			c.mu.Unlock()
+			inserted++
+			continue
+		}
+		c.discardRow(tableName, r.id, r.keys, r.stored, r.token)
+		for i, key := range r.keys {
+			if key == "" {
+				continue
+			}
+			if discarded[i] == nil {
+				discarded[i] = make(map[string]bool)
+			}
+			discarded[i][key] = true
+		}
+		failures = append(failures, RowFailure{Row: r.position, Reason: reason})
+	}
+
+	sortFailures(failures)
+	result.Inserted += inserted
+	result.Failures = append(result.Failures, failures...)
+}
+
+// sortFailures sorts failures by the positions of their rows.
+func sortFailures(failures []RowFailure) {
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Row < failures[j].Row })
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBulkInsert(t *testing.T) {
+	c, network, cli := newTestCluster(3, "Bulk")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "grade", DataType: TypeDouble},
+	}, PrimaryKey: []string{"sid"}}
+	rules := []byte(`{
+		"0": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "name"]},
+		"1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["grade"]},
+		"2": {"predicate": {"grade": [{"op": ">=", "val": 3}, {"op": "<", "val": 5}]},
+			"column": ["sid", "name", "grade"]}
+	}`)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+
+	// each bad row is reported with its position, and the other rows are inserted
+	rows := []Row{
+		{1, "John", 2.0},
+		{2, "Mary", 4.0},
+		{1, "Jack", 1.0},
+		{3, "Lucy", "four"},
+		{4, "Tom", 7.0},
+		{5, "Anna"},
+	}
+	result := BulkInsertResult{}
+	cli.Call("Cluster.BulkInsert", []interface{}{"student", rows, "load"}, &result)
+	if result.Inserted != 2 || len(result.Failures) != 4 {
+		t.Fatalf("expected 2 rows inserted and 4 failures, actual %+v", result)
+	}
+	for i, position := range []int{2, 3, 4, 5} {
+		if result.Failures[i].Row != position || result.Failures[i].Reason == "" {
+			t.Errorf("expected failure %d on row %d, actual %+v", i, position, result.Failures[i])
+		}
+	}
+	// a single RPC per node for a batch
+	before := make(map[string]int)
+	for _, nodeId := range c.nodeIds {
+		before[nodeId] = network.GetCount(nodeId)
+	}
+	result = c.InsertRows("student", []Row{{10, "Jack", 1.0}, {11, "Lucy", 4.0}, {12, "Tom", 2.5}})
+	if result.Inserted != 3 {
+		t.Fatalf("expected 3 rows inserted, actual %+v", result)
+	}
+	for _, nodeId := range c.nodeIds {
+		if calls := network.GetCount(nodeId) - before[nodeId]; calls != 1 {
+			t.Errorf("expected a single RPC to %s, actual %d", nodeId, calls)
+		}
+	}
+	selected, err := c.Select("student", Predicate{})
+	if err != nil || len(selected.Rows) != 5 {
+		t.Errorf("expected 5 rows, actual %v %v", selected.Rows, err)
+	}
+	// the rows inserted by a call are not inserted again by a retry with the same token, but are rejected without it
+	if result = c.InsertRowsOnce("student", rows, "load"); result.Inserted != 2 || len(result.Failures) != 4 ||
+		c.rowCount("student") != 5 {
+		t.Errorf("expected the rows inserted again, actual %+v", result)
+	}
+	if result = c.InsertRows("student", rows[:2]); result.Inserted != 0 || len(result.Failures) != 2 {
+		t.Errorf("expected the duplicate rows rejected, actual %+v", result)
+	}
+
+	// the rows routed to a fragment with no live replica are rejected and removed from the other fragments
+	network.DeleteServer(c.nodeIds[0])
+	result = c.InsertRows("student", []Row{{6, "Bob", 3.5}, {7, "Eve", 1.5}, {8, "Zoe", 4.5}})
+	if result.Inserted != 2 || len(result.Failures) != 1 || result.Failures[0].Row != 1 ||
+		!strings.Contains(result.Failures[0].Reason, "no replica") {
+		t.Errorf("expected the row of the dead node rejected, actual %+v", result)
+	}
+	if result = c.InsertRows("student", []Row{{7, "Eve", 4.0}}); result.Inserted != 1 {
+		t.Errorf("the key of a rejected row should be released, actual %+v", result)
+	}
+	if result = c.InsertRows("teacher", []Row{{1}}); result.Inserted != 0 || len(result.Failures) != 1 {
+		t.Errorf("expected the rows of an unknown table rejected, actual %+v", result)
+	}
+}
+
+func TestBulkInsertBatches(t *testing.T) {
+	c, network, cli := newTestCluster(2, "BulkBatch")
+	schema := TableSchema{TableName: "event", ColumnSchemas: []ColumnSchema{
+		{Name: "seq", DataType: TypeInt32},
+	}, PrimaryKey: []string{"seq"}}
+	rules := []byte(`{
+		"0": {"predicate": {"seq": [{"op": "<", "val": 5000}]}, "column": ["seq"]},
+		"1": {"predicate": {"seq": [{"op": ">=", "val": 5000}]}, "column": ["seq"]}
+	}`)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]Row, 10000)
+	for i := range rows {
+		rows[i] = Row{i}
+	}
+	before := network.GetTotalCount()
+	result := c.InsertRows("event", rows)
+	if result.Inserted != len(rows) || len(result.Failures) != 0 {
+		t.Fatalf("expected %d rows inserted, actual %d %v", len(rows), result.Inserted, result.Failures)
+	}
+	batches := (len(rows) + bulkBatchSize - 1) / bulkBatchSize
+	if calls := network.GetTotalCount() - before; calls > batches*len(c.nodeIds) {
+		t.Errorf("expected at most %d RPCs, actual %d", batches*len(c.nodeIds), calls)
+	}
+	if count := c.rowCount("event"); count != len(rows) {
+		t.Errorf("expected %d rows at the coordinator, actual %d", len(rows), count)
+	}
+	selected, err := c.Select("event", Predicate{"seq": {{Op: ">=", Val: 9990}}})
+	if err != nil || len(selected.Rows) != 10 {
+		t.Errorf("expected 10 rows, actual %d %v", len(selected.Rows), err)
+	}
+}
+
+func TestBulkInsertReferences(t *testing.T) {
+	c, _, cli := newTestCluster(1, "BulkReference")
+	schema := TableSchema{TableName: "employee", ColumnSchemas: []ColumnSchema{
+		{Name: "eid", DataType: TypeInt32},
+		{Name: "manager", DataType: TypeInt32},
+	}, PrimaryKey: []string{"eid"},
+		ForeignKeys: []ForeignKey{{Columns: []string{"manager"}, RefTable: "employee"}}}
+	rules := []byte(`{"0": {"predicate": {}, "column": ["eid", "manager"]}}`)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	// the only replica already holds another row with the id of the first row, so that it does not store it
+	reply := ""
+	if c.nodes["Node0"].RPCInsert([]interface{}{"employee|0", Row{int64(1), int32(9), nil}}, &reply); reply != "0 OK" {
+		t.Fatalf("cannot insert on Node0: %v", reply)
+	}
+
+	// the rows referencing a row of the batch that is not inserted are not either
+	result := c.InsertRows("employee", []Row{{1, nil}, {2, 1}, {3, nil}, {4, 3}, {5, 2}})
+	if result.Inserted != 2 || len(result.Failures) != 3 {
+		t.Fatalf("expected 2 rows inserted and 3 failures, actual %+v", result)
+	}
+	for i, position := range []int{0, 1, 4} {
+		if result.Failures[i].Row != position {
+			t.Errorf("expected failure %d on row %d, actual %+v", i, position, result.Failures[i])
+		}
+	}
+	if !strings.Contains(result.Failures[1].Reason, "referenced row not inserted") {
+		t.Errorf("expected a foreign key failure, actual %v", result.Failures[1].Reason)
+	}
+	selected, err := c.Select("employee", Predicate{"eid": {{Op: "<", Val: 9}}})
+	if err != nil || len(selected.Rows) != 2 {
+		t.Errorf("expected 2 rows, actual %v %v", selected.Rows, err)
+	}
+}
+
+func BenchmarkBulkInsert(b *testing.B) {
+	schema := TableSchema{TableName: "event", ColumnSchemas: []ColumnSchema{
+		{Name: "seq", DataType: TypeInt32},
+		{Name: "payload", DataType: TypeString},
+	}, PrimaryKey: []string{"seq"}}
+	rules := []byte(`{
+		"0": {"predicate": {"seq": [{"op": "<", "val": 500000}]}, "column": ["seq", "payload"]},
+		"1": {"predicate": {"seq": [{"op": ">=", "val": 500000}]}, "column": ["seq", "payload"]}
+	}`)
+	rows := make([]Row, 1000000)
+	for i := range rows {
+		rows[i] = Row{i, "payload"}
+	}
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		c, _, cli := newTestCluster(2, "BulkBenchmark")
+		if err := buildTestTable(cli, schema, rules); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if result := c.InsertRows("event", rows); result.Inserted != len(rows) {
+			b.Fatalf("expected %d rows inserted, actual %d %v", len(rows), result.Inserted, result.Failures)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"fmt"
+)
+
+// FragmentDelete deletes the rows of a distributed table satisfying the given predicate from all of its fragments.
+// The deletion is rejected as a whole if a deleted row is still referenced by a foreign key with OnDeleteRestrict,
+// and the referencing rows are deleted as well for a foreign key with OnDeleteCascade.
+// params: tableName string, predicate Predicate
+func (c *Cluster) FragmentDelete(params []interface{}, reply *string) {
+	if _, err := c.Delete(params[0].(string), params[1].(Predicate)); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// Delete deletes the rows of a distributed table satisfying the predicate like FragmentDelete does, and returns how
+// many rows of the table are deleted, not counting the rows deleted by cascade. A *ConstraintError is returned if a
+// deleted row is still referenced, in which case nothing is deleted.
+// Writes are blocked during the deletion, so that no row referencing a deleted row is inserted meanwhile. The deleted
+// rows are no longer read from any replica at once, and the replicas that are down or fail miss the deletion and
+// no longer serve the deleted rows until they catch up, see removeRows.
+func (c *Cluster) Delete(tableName string, predicate Predicate) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.RLock()
+	schemas := make(map[string]*TableSchema, len(c.tableSchemas))
+	for name, schema := range c.tableSchemas {
+		schemas[name] = schema
+	}
+	c.mu.RUnlock()
+	schema, ok := schemas[tableName]
+	if !ok {
+		return 0, fmt.Errorf("no such table %s", tableName)
+	}
+	predicate = predicate.clone()
+	if err := predicate.bind(schema.ColumnSchemas); err != nil {
+		return 0, err
+	}
+
+	d := &deletion{cluster: c, schemas: schemas, scanned: make(map[string][]Row), victims: make(map[string][]Row),
+		deleted: make(map[string]map[int64]bool)}
+	rows, err := d.scan(tableName)
+	if err != nil {
+		return 0, err
+	}
+	matched := make([]Row, 0)
+	for _, row := range rows {
+		if matchRow(schema.ColumnSchemas, row, predicate) {
+			matched = append(matched, row)
+		}
+	}
+	if err := d.add(tableName, matched); err != nil {
+		return 0, err
+	}
+	if err := c.removeRows(d.victims); err != nil {
+		return 0, err
+	}
+	return len(d.victims[tableName]), nil
+}
+
+// deletion collects the rows to be deleted by a Delete, following the foreign keys referencing them.
+type deletion struct {
+	cluster *Cluster
+	schemas map[string]*TableSchema
+	// the rows of each table involved, scanned at most once
+	scanned map[string][]Row
+	// the rows to be deleted from each table
+	victims map[string][]Row
+	// the ids of the rows to be deleted from each table
+	deleted map[string]map[int64]bool
+}
+
+func (d *deletion) scan(tableName string) ([]Row, error) {
+	if rows, ok := d.scanned[tableName]; ok {
+		return rows, nil
+	}
+	rows, err := d.cluster.scanRows(tableName)
+	if err != nil {
+		return nil, err
+	}
+	d.scanned[tableName] = rows
+	return rows, nil
+}
+
+// add adds rows of a table to the deletion, and then the rows of the child tables referencing them.
+func (d *deletion) add(tableName string, rows []Row) error {
+	schema := d.schemas[tableName]
+	keys, err := schema.uniqueKeys()
+	if err != nil {
+		return err
+	}
+	// the encoded values of each unique key of the deleted rows
+	deletedKeys := make([]map[string]bool, len(keys))
+	for i := range deletedKeys {
+		deletedKeys[i] = make(map[string]bool)
+	}
+	if d.deleted[tableName] == nil {
+		d.deleted[tableName] = make(map[int64]bool)
+	}
+	for _, row := range rows {
+		id := rowId(row[len(row)-1])
+		if d.deleted[tableName][id] {
+			continue
+		}
+		d.deleted[tableName][id] = true
+		d.victims[tableName] = append(d.victims[tableName], row)
+		for i, key := range encodeKeys(schema, keys, row) {
+			if key != "" {
+				deletedKeys[i][key] = true
+			}
+		}
+	}
+
+	for childName, child := range d.schemas {
+		fks, err := child.foreignKeys(func(name string) *TableSchema { return d.schemas[name] })
+		if err != nil {
+			return err
+		}
+		for i := range fks {
+			if fks[i].RefTable != tableName {
+				continue
+			}
+			childRows, err := d.scan(childName)
+			if err != nil {
+				return err
+			}
+			referencing := make([]Row, 0)
+			for _, childRow := range childRows {
+				values := fks[i].values(childRow)
+				if hasNull(values) || d.deleted[childName][rowId(childRow[len(childRow)-1])] ||
+					!deletedKeys[fks[i].refKey][encodeKey(values)] {
+					continue
+				}
+				if fks[i].OnDelete != OnDeleteCascade {
+					return &ConstraintError{Table: childName, Constraint: ConstraintForeignKey,
+						Columns: fks[i].Columns, Values: values, Reason: "key still referenced"}
+				}
+				referencing = append(referencing, childRow)
+			}
+			if len(referencing) > 0 {
+				if err := d.add(childName, referencing); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scanRows returns the rows of a distributed table assembled from its fragments, with the columns in the order of the
+// schema given to BuildTable followed by the id. Each fragment is read from every replica the catalog records, so that
+// a replica missing some rows is made up for by the others, and the rows are returned in the order they are inserted.
+// The columns of a row that are not found in any reachable replica are null.
+func (c *Cluster) scanRows(tableName string) ([]Row, error) {
+	c.mu.RLock()
+	schema, ok := c.tableSchemas[tableName]
+	c.mu.RUnlock()
+	fragments := c.fragmentsOf(tableName, false)
+	if !ok {
+		return nil, fmt.Errorf("no such table %s", tableName)
+	}
+
+	width := len(schema.ColumnSchemas)
+	rows := make(map[int64]Row)
+	for _, f := range fragments {
+		for _, nodeId := range f.nodes {
+			end := c.nodeEnd(nodeId)
+			for after := int64(0); ; {
+				batch := Dataset{}
+				// a node that is down is skipped, the fragment is read from its other replicas
+				if !end.Call("Node.RPCScanBatch", []interface{}{f.name, after, transferBatchSize}, &batch) ||
+					batch.Schema.TableName == "" || len(batch.Rows) == 0 {
+					break
+				}
+				after = rowId(batch.Rows[len(batch.Rows)-1][0])
+				for _, fragmentRow := range c.visibleRows(replica{f.name, nodeId}, batch.Rows) {
+					id := rowId(fragmentRow[0])
+					row, ok := rows[id]
+					if !ok {
+						row = make(Row, width+1)
+						row[width] = id
+						rows[id] = row
+					}
+					for j, cs := range batch.Schema.ColumnSchemas[1:] {
+						if position := schema.ColumnIndex(cs.Name); position >= 0 {
+							row[position] = fragmentRow[j+1]
+						}
+					}
+				}
+				if len(batch.Rows) < transferBatchSize {
+					break
+				}
+			}
+		}
+	}
+
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, row)
+	}
+	sortByIds(result)
+	return result, nil
+}
+
+// removeRows removes the rows of each table, assembled by scanRows, from every replica of the fragments of the table,
+// and then releases their keys at the coordinator. The rows are marked removed on every replica before any of them is
+// sent the removal, so that they are no longer read from any fragment at once, and remain so on the replicas that miss
+// the removal, see markRemoved.
+func (c *Cluster) removeRows(victims map[string][]Row) error {
+	ids := make(map[string][]int64, len(victims))
+	keys := make(map[string][][]string, len(victims))
+	fragments := make(map[string][]fragment, len(victims))
+	for tableName, rows := range victims {
+		c.mu.RLock()
+		schema := c.tableSchemas[tableName]
+		c.mu.RUnlock()
+		uniqueKeys, err := schema.uniqueKeys()
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			ids[tableName] = append(ids[tableName], rowId(row[len(row)-1]))
+			keys[tableName] = append(keys[tableName], encodeKeys(schema, uniqueKeys, row))
+		}
+		// the fragments being filled by a repartitioning lose the rows as well
+		fragments[tableName] = c.fragmentsOf(tableName, true)
+	}
+	c.mu.Lock()
+	for tableName, tableFragments := range fragments {
+		for _, f := range tableFragments {
+			for _, nodeId := range f.nodes {
+				c.markRemoved(tableName, ids[tableName], replica{f.name, nodeId})
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	done := make(map[string][]replica, len(victims))
+	for tableName, tableFragments := range fragments {
+		for _, f := range tableFragments {
+			for _, nodeId := range f.nodes {
+				replyMsg := ""
+				// a replica that is down or fails misses the deletion, a replica that does not exist on its node has
+				// no rows to delete
+				if c.nodeEnd(nodeId).Call("Node.RPCDeleteRows", []interface{}{f.name, ids[tableName]}, &replyMsg) &&
+					(replyMsg[0] == '0' || replyMsg == "1 no such table") {
+					done[tableName] = append(done[tableName], replica{f.name, nodeId})
+				}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for tableName := range victims {
+		for _, rowKeys := range keys[tableName] {
+			c.releaseKeys(tableName, rowKeys)
+		}
+		for _, r := range done[tableName] {
+			c.unmarkRemoved(tableName, ids[tableName], r)
+		}
+		c.tableIds[tableName].count -= len(victims[tableName])
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the schema holding the virtual tables describing the catalog of the cluster, which are read through Select like the
+// other tables and cannot be written. No table can be built with a name in it.
+const InformationSchema = "information_schema"
+
+// the virtual tables of InformationSchema
+const (
+	// a row per table: table_name, partitioning ("rules" or "hash"), fragments, rows
+	InformationSchemaTables = InformationSchema + ".tables"
+	// a row per column of each table: table_name, column_name, ordinal_position (from 1), data_type, not_null,
+	// column_default (null if the column has no default)
+	InformationSchemaColumns = InformationSchema + ".columns"
+	// a row per replica of each fragment: table_name, fragment_name, rule_key, node_id, node_state, columns
+	// (separated by commas), predicate (in the JSON grammar of the rules), is_default, bucket (-1 unless the table is
+	// partitioned by hash), storage, rows (-1 if the node is unreachable)
+	InformationSchemaFragments = InformationSchema + ".fragments"
+)
+
+// TableDescription is the definition of a distributed table and the placement of its fragments, see DescribeTable.
+type TableDescription struct {
+	Schema TableSchema
+	// the hash partitioning of the table, or nil if it is partitioned by rules
+	Hashing   *HashPartitioning
+	Fragments []FragmentDescription
+}
+
+// FragmentDescription is a fragment of a distributed table as described by DescribeTable.
+type FragmentDescription struct {
+	// the name of the fragment on the nodes, e.g., "student|0"
+	Name string
+	// the key of the partition rule defining the fragment, e.g., "0|1"
+	Key string
+	// the partition rule defining the fragment, generated from the hash partitioning for a table partitioned by hash,
+	// and whose storage is the one of the first reachable replica
+	Rule Rule
+	// the bucket held by the fragment of a table partitioned by hash, or -1
+	Bucket int
+	// the nodes holding a replica of the fragment, and the number of rows in each of them, -1 if the node is
+	// unreachable. The replicas of a fragment may have different numbers of rows while a node catches up.
+	Nodes     []string
+	RowCounts []int
+}
+
+// ListTables returns the names of the distributed tables in order, without the virtual tables of InformationSchema.
+func (c *Cluster) ListTables(args interface{}, reply *[]string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.tableSchemas))
+	for tableName := range c.tableSchemas {
+		names = append(names, tableName)
+	}
+	sort.Strings(names)
+	*reply = names
+}
+
+// DescribeTable returns the schema of a distributed table, its partitioning, and for each fragment the nodes holding
+// it with their numbers of rows, see Describe. The description is empty if there is no such table.
+// args: the name of the table
+func (c *Cluster) DescribeTable(tableName string, reply *TableDescription) {
+	if description, err := c.Describe(tableName); err == nil {
+		*reply = description
+	}
+}
+
+// Describe describes a distributed table like DescribeTable does. The fragments are described in the order of their
+// numbers, and each replica is asked for its number of rows.
+func (c *Cluster) Describe(tableName string) (TableDescription, error) {
+	c.mu.RLock()
+	schema, ok := c.tableSchemas[tableName]
+	hashing := c.tableHashing[tableName]
+	fragments := append([]fragment(nil), c.tableFragments[tableName]...)
+	c.mu.RUnlock()
+	if !ok {
+		return TableDescription{}, fmt.Errorf("no such table %s", tableName)
+	}
+	description := TableDescription{Schema: *schema, Fragments: make([]FragmentDescription, len(fragments))}
+	if hashing != nil {
+		h := *hashing
+		description.Hashing = &h
+	}
+	for i, f := range fragments {
+		fd := FragmentDescription{Name: f.name, Key: f.key, Bucket: f.bucket, Nodes: append([]string(nil), f.nodes...),
+			RowCounts: make([]int, len(f.nodes)),
+			Rule:      Rule{Predicate: f.predicate, Column: append([]string(nil), f.columns...), Default: f.isDefault}}
+		for j, nodeId := range f.nodes {
+			end := c.nodeEnd(nodeId)
+			if !end.Call("Node.RPCCountRows", f.name, &fd.RowCounts[j]) {
+				fd.RowCounts[j] = -1
+				continue
+			}
+			if fd.Rule.Storage == "" {
+				meta := tableMeta{}
+				if end.Call("Node.RPCTableMeta", f.name, &meta) && meta.Schema.TableName != "" {
+					fd.Rule.Storage = StorageName(meta.Storage)
+				}
+			}
+		}
+		description.Fragments[i] = fd
+	}
+	return description, nil
+}
+
+// isVirtualTable tells whether a table name is in InformationSchema.
+func isVirtualTable(tableName string) bool {
+	return strings.HasPrefix(tableName, InformationSchema+".")
+}
+
+// selectVirtual returns the rows of a virtual table of InformationSchema satisfying the predicate, built from the
+// catalog when the table is read.
+func (c *Cluster) selectVirtual(tableName string, predicate Predicate) (Dataset, error) {
+	schema := TableSchema{TableName: tableName}
+	rows := make([]Row, 0)
+	// the tables dropped after they are listed are skipped
+	var tableNames []string
+	c.ListTables(nil, &tableNames)
+	switch tableName {
+	case InformationSchemaTables:
+		schema.ColumnSchemas = []ColumnSchema{{Name: "table_name", DataType: TypeString},
+			{Name: "partitioning", DataType: TypeString}, {Name: "fragments", DataType: TypeInt32},
+			{Name: "rows", DataType: TypeInt32}}
+		c.mu.RLock()
+		for _, name := range tableNames {
+			if _, ok := c.tableSchemas[name]; !ok {
+				continue
+			}
+			partitioning := "rules"
+			if c.tableHashing[name] != nil {
+				partitioning = "hash"
+			}
+			rows = append(rows, Row{name, partitioning, int32(len(c.tableFragments[name])),
+				int32(c.tableIds[name].count)})
+		}
+		c.mu.RUnlock()
+	case InformationSchemaColumns:
+		schema.ColumnSchemas = []ColumnSchema{{Name: "table_name", DataType: TypeString},
+			{Name: "column_name", DataType: TypeString}, {Name: "ordinal_position", DataType: TypeInt32},
+			{Name: "data_type", DataType: TypeString}, {Name: "not_null", DataType: TypeBoolean},
+			{Name: "column_default", DataType: TypeString}}
+		c.mu.RLock()
+		for _, name := range tableNames {
+			tableSchema, ok := c.tableSchemas[name]
+			if !ok {
+				continue
+			}
+			for i, cs := range tableSchema.ColumnSchemas {
+				var columnDefault interface{}
+				if cs.Default != nil {
+					columnDefault = fmt.Sprint(cs.Default)
+				}
+				rows = append(rows, Row{name, cs.Name, int32(i + 1), DataTypeName(cs.DataType), cs.NotNull,
+					columnDefault})
+			}
+		}
+		c.mu.RUnlock()
+	case InformationSchemaFragments:
+		schema.ColumnSchemas = []ColumnSchema{{Name: "table_name", DataType: TypeString},
+			{Name: "fragment_name", DataType: TypeString}, {Name: "rule_key", DataType: TypeString},
+			{Name: "node_id", DataType: TypeString}, {Name: "node_state", DataType: TypeString},
+			{Name: "columns", DataType: TypeString}, {Name: "predicate", DataType: TypeString},
+			{Name: "is_default", DataType: TypeBoolean}, {Name: "bucket", DataType: TypeInt32},
+			{Name: "storage", DataType: TypeString}, {Name: "rows", DataType: TypeInt32}}
+		for _, name := range tableNames {
+			description, err := c.Describe(name)
+			if err != nil {
+				continue
+			}
+			for _, fd := range description.Fragments {
+				for i, nodeId := range fd.Nodes {
+					rows = append(rows, Row{name, fd.Name, fd.Key, nodeId, NodeStateName(c.NodeState(nodeId)),
+						strings.Join(fd.Rule.Column, ","), predicateJSON(fd.Rule.Predicate), fd.Rule.Default,
+						int32(fd.Bucket), fd.Rule.Storage, int32(fd.RowCounts[i])})
+				}
+			}
+		}
+	default:
+		return Dataset{}, fmt.Errorf("no such table %s", tableName)
+	}
+
+	predicate = predicate.clone()
+	if err := predicate.bind(schema.ColumnSchemas); err != nil {
+		return Dataset{}, err
+	}
+	selected := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		if matchRow(schema.ColumnSchemas, row, predicate) {
+			selected = append(selected, row)
+		}
+	}
+	return Dataset{Schema: schema, Rows: selected}, nil
+}
+
+// predicateJSON returns a predicate in the JSON grammar of the predicates in rules, e.g.,
+// {"grade":[{"op":"<","val":3}]}, with the keys in order.
+func predicateJSON(p Predicate) string {
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	// the operators < and > are kept as they are
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(predicateValue(p)); err != nil {
+		return fmt.Sprint(p)
+	}
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+func predicateValue(p Predicate) map[string]interface{} {
+	value := make(map[string]interface{}, len(p))
+	for key, atoms := range p {
+		if isLogicalOp(key) {
+			operands := make([]interface{}, 0)
+			for _, atom := range atoms {
+				for _, arg := range atom.Args {
+					operands = append(operands, predicateValue(arg))
+				}
+			}
+			value[key] = operands
+			continue
+		}
+		list := make([]map[string]interface{}, len(atoms))
+		for i, atom := range atoms {
+			list[i] = map[string]interface{}{"op": atom.Op}
+			if atom.Val != nil {
+				list[i]["val"] = atom.Val
+			}
+		}
+		value[key] = list
+	}
+	return value
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestDescribeTable(t *testing.T) {
+	c, _, cli := newTestCluster(3, "Describe")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32, NotNull: true},
+		{Name: "grade", DataType: TypeDouble, Default: 1.5},
+	}}
+	reply := ""
+	if err := buildTestTable(cli, schema, []byte(`{
+		"0|1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "grade"]},
+		"2": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "grade"], "storage": "columnar"}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	schema.TableName = "course"
+	if err := buildTestTable(cli, schema, []byte{}, HashPartitioning{Key: []string{"sid"}, Buckets: 2}); err != nil {
+		t.Fatal(err)
+	}
+	schema.TableName = InformationSchemaTables
+	if cli.Call("Cluster.BuildTable", []interface{}{schema, []byte(`{}`)}, &reply); reply == "0 OK" {
+		t.Errorf("a table should not be built in %s", InformationSchema)
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.Insert("student", Row{i, float64(i)}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+
+	tables := make([]string, 0)
+	cli.Call("Cluster.ListTables", "", &tables)
+	if len(tables) != 2 || tables[0] != "course" || tables[1] != "student" {
+		t.Errorf("expected course and student, actual %v", tables)
+	}
+
+	description := TableDescription{}
+	cli.Call("Cluster.DescribeTable", "student", &description)
+	if description.Schema.TableName != "student" || description.Hashing != nil || len(description.Fragments) != 2 {
+		t.Fatalf("unexpected description %+v", description)
+	}
+	for _, fd := range description.Fragments {
+		low := fd.Rule.Predicate["grade"][0].Op == "<"
+		expected := map[bool][]int{true: {3, 3}, false: {2}}[low]
+		if len(fd.Nodes) != len(expected) || len(fd.RowCounts) != len(expected) || fd.RowCounts[0] != expected[0] ||
+			fd.Rule.Storage != map[bool]string{true: "memory", false: "columnar"}[low] || fd.Bucket != -1 {
+			t.Errorf("unexpected description of %s: %+v", fd.Name, fd)
+		}
+	}
+	description = TableDescription{}
+	if cli.Call("Cluster.DescribeTable", "course", &description); description.Hashing == nil ||
+		description.Hashing.Buckets != 2 || description.Fragments[1].Bucket != 1 {
+		t.Errorf("unexpected description %+v", description)
+	}
+	description = TableDescription{}
+	if cli.Call("Cluster.DescribeTable", "unknown", &description); description.Schema.TableName != "" {
+		t.Errorf("an unknown table should not be described, actual %+v", description)
+	}
+
+	// the virtual tables are read through the usual selects
+	dataset := Dataset{}
+	cli.Call("Cluster.FragmentRead", []interface{}{InformationSchemaTables, Predicate{}}, &dataset)
+	if len(dataset.Rows) != 2 || dataset.Rows[0][1] != "hash" || dataset.Rows[1][3] != int32(5) {
+		t.Errorf("unexpected tables %v", dataset.Rows)
+	}
+	selected, err := c.Select(InformationSchemaColumns, Predicate{"table_name": {{Op: "=", Val: "student"}}})
+	if err != nil || len(selected.Rows) != 2 || selected.Rows[0][1] != "sid" || selected.Rows[0][4] != true ||
+		selected.Rows[0][5] != nil || selected.Rows[1][3] != "double" || selected.Rows[1][5] != "1.5" {
+		t.Errorf("unexpected columns %v (%v)", selected.Rows, err)
+	}
+	selected, err = c.Select(InformationSchemaFragments, Predicate{"table_name": {{Op: "=", Val: "student"}},
+		"node_id": {{Op: "=", Val: "Node1"}}})
+	if err != nil || len(selected.Rows) != 1 || selected.Rows[0][2] != "0|1" ||
+		selected.Rows[0][6] != `{"grade":[{"op":"<","val":3}]}` || selected.Rows[0][10] != int32(3) ||
+		selected.Rows[0][4] != "alive" {
+		t.Errorf("unexpected fragments %v (%v)", selected.Rows, err)
+	}
+	if _, err := c.Select(InformationSchema+".unknown", Predicate{}); err == nil {
+		t.Errorf("an unknown virtual table should not be read")
+	}
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DropTable removes a distributed table from the catalog and drops its fragments from every node, so that a table
+// with the same name may be built again. A table that a foreign key of another table refers to cannot be dropped.
+// The fragments of the table are looked up on the nodes rather than in the catalog, so that dropping a table again, or
+// a table that is no longer in the catalog, drops the fragments left on the nodes that were unreachable, and replies
+// "0 OK" once no node holds any of them. The fragments of another table, or of a table built again since, are kept.
+// args: the name of the table
+func (c *Cluster) DropTable(tableName string, reply *string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	if children := c.referencingTables(tableName); len(children) > 0 {
+		c.mu.Unlock()
+		*reply = fmt.Sprintf("1 cannot drop %s as %s refers to it", tableName, strings.Join(children, ", "))
+		return
+	}
+	if _, busy := c.pendingLayouts[tableName]; busy {
+		c.mu.Unlock()
+		*reply = fmt.Sprintf("1 cannot drop %s while it is repartitioned", tableName)
+		return
+	}
+	delete(c.tableIds, tableName)
+	delete(c.tableSchemas, tableName)
+	delete(c.uniqueKeyIds, tableName)
+	delete(c.tableFragments, tableName)
+	delete(c.tableHashing, tableName)
+	c.mu.Unlock()
+
+	assigned := c.assignedFragments()
+	unreachable := make([]string, 0)
+	for _, nodeId := range c.nodeList() {
+		end := c.nodeEnd(nodeId)
+		names := make([]string, 0)
+		if !end.Call("Node.ListTables", "", &names) {
+			unreachable = append(unreachable, nodeId)
+			continue
+		}
+		for _, name := range names {
+			if _, ok := assigned[name]; ok || !isFragmentOf(name, tableName) {
+				continue
+			}
+			replyMsg := ""
+			if !end.Call("Node.RPCDropTable", []interface{}{name}, &replyMsg) {
+				unreachable = append(unreachable, nodeId)
+				break
+			}
+			if replyMsg[0] != '0' {
+				*reply = fmt.Sprintf("1 cannot drop %s on %s: %s", name, nodeId, replyMsg[2:])
+				return
+			}
+		}
+	}
+	if len(unreachable) > 0 {
+		*reply = fmt.Sprintf("1 %s is dropped but %s may still hold its fragments", tableName,
+			strings.Join(unreachable, ", "))
+		return
+	}
+	*reply = "0 OK"
+}
+
+// isFragmentOf tells whether a name is the one of a fragment of the given table, i.e., the name of the table followed
+// by "|" and the number of the fragment.
+func isFragmentOf(name string, tableName string) bool {
+	number := strings.TrimPrefix(name, tableName+"|")
+	if number == name || number == "" {
+		return false
+	}
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// TruncateTable removes all the rows of a distributed table, and keeps its definition, its fragments and their
+// indexes. A table that a foreign key of another table refers to cannot be truncated. The ids assigned so far are
+// retired at the coordinator first, so that the rows are no longer read even from a replica that misses the
+// truncation, and truncating the table again truncates such replicas.
+// args: the name of the table
+func (c *Cluster) TruncateTable(tableName string, reply *string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	schema, ok := c.tableSchemas[tableName]
+	if !ok {
+		c.mu.Unlock()
+		*reply = "1 no such table " + tableName
+		return
+	}
+	if children := c.referencingTables(tableName); len(children) > 0 {
+		c.mu.Unlock()
+		*reply = fmt.Sprintf("1 cannot truncate %s as %s refers to it", tableName, strings.Join(children, ", "))
+		return
+	}
+	if _, busy := c.pendingLayouts[tableName]; busy {
+		c.mu.Unlock()
+		*reply = fmt.Sprintf("1 cannot truncate %s while it is repartitioned", tableName)
+		return
+	}
+	keys, err := schema.uniqueKeys()
+	if err != nil {
+		c.mu.Unlock()
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	// the sequence goes on, the rows inserted from now on have ids that are not retired
+	ids := c.tableIds[tableName]
+	ids.floor, ids.count = ids.next, 0
+	ids.removed = make(map[int64][]replica)
+	c.uniqueKeyIds[tableName] = make([]map[string]int64, len(keys))
+	for i := range keys {
+		c.uniqueKeyIds[tableName][i] = make(map[string]int64)
+	}
+	fragments := append([]fragment(nil), c.tableFragments[tableName]...)
+	c.mu.Unlock()
+
+	*reply = "0 OK"
+	for _, f := range fragments {
+		for _, nodeId := range f.nodes {
+			replyMsg := ""
+			if !c.nodeEnd(nodeId).Call("Node.RPCTruncateTable", []interface{}{f.name}, &replyMsg) {
+				*reply = fmt.Sprintf("1 cannot truncate %s: %s is unreachable", f.name, nodeId)
+			} else if replyMsg[0] != '0' {
+				*reply = fmt.Sprintf("1 cannot truncate %s on %s: %s", f.name, nodeId, replyMsg[2:])
+			}
+		}
+	}
+}
+
+// referencingTables returns the names of the other tables having a foreign key referring to the table, in order. The
+// caller must hold c.mu.
+func (c *Cluster) referencingTables(tableName string) []string {
+	children := make([]string, 0)
+	for childName, child := range c.tableSchemas {
+		if childName == tableName {
+			continue
+		}
+		for _, fk := range child.ForeignKeys {
+			if fk.RefTable == tableName {
+				children = append(children, childName)
+				break
+			}
+		}
+	}
+	sort.Strings(children)
+	return children
+}
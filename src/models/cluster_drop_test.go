@@ -0,0 +1,117 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestDropTable(t *testing.T) {
+	c, network, cli := newTestCluster(3, "Drop")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "grade", DataType: TypeDouble},
+	}, PrimaryKey: []string{"sid"}}
+	rules := []byte(`{
+		"0|1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "grade"]},
+		"2": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "grade"]}
+	}`)
+	reply := ""
+	build := func() {
+		if err := buildTestTable(cli, schema, rules); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 6; i++ {
+			if err := c.Insert("student", Row{i, float64(i)}); err != nil {
+				t.Fatalf("cannot insert row %d: %v", i, err)
+			}
+		}
+	}
+	build()
+	if cli.Call("Cluster.BuildTable", []interface{}{schema, rules}, &reply); reply == "0 OK" {
+		t.Errorf("a table should not be built twice")
+	}
+
+	// a table referenced by another one can be neither dropped nor truncated
+	course := TableSchema{TableName: "course", ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}},
+		ForeignKeys: []ForeignKey{{Columns: []string{"sid"}, RefTable: "student"}}}
+	if err := buildTestTable(cli, course, []byte(`{"1": {"predicate": {}, "column": ["sid"]}}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"Cluster.DropTable", "Cluster.TruncateTable"} {
+		if cli.Call(method, "student", &reply); reply == "0 OK" {
+			t.Errorf("%s should reject a referenced table", method)
+		}
+	}
+	if cli.Call("Cluster.DropTable", "course", &reply); reply != "0 OK" {
+		t.Fatalf("cannot drop table: %v", reply)
+	}
+
+	// a truncated table keeps its fragments and indexes, and its keys may be inserted again
+	if c.CreateIndex([]interface{}{"student", "grade", "btree"}, &reply); reply != "0 OK" {
+		t.Fatalf("cannot create index: %v", reply)
+	}
+	if cli.Call("Cluster.TruncateTable", "student", &reply); reply != "0 OK" {
+		t.Fatalf("cannot truncate table: %v", reply)
+	}
+	for _, f := range c.tableFragments["student"] {
+		for _, nodeId := range f.nodes {
+			meta := tableMeta{}
+			c.nodes[nodeId].RPCTableMeta(f.name, &meta)
+			dataset := Dataset{}
+			c.nodes[nodeId].ScanTable(f.name, &dataset)
+			if len(dataset.Rows) != 0 || meta.Indexes["grade"] != IndexBTree {
+				t.Errorf("expected %s on %s empty with its index, actual %v %v", f.name, nodeId, dataset.Rows,
+					meta.Indexes)
+			}
+		}
+	}
+	if err := c.Insert("student", Row{0, 4.0}); err != nil {
+		t.Errorf("cannot insert after the truncation: %v", err)
+	}
+	if selected, err := c.Select("student", Predicate{}); err != nil || len(selected.Rows) != 1 {
+		t.Errorf("expected 1 row, actual %v (%v)", selected.Rows, err)
+	}
+
+	// a node that is down while the table is dropped keeps its fragments until the drop is retried
+	network.DeleteServer("Node2")
+	if cli.Call("Cluster.DropTable", "student", &reply); reply == "0 OK" {
+		t.Errorf("the drop should report that Node2 is unreachable")
+	}
+	if _, ok := c.tableSchemas["student"]; ok || c.tableIds["student"] != nil ||
+		c.tableFragments["student"] != nil || c.uniqueKeyIds["student"] != nil {
+		t.Errorf("the table should be removed from the catalog")
+	}
+	if cli.Call("Cluster.RestartNode", "Node2", &reply); reply != "0 OK" {
+		t.Fatalf("cannot restart node: %v", reply)
+	}
+	if cli.Call("Cluster.DropTable", "student", &reply); reply != "0 OK" {
+		t.Errorf("cannot drop the table again: %v", reply)
+	}
+	for _, nodeId := range c.nodeList() {
+		tables := make([]string, 0)
+		if c.nodes[nodeId].ListTables("", &tables); len(tables) != 0 {
+			t.Errorf("expected no fragment on %s, actual %v", nodeId, tables)
+		}
+	}
+
+	// the name may be used again
+	build()
+	if selected, err := c.Select("student", Predicate{}); err != nil || len(selected.Rows) != 6 {
+		t.Errorf("expected 6 rows, actual %v (%v)", selected.Rows, err)
+	}
+
+	// the name of a table cannot be taken for the one of a fragment, and only the fragments of the table are dropped
+	fragmentName := schema
+	fragmentName.TableName = "student|9"
+	if cli.Call("Cluster.BuildTable", []interface{}{fragmentName, rules}, &reply); reply == "0 OK" {
+		t.Errorf("a table name should not contain |")
+	}
+	c.nodes["Node0"].CreateTable(&TableSchema{TableName: "student|old", ColumnSchemas: schema.ColumnSchemas})
+	if cli.Call("Cluster.DropTable", "student", &reply); reply != "0 OK" {
+		t.Fatalf("cannot drop table: %v", reply)
+	}
+	tables := make([]string, 0)
+	if c.nodes["Node0"].ListTables("", &tables); len(tables) != 1 || tables[0] != "student|old" {
+		t.Errorf("expected only student|old on Node0, actual %v", tables)
+	}
+}
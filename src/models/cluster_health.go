@@ -0,0 +1,238 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// the states of a node as seen by the failure detector of the cluster, see CheckHeartbeats
+const (
+	NodeAlive = iota
+	// the node missed a few heartbeats, it may be slow or partitioned and keeps its replicas
+	NodeSuspect
+	// the node missed so many heartbeats that its replicas are re-created on the alive nodes
+	NodeDead
+)
+
+const (
+	// how many heartbeats in a row a node misses before it is suspected
+	suspectAfterMissed = 1
+	// how many heartbeats in a row a node misses before it is declared dead
+	deadAfterMissed = 3
+)
+
+// nodeHealth is what the failure detector knows about a node.
+type nodeHealth struct {
+	state int
+	// the number of heartbeats the node missed in a row
+	missed int
+}
+
+// NodeStateName returns the name of a state of a node, e.g., "suspect" for NodeSuspect.
+func NodeStateName(state int) string {
+	switch state {
+	case NodeAlive:
+		return "alive"
+	case NodeSuspect:
+		return "suspect"
+	case NodeDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// NodeState returns the state of a node as seen by the failure detector. A node is alive until it misses a heartbeat.
+func (c *Cluster) NodeState(nodeId string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if h, ok := c.health[nodeId]; ok {
+		return h.state
+	}
+	return NodeAlive
+}
+
+// NodeStates replies the name of the state of each node of the cluster, see NodeState.
+func (c *Cluster) NodeStates(args interface{}, reply *map[string]string) {
+	states := make(map[string]string)
+	for _, nodeId := range c.nodeList() {
+		states[nodeId] = NodeStateName(c.NodeState(nodeId))
+	}
+	*reply = states
+}
+
+// healthyNodes returns the given nodes that are alive, in the same order.
+func (c *Cluster) healthyNodes(nodeIds []string) []string {
+	healthy := make([]string, 0, len(nodeIds))
+	for _, nodeId := range nodeIds {
+		if c.NodeState(nodeId) == NodeAlive {
+			healthy = append(healthy, nodeId)
+		}
+	}
+	return healthy
+}
+
+// StartFailureDetector checks the heartbeats of the nodes every interval in the background, see CheckHeartbeats, until
+// StopFailureDetector is called. It does nothing if the failure detector is already running.
+func (c *Cluster) StartFailureDetector(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.detectorDone != nil {
+		return
+	}
+	done := make(chan struct{})
+	c.detectorDone = done
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.CheckHeartbeats()
+			}
+		}
+	}()
+}
+
+// StopFailureDetector stops the background checks started by StartFailureDetector. The round in progress, if any,
+// still completes.
+func (c *Cluster) StopFailureDetector() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.detectorDone != nil {
+		close(c.detectorDone)
+		c.detectorDone = nil
+	}
+}
+
+// CheckHeartbeats runs a round of the failure detector. A heartbeat is sent to every node at once, a node that does
+// not answer becomes suspect after suspectAfterMissed rounds and dead after deadAfterMissed rounds in a row, and a node
+// that answers is alive again. Then:
+//   - a node that answers after being suspect or dead drops the replicas the catalog no longer assigns to it, removes
+//     the rows its other replicas missed the removal of, and copies the rows they missed from their peers, as the
+//     writes skip a node that does not answer either way;
+//   - each replica on a dead node is re-created on the alive node holding the fewest replicas among those not holding
+//     the fragment yet, so that the fragment gets back the number of replicas its rule specified.
+//
+// The replicas are not repaired while a table is repartitioned, but in a later round.
+func (c *Cluster) CheckHeartbeats() {
+	nodeIds := c.nodeList()
+	answered := make([]bool, len(nodeIds))
+	var wg sync.WaitGroup
+	for i, nodeId := range nodeIds {
+		wg.Add(1)
+		go func(i int, nodeId string) {
+			defer wg.Done()
+			reply := ""
+			answered[i] = c.nodeEnd(nodeId).Call("Node.Heartbeat", "", &reply)
+		}(i, nodeId)
+	}
+	wg.Wait()
+
+	revived := make([]string, 0)
+	c.mu.Lock()
+	for i, nodeId := range nodeIds {
+		h, ok := c.health[nodeId]
+		if !ok {
+			h = &nodeHealth{state: NodeAlive}
+			c.health[nodeId] = h
+		}
+		if answered[i] {
+			if h.state != NodeAlive {
+				revived = append(revived, nodeId)
+			}
+			h.state, h.missed = NodeAlive, 0
+			continue
+		}
+		h.missed++
+		if h.missed >= deadAfterMissed {
+			h.state = NodeDead
+		} else if h.missed >= suspectAfterMissed {
+			h.state = NodeSuspect
+		}
+	}
+	c.mu.Unlock()
+
+	for _, nodeId := range revived {
+		c.rejoin(nodeId)
+	}
+	c.repairReplicas()
+}
+
+// rejoin brings a node that was suspect or dead up to date with the catalog, see CheckHeartbeats.
+func (c *Cluster) rejoin(nodeId string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	assigned := c.assignedFragments()
+	replicas := make([]string, 0)
+	if !c.nodeEnd(nodeId).Call("Node.ListTables", "", &replicas) {
+		return
+	}
+	c.replayRemovals(nodeId)
+	for _, name := range replicas {
+		f, ok := assigned[name]
+		if !ok || !containsString(f.nodes, nodeId) {
+			// the replica was re-created on another node, its rows are stale
+			replyMsg := ""
+			c.nodeEnd(nodeId).Call("Node.RPCDropTable", []interface{}{name}, &replyMsg)
+			continue
+		}
+		for _, peer := range f.nodes {
+			if peer != nodeId {
+				c.copyFragment(name, peer, nodeId)
+			}
+		}
+	}
+}
+
+// repairReplicas re-creates the replicas on dead nodes on alive nodes, see CheckHeartbeats. Writes are blocked while
+// the replicas are copied.
+func (c *Cluster) repairReplicas() {
+	damaged := false
+	tableNames, tables, _ := c.placement()
+	for _, tableName := range tableNames {
+		for _, f := range tables[tableName] {
+			for _, nodeId := range f.nodes {
+				damaged = damaged || c.NodeState(nodeId) == NodeDead
+			}
+		}
+	}
+	if !damaged {
+		return
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.RLock()
+	pending := len(c.pendingLayouts)
+	c.mu.RUnlock()
+	if pending > 0 {
+		return
+	}
+	tableNames, tables, load := c.placement()
+	candidates := c.healthyNodes(c.nodeList())
+	for _, tableName := range tableNames {
+		for _, f := range tables[tableName] {
+			for _, nodeId := range append([]string(nil), f.nodes...) {
+				if c.NodeState(nodeId) != NodeDead {
+					continue
+				}
+				target := leastLoaded(f, candidates, load)
+				// a fragment whose replicas are all dead cannot be repaired, nor one on every alive node already
+				if target == "" || c.moveReplica(f, nodeId, target) != nil {
+					continue
+				}
+				load[target]++
+				c.replaceReplica(tableName, f.name, nodeId, target)
+				for i := range f.nodes {
+					if f.nodes[i] == nodeId {
+						f.nodes = append(append(append([]string(nil), f.nodes[:i]...), target), f.nodes[i+1:]...)
+						break
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"../labrpc"
+)
+
+func TestFailureDetector(t *testing.T) {
+	c, network, cli := newTestCluster(4, "Health")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	reply := ""
+	if err := buildTestTable(cli, schema, []byte(`{
+		"0|1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "grade"]},
+		"2": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "grade"]}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if err := c.Insert("student", Row{i, float64(i)}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+	low := c.tableFragments["student"][0]
+	if low.nodes[0] != "Node0" {
+		low = c.tableFragments["student"][1]
+	}
+
+	expectStates := func(expected map[string]string) {
+		states := make(map[string]string)
+		cli.Call("Cluster.NodeStates", "", &states)
+		for nodeId, state := range expected {
+			if states[nodeId] != state {
+				t.Errorf("%s should be %s, actual %v", nodeId, state, states)
+			}
+		}
+	}
+	c.CheckHeartbeats()
+	expectStates(map[string]string{"Node0": "alive", "Node1": "alive", "Node2": "alive", "Node3": "alive"})
+
+	// a killed node is suspected first, and its replicas are kept
+	network.DeleteServer("Node1")
+	c.CheckHeartbeats()
+	expectStates(map[string]string{"Node0": "alive", "Node1": "suspect"})
+	if nodes := c.tableFragments["student"][0].nodes; !containsString(append(nodes,
+		c.tableFragments["student"][1].nodes...), "Node1") {
+		t.Errorf("the replica on a suspect node should be kept")
+	}
+
+	// then it is dead, and its replica is re-created on the least loaded node
+	c.CheckHeartbeats()
+	c.CheckHeartbeats()
+	expectStates(map[string]string{"Node1": "dead"})
+	for _, f := range c.tableFragments["student"] {
+		if f.name == low.name && (len(f.nodes) != 2 || f.nodes[0] != "Node0" || f.nodes[1] != "Node3") {
+			t.Errorf("expected %s on Node0 and Node3, actual %v", f.name, f.nodes)
+		}
+	}
+	dataset := Dataset{}
+	c.nodes["Node3"].ScanTable(low.name, &dataset)
+	if len(dataset.Rows) != 3 {
+		t.Errorf("expected the 3 rows of %s on Node3, actual %v", low.name, dataset.Rows)
+	}
+	if err := c.Insert("student", Row{6, 1.0}); err != nil {
+		t.Errorf("cannot insert after the repair: %v", err)
+	}
+	if c.nodes["Node3"].ScanTable(low.name, &dataset); len(dataset.Rows) != 4 {
+		t.Errorf("the new replica should receive the writes, actual %v", dataset.Rows)
+	}
+
+	// a node answering again drops the replicas it lost
+	c.RestartNode("Node1", &reply)
+	c.CheckHeartbeats()
+	expectStates(map[string]string{"Node1": "alive"})
+	tables := make([]string, 0)
+	c.nodes["Node1"].ListTables("", &tables)
+	if len(tables) != 0 {
+		t.Errorf("Node1 should drop its stale replicas, actual %v", tables)
+	}
+
+	// the only replica of a fragment cannot be repaired
+	network.DeleteServer("Node2")
+	for i := 0; i < deadAfterMissed; i++ {
+		c.CheckHeartbeats()
+	}
+	expectStates(map[string]string{"Node2": "dead"})
+	for _, f := range c.tableFragments["student"] {
+		if f.name != low.name && (len(f.nodes) != 1 || f.nodes[0] != "Node2") {
+			t.Errorf("the fragment on Node2 should be kept, actual %v", f.nodes)
+		}
+	}
+}
+
+func TestSuspectNodeCatchesUp(t *testing.T) {
+	c, network, cli := newTestCluster(2, "Suspect")
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}}}
+	if err := buildTestTable(cli, schema, []byte(`{"0|1": {"predicate": {}, "column": ["sid"]}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node1 is partitioned away with its rows, and misses a write while it is suspect
+	c.Insert("student", Row{1})
+	network.DeleteServer("Node1")
+	c.CheckHeartbeats()
+	if err := c.Insert("student", Row{2}); err != nil {
+		t.Fatalf("cannot insert while Node1 is suspect: %v", err)
+	}
+	server := labrpc.MakeServer()
+	server.AddService(labrpc.MakeService(c.nodes["Node1"]))
+	network.AddServer("Node1", server)
+	c.CheckHeartbeats()
+	if state := c.NodeState("Node1"); state != NodeAlive {
+		t.Fatalf("Node1 should be alive, actual %s", NodeStateName(state))
+	}
+	dataset := Dataset{}
+	c.nodes["Node1"].ScanTable("student|0", &dataset)
+	if len(dataset.Rows) != 2 {
+		t.Errorf("Node1 should catch up with the write it missed, actual %v", dataset.Rows)
+	}
+}
+
+func TestFailureDetectorInBackground(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(2, network, "BackgroundHealthCluster")
+	c.StartFailureDetector(10 * time.Millisecond)
+	defer c.StopFailureDetector()
+	network.DeleteServer("Node1")
+	deadline := time.Now().Add(5 * time.Second)
+	for c.NodeState("Node1") != NodeDead && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.NodeState("Node1") != NodeDead || c.NodeState("Node0") != NodeAlive {
+		t.Errorf("expected Node1 dead and Node0 alive, actual %s and %s", NodeStateName(c.NodeState("Node1")),
+			NodeStateName(c.NodeState("Node0")))
+	}
+}
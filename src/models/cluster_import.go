@@ -0,0 +1,78 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Import reads rows from a reader, see ReadRows, and inserts them into a distributed table, see InsertRows, so that
+// they are routed like the rows inserted one by one. The positions of the failures are the positions of the rows in
+// the file, whether a row cannot be decoded or is rejected by the table. An error is returned, and nothing is
+// inserted, if there is no such table or the file is malformed.
+func (c *Cluster) Import(tableName string, r io.Reader, format int) (BulkInsertResult, error) {
+	c.mu.RLock()
+	schema, ok := c.tableSchemas[tableName]
+	var copied TableSchema
+	if ok {
+		copied = *schema
+	}
+	c.mu.RUnlock()
+	if !ok {
+		return BulkInsertResult{}, fmt.Errorf("no such table %s", tableName)
+	}
+	rows, positions, failures, err := readRows(r, format, copied)
+	if err != nil {
+		return BulkInsertResult{}, err
+	}
+	result := c.InsertRows(tableName, rows)
+	for _, failure := range result.Failures {
+		failures = append(failures, RowFailure{Row: positions[failure.Row], Reason: failure.Reason})
+	}
+	sortFailures(failures)
+	result.Failures = failures
+	return result, nil
+}
+
+// ImportFile imports a file into a distributed table like Import does, in the format given by its extension, see
+// FileFormatOf.
+func (c *Cluster) ImportFile(tableName string, path string) (BulkInsertResult, error) {
+	format, err := FileFormatOf(path)
+	if err != nil {
+		return BulkInsertResult{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return BulkInsertResult{}, err
+	}
+	defer file.Close()
+	return c.Import(tableName, file, format)
+}
+
+// Export writes the rows of a table satisfying the predicate to a writer, see WriteDataset. The table may be any
+// table Select reads, including the virtual tables of InformationSchema.
+func (c *Cluster) Export(tableName string, predicate Predicate, w io.Writer, format int) error {
+	dataset, err := c.Select(tableName, predicate)
+	if err != nil {
+		return err
+	}
+	return WriteDataset(w, format, dataset)
+}
+
+// ExportFile exports a whole table to a file like Export does, in the format given by its extension, see
+// FileFormatOf. The file is replaced if it exists.
+func (c *Cluster) ExportFile(tableName string, path string) error {
+	format, err := FileFormatOf(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := c.Export(tableName, Predicate{}, file, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
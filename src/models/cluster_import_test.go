@@ -0,0 +1,125 @@
+package models
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"../labrpc"
+)
+
+func TestImportExport(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(3, network, "ImportCluster")
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "age", DataType: TypeInt64, Default: int64(18)},
+		{Name: "grade", DataType: TypeDouble},
+		{Name: "active", DataType: TypeBoolean},
+	}, PrimaryKey: []string{"sid"}}
+	rules := []byte(`{
+		"0": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "name", "age", "grade", "active"]},
+		"1": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "name", "age", "grade", "active"]}
+	}`)
+	reply := ""
+	if c.BuildTable([]interface{}{schema, rules}, &reply); reply != "0 OK" {
+		t.Fatalf("cannot build table: %v", reply)
+	}
+
+	// the columns are matched by name, the missing ones take their defaults, and each bad row is reported
+	csvData := "grade,sid,name,active\n" +
+		"2.5,1,\"Smith, John\",true\n" +
+		"4,2,Mary,false\n" +
+		"x,3,Lucy,true\n" +
+		"3.5,1,Jack,true\n" +
+		"1,4\n" +
+		"3.5,5,,\n"
+	result, err := c.Import("student", strings.NewReader(csvData), FormatCSV)
+	if err != nil {
+		t.Fatalf("cannot import: %v", err)
+	}
+	if result.Inserted != 3 || len(result.Failures) != 3 {
+		t.Fatalf("expected 3 rows imported and 3 failures, actual %+v", result)
+	}
+	for i, position := range []int{2, 3, 4} {
+		if result.Failures[i].Row != position {
+			t.Errorf("expected failure %d on row %d, actual %+v", i, position, result.Failures[i])
+		}
+	}
+	selected, err := c.Select("student", Predicate{"sid": {{Op: "=", Val: 1}}})
+	if err != nil || len(selected.Rows) != 1 || selected.Rows[0][1] != "Smith, John" ||
+		selected.Rows[0][2] != int64(18) || selected.Rows[0][4] != true {
+		t.Errorf("unexpected row %v %v", selected.Rows, err)
+	}
+	if _, err := c.Import("student", strings.NewReader("sid,phone\n1,2\n"), FormatCSV); err == nil {
+		t.Errorf("a header with an unknown column should be rejected")
+	}
+
+	jsonData := `{"sid": 6, "name": "Tom", "grade": 1.5, "age": 20}` + "\n\n" +
+		`{"sid": 7, "grade": "high"}` + "\n" +
+		`{"sid": 8, "grade": 2, "phone": 1}` + "\n" +
+		`{"sid": 9, "grade": 3.25, "active": null}` + "\n"
+	if result, err = c.Import("student", strings.NewReader(jsonData), FormatJSONL); err != nil {
+		t.Fatalf("cannot import: %v", err)
+	}
+	// the positions are those of the lines, blank lines included
+	if result.Inserted != 2 || len(result.Failures) != 2 || result.Failures[0].Row != 2 ||
+		result.Failures[1].Row != 3 {
+		t.Errorf("expected lines 2 and 3 rejected, actual %+v", result)
+	}
+
+	// a table exported in a format is imported back into an identical table
+	dir := t.TempDir()
+	for _, name := range []string{"student.csv", "student.jsonl"} {
+		path := filepath.Join(dir, name)
+		if err := c.ExportFile("student", path); err != nil {
+			t.Fatalf("cannot export %s: %v", name, err)
+		}
+		copied := schema
+		copied.TableName = "copy_" + strings.Replace(name, ".", "_", 1)
+		if c.BuildTable([]interface{}{copied, rules}, &reply); reply != "0 OK" {
+			t.Fatalf("cannot build table: %v", reply)
+		}
+		if result, err := c.ImportFile(copied.TableName, path); err != nil || result.Inserted != 5 ||
+			len(result.Failures) != 0 {
+			t.Fatalf("cannot import %s back: %+v %v", name, result, err)
+		}
+		for _, sid := range []int{1, 2, 5, 6, 9} {
+			original, _ := c.Select("student", Predicate{"sid": {{Op: "=", Val: sid}}})
+			imported, _ := c.Select(copied.TableName, Predicate{"sid": {{Op: "=", Val: sid}}})
+			if len(original.Rows) != 1 || len(imported.Rows) != 1 || !original.Rows[0].Equals(&imported.Rows[0]) {
+				t.Errorf("%s: expected %v, actual %v", name, original.Rows, imported.Rows)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "student.jsonl")); err != nil {
+		t.Errorf("the export should create the file: %v", err)
+	}
+
+	// a dataset keeps the order of its columns, and null is \N or null, apart from the empty string
+	dataset := Dataset{Schema: TableSchema{ColumnSchemas: []ColumnSchema{{Name: "b", DataType: TypeString},
+		{Name: "a", DataType: TypeFloat}}}, Rows: []Row{{"x<y", float32(3.6)}, {nil, nil}, {"", nil}, {`\N`, nil}}}
+	buffer := new(bytes.Buffer)
+	if err := WriteDataset(buffer, FormatJSONL, dataset); err != nil ||
+		buffer.String() != "{\"b\":\"x<y\",\"a\":3.6}\n{\"b\":null,\"a\":null}\n{\"b\":\"\",\"a\":null}\n"+
+			"{\"b\":\"\\\\N\",\"a\":null}\n" {
+		t.Errorf("unexpected JSON Lines %q %v", buffer.String(), err)
+	}
+	buffer.Reset()
+	if err := WriteDataset(buffer, FormatCSV, dataset); err != nil ||
+		buffer.String() != "b,a\nx<y,3.6\n\\N,\\N\n,\\N\n\\\\N,\\N\n" {
+		t.Errorf("unexpected CSV %q %v", buffer.String(), err)
+	}
+	if rows, failures, err := ReadRows(buffer, FormatCSV, dataset.Schema); err != nil || len(failures) != 0 ||
+		len(rows) != len(dataset.Rows) || rows[1][0] != nil || rows[2][0] != "" || rows[3][0] != `\N` {
+		t.Errorf("expected %v read back, actual %v %v %v", dataset.Rows, rows, failures, err)
+	}
+	buffer.Reset()
+	if err := c.Export(InformationSchemaTables, Predicate{}, buffer, FormatCSV); err != nil ||
+		!strings.Contains(buffer.String(), "student,rules,2,5") {
+		t.Errorf("cannot export a virtual table: %q %v", buffer.String(), err)
+	}
+}
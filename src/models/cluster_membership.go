@@ -0,0 +1,242 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the prefix of the identifiers of the nodes, which are followed by the numbers the partition rules refer to them with
+const nodeNamePrefix = "Node"
+
+// nodeList returns the identifiers of the nodes of the cluster. The returned slice is never modified, as the membership
+// changes replace it.
+func (c *Cluster) nodeList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nodeIds
+}
+
+// hasNode tells whether the node numbered i in the partition rules is in the cluster.
+func (c *Cluster) hasNode(i int) bool {
+	nodeId := nodeNamePrefix + strconv.Itoa(i)
+	for _, id := range c.nodeList() {
+		if id == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// AddNode starts a new empty node and registers it in the network under the given identifier, which is "Node"
+// followed by a number that no node of the cluster has, e.g., "Node3". The node holds no fragment until some partition
+// rules refer to it, or it replaces a decommissioned node.
+// args: the identifier of the node
+func (c *Cluster) AddNode(nodeId string, reply *string) {
+	number, err := strconv.Atoi(strings.TrimPrefix(nodeId, nodeNamePrefix))
+	if !strings.HasPrefix(nodeId, nodeNamePrefix) || err != nil || number < 0 ||
+		nodeId != nodeNamePrefix+strconv.Itoa(number) {
+		*reply = "1 invalid node identifier " + nodeId
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.hasNode(number) {
+		*reply = "1 node " + nodeId + " already exists"
+		return
+	}
+	c.mu.Lock()
+	c.persisters[nodeId] = MakePersister()
+	c.mu.Unlock()
+	if err := c.startNode(nodeId); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.nodeIds = append(append([]string(nil), c.nodeIds...), nodeId)
+	c.mu.Unlock()
+	*reply = "0 OK"
+}
+
+// DecommissionNode removes a node from the cluster. Each replica held by the node is first moved to the alive node
+// holding the fewest replicas among the nodes not holding the fragment yet, so that the fragment keeps its number of
+// replicas, unless every other alive node already holds it. The catalog then refers to the new replicas, and the node
+// is unregistered from the network. Writes are blocked until the replicas are moved.
+// args: the identifier of the node
+func (c *Cluster) DecommissionNode(nodeId string, reply *string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.RLock()
+	node, ok := c.nodes[nodeId]
+	remaining := make([]string, 0, len(c.nodeIds))
+	for _, id := range c.nodeIds {
+		if id != nodeId {
+			remaining = append(remaining, id)
+		}
+	}
+	pending := len(c.pendingLayouts)
+	c.mu.RUnlock()
+	if !ok {
+		*reply = "1 no such node"
+		return
+	}
+	if len(remaining) == 0 {
+		*reply = "1 cannot decommission the last node"
+		return
+	}
+	if pending > 0 {
+		*reply = "1 cannot decommission a node while a table is repartitioned"
+		return
+	}
+
+	tableNames, tables, load := c.placement()
+	candidates := c.healthyNodes(remaining)
+	for _, tableName := range tableNames {
+		for _, f := range tables[tableName] {
+			if !containsString(f.nodes, nodeId) {
+				continue
+			}
+			target := leastLoaded(f, candidates, load)
+			if target != "" {
+				if err := c.moveReplica(f, nodeId, target); err != nil {
+					*reply = fmt.Sprintf("1 %v", err)
+					return
+				}
+				load[target]++
+			} else if len(f.nodes) == 1 {
+				*reply = "1 cannot move the only replica of " + f.name
+				return
+			}
+			c.replaceReplica(tableName, f.name, nodeId, target)
+		}
+	}
+
+	c.mu.Lock()
+	c.nodeIds = remaining
+	delete(c.nodes, nodeId)
+	delete(c.persisters, nodeId)
+	delete(c.health, nodeId)
+	c.mu.Unlock()
+	// the node drops its replicas, which removes the files of the durable ones
+	replicas := make([]string, 0)
+	c.nodeEnd(nodeId).Call("Node.ListTables", "", &replicas)
+	for _, name := range replicas {
+		replyMsg := ""
+		c.nodeEnd(nodeId).Call("Node.RPCDropTable", []interface{}{name}, &replyMsg)
+	}
+	c.network.DeleteServer(nodeId)
+	node.Close()
+	*reply = "0 OK"
+}
+
+// placement returns the names of the tables in order, their fragments, and the number of replicas held by each node.
+func (c *Cluster) placement() ([]string, map[string][]fragment, map[string]int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tableNames := make([]string, 0, len(c.tableFragments))
+	tables := make(map[string][]fragment, len(c.tableFragments))
+	load := make(map[string]int)
+	for tableName, fragments := range c.tableFragments {
+		tableNames = append(tableNames, tableName)
+		tables[tableName] = append([]fragment(nil), fragments...)
+		for _, f := range fragments {
+			for _, nodeId := range f.nodes {
+				load[nodeId]++
+			}
+		}
+	}
+	sort.Strings(tableNames)
+	return tableNames, tables, load
+}
+
+// leastLoaded returns the candidate holding the fewest replicas among those not holding the fragment, the first one
+// if several do, or nothing if every candidate holds the fragment.
+func leastLoaded(f fragment, candidates []string, load map[string]int) string {
+	target := ""
+	for _, nodeId := range candidates {
+		if !containsString(f.nodes, nodeId) && (target == "" || load[nodeId] < load[target]) {
+			target = nodeId
+		}
+	}
+	return target
+}
+
+// replaceReplica records in the catalog that the replica of a fragment on node from is on node to instead, or that it
+// is dropped if to is empty. The removals the replica on node from missed are forgotten, as it is never read again.
+func (c *Cluster) replaceReplica(tableName string, fragmentName string, from string, to string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forgetReplica(tableName, replica{fragmentName, from})
+	fragments := append([]fragment(nil), c.tableFragments[tableName]...)
+	for i, f := range fragments {
+		if f.name != fragmentName {
+			continue
+		}
+		nodes := make([]string, 0, len(f.nodes))
+		for _, nodeId := range f.nodes {
+			if nodeId == from {
+				nodeId = to
+			}
+			if nodeId != "" {
+				nodes = append(nodes, nodeId)
+			}
+		}
+		fragments[i].nodes = nodes
+	}
+	c.tableFragments[tableName] = fragments
+}
+
+// moveReplica creates a replica of a fragment on node to with the definition it has on node from, and copies its rows
+// from every replica of the fragment. A replica of the fragment already on node to is replaced.
+func (c *Cluster) moveReplica(f fragment, from string, to string) error {
+	meta := tableMeta{}
+	for _, id := range append([]string{from}, f.nodes...) {
+		if c.nodeEnd(id).Call("Node.RPCTableMeta", f.name, &meta) && meta.Schema.TableName != "" {
+			break
+		}
+	}
+	if meta.Schema.TableName == "" || meta.Predicate == nil || meta.FullSchema == nil {
+		return errors.New("no replica of " + f.name + " is reachable")
+	}
+	target := c.nodeEnd(to)
+	replyMsg := ""
+	// a replica left on node to by a move that failed is stale, it is dropped so that the move can be retried
+	if !target.Call("Node.RPCDropTable", []interface{}{f.name}, &replyMsg) {
+		return errors.New(to + " is unreachable")
+	}
+	if !target.Call("Node.RPCCreateTable", []interface{}{meta.Schema, *meta.Predicate, *meta.FullSchema, meta.Storage},
+		&replyMsg) {
+		return errors.New(to + " is unreachable")
+	}
+	if replyMsg[0] != '0' {
+		return fmt.Errorf("cannot create %s on %s: %s", f.name, to, replyMsg[2:])
+	}
+	for column, kind := range meta.Indexes {
+		if column == idColumnName {
+			continue
+		}
+		if !target.Call("Node.RPCCreateIndex", []interface{}{f.name, column, kind}, &replyMsg) {
+			return errors.New(to + " is unreachable")
+		}
+		if replyMsg[0] != '0' {
+			return fmt.Errorf("cannot create the index on %s of %s on %s: %s", column, f.name, to, replyMsg[2:])
+		}
+	}
+	for _, id := range f.nodes {
+		if err := c.copyFragment(f.name, id, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestMembership(t *testing.T) {
+	c, _, cli := newTestCluster(3, "Membership")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	reply := ""
+	if err := buildTestTable(cli, schema, []byte(`{
+		"0|1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "grade"]},
+		"1": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "grade"]}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if c.CreateIndex([]interface{}{"student", "grade", "btree"}, &reply); reply != "0 OK" {
+		t.Fatalf("cannot create index: %v", reply)
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.Insert("student", Row{i, float64(i) / 2}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+
+	for _, invalid := range []string{"Node1", "Node", "Node03", "Server4"} {
+		cli.Call("Cluster.AddNode", invalid, &reply)
+		if reply == "0 OK" {
+			t.Errorf("%s should not be added", invalid)
+		}
+	}
+	cli.Call("Cluster.AddNode", "Node3", &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot add node: %v", reply)
+	}
+
+	// the replicas left on Node2 and Node3 by a move that failed, with a row that is not in the table, are replaced
+	isLow := func(f fragment) bool { return f.predicate["grade"][0].Op == "<" }
+	for _, f := range c.tableFragments["student"] {
+		meta := tableMeta{}
+		c.nodes["Node1"].RPCTableMeta(f.name, &meta)
+		for _, nodeId := range []string{"Node2", "Node3"} {
+			c.nodes[nodeId].RPCCreateTable([]interface{}{meta.Schema, *meta.Predicate, *meta.FullSchema, meta.Storage},
+				&reply)
+			stale := Row{int64(100), int32(100), map[bool]float64{true: 1, false: 4}[isLow(f)]}
+			c.nodes[nodeId].RPCInsert([]interface{}{f.name, stale}, &reply)
+			if reply != "0 OK" {
+				t.Fatalf("cannot plant a stale replica of %s on %s: %v", f.name, nodeId, reply)
+			}
+		}
+	}
+
+	// the replicas on Node1 move to the nodes holding the fewest replicas, i.e., Node2 and Node3
+	cli.Call("Cluster.DecommissionNode", "Node1", &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot decommission node: %v", reply)
+	}
+	// the fragment of the low grades has two replicas and 6 rows, the other one has a replica and 5 rows
+	holders := make(map[string]bool)
+	for _, f := range c.tableFragments["student"] {
+		if containsString(f.nodes, "Node1") || len(f.nodes) != map[bool]int{true: 2, false: 1}[isLow(f)] {
+			t.Errorf("unexpected replicas of %s: %v", f.name, f.nodes)
+		}
+		for _, nodeId := range f.nodes {
+			holders[nodeId] = true
+			meta := tableMeta{}
+			c.nodes[nodeId].RPCTableMeta(f.name, &meta)
+			if meta.Indexes["grade"] != IndexBTree {
+				t.Errorf("the index on grade should be on %s, actual %v", nodeId, meta.Indexes)
+			}
+		}
+	}
+	if !holders["Node2"] || !holders["Node3"] {
+		t.Errorf("expected replicas on Node2 and Node3, actual %v", holders)
+	}
+	if end := c.nodeEnd("Node1"); end.Call("Node.SayHello", "", &reply) {
+		t.Errorf("Node1 should be removed from the network")
+	}
+
+	// the rows are still there, and the new replicas receive the writes
+	if err := c.Insert("student", Row{10, 4.0}); err != nil {
+		t.Errorf("cannot insert after the decommission: %v", err)
+	}
+	selected, err := c.Select("student", Predicate{})
+	if err != nil || len(selected.Rows) != 11 {
+		t.Errorf("expected 11 rows, actual %v (%v)", selected.Rows, err)
+	}
+	for _, f := range c.tableFragments["student"] {
+		for _, nodeId := range f.nodes {
+			dataset := Dataset{}
+			c.nodes[nodeId].ScanTable(f.name, &dataset)
+			if expected := map[bool]int{true: 6, false: 5}[isLow(f)]; len(dataset.Rows) != expected {
+				t.Errorf("expected %d rows in %s on %s, actual %d", expected, f.name, nodeId, len(dataset.Rows))
+			}
+		}
+	}
+
+	// the rules may refer to the new node but not to the decommissioned one
+	schema.TableName = "course"
+	if err := buildTestTable(cli, schema, []byte(`{"1": {"predicate": {}, "column": ["sid", "grade"]}}`)); err == nil {
+		t.Errorf("the rules should not refer to Node1")
+	}
+	if err := buildTestTable(cli, schema, []byte(`{"3": {"predicate": {}, "column": ["sid", "grade"]}}`)); err != nil {
+		t.Errorf("cannot build a table on Node3: %v", err)
+	}
+
+	cli.Call("Cluster.DecommissionNode", "Node1", &reply)
+	if reply == "0 OK" {
+		t.Errorf("Node1 should not be decommissioned twice")
+	}
+	for _, nodeId := range []string{"Node0", "Node2"} {
+		cli.Call("Cluster.DecommissionNode", nodeId, &reply)
+		if reply != "0 OK" {
+			t.Fatalf("cannot decommission %s: %v", nodeId, reply)
+		}
+	}
+	cli.Call("Cluster.DecommissionNode", "Node3", &reply)
+	if reply == "0 OK" {
+		t.Errorf("the last node should not be decommissioned")
+	}
+	if selected, err = c.Select("student", Predicate{}); err != nil || len(selected.Rows) != 11 {
+		t.Errorf("expected 11 rows on Node3, actual %v (%v)", selected.Rows, err)
+	}
+}
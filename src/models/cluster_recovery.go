@@ -0,0 +1,84 @@
+package models
+
+import "fmt"
+
+// how many rows are transferred in one RPC when a fragment is copied between nodes
+const transferBatchSize = 256
+
+// RestartNode kills the running incarnation of a node, if it is still alive, and starts a new one with the state saved
+// in its persister. The new incarnation recovers the definitions of its tables and the rows of its durable tables, and
+// then each of its fragments removes the rows whose removal it missed, and copies the rows it missed (or lost, for
+// in-memory fragments) from the replicas of the fragment on the other nodes, as recorded in the catalog. Writes are
+// blocked until the node has caught up.
+// args: the identifier of the node, e.g., "Node1"
+func (c *Cluster) RestartNode(nodeId string, reply *string) {
+	c.mu.RLock()
+	old, ok := c.nodes[nodeId]
+	persister := c.persisters[nodeId]
+	c.mu.RUnlock()
+	if !ok {
+		*reply = "1 no such node"
+		return
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	// the old incarnation must stop serving before its state is copied, see processReq in labrpc
+	c.network.DeleteServer(nodeId)
+	old.Close()
+	c.mu.Lock()
+	c.persisters[nodeId] = persister.Copy()
+	c.mu.Unlock()
+	if err := c.startNode(nodeId); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+
+	fragments := make([]string, 0)
+	if !c.nodeEnd(nodeId).Call("Node.ListTables", "", &fragments) {
+		*reply = "1 " + nodeId + " is unreachable"
+		return
+	}
+	c.replayRemovals(nodeId)
+	assigned := c.assignedFragments()
+	for _, fragment := range fragments {
+		// a replica the catalog no longer assigns to the node has no peer to copy from
+		for _, peer := range assigned[fragment].nodes {
+			if peer == nodeId {
+				continue
+			}
+			if err := c.copyFragment(fragment, peer, nodeId); err != nil {
+				*reply = fmt.Sprintf("1 %v", err)
+				return
+			}
+		}
+	}
+	*reply = "0 OK"
+}
+
+// copyFragment merges the rows of a fragment on node from into the same fragment on node to, one range of ids at a
+// time, but the rows node from should no longer hold, see rowIds.visible. The rows node to holds with the same ids are
+// overwritten. Nothing is copied if node from does not hold the fragment or is unreachable.
+func (c *Cluster) copyFragment(fragment string, from string, to string) error {
+	source := c.nodeEnd(from)
+	target := c.nodeEnd(to)
+	for after := int64(0); ; {
+		batch := Dataset{}
+		if !source.Call("Node.RPCScanBatch", []interface{}{fragment, after, transferBatchSize}, &batch) ||
+			batch.Schema.TableName == "" || len(batch.Rows) == 0 {
+			return nil
+		}
+		after = rowId(batch.Rows[len(batch.Rows)-1][0])
+		replyMsg := ""
+		rows := c.visibleRows(replica{fragment, from}, batch.Rows)
+		if !target.Call("Node.RPCMergeRows", []interface{}{fragment, rows}, &replyMsg) {
+			return fmt.Errorf("%s is unreachable", to)
+		}
+		if replyMsg[0] != '0' {
+			return fmt.Errorf("cannot copy %s to %s: %s", fragment, to, replyMsg[2:])
+		}
+		if len(batch.Rows) < transferBatchSize {
+			return nil
+		}
+	}
+}
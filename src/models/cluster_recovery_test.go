@@ -0,0 +1,147 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"../labrpc"
+)
+
+func setupRecoveryCluster(t *testing.T, dataDir string, storage string) (*Cluster, *labrpc.Network,
+	*labrpc.ClientEnd) {
+	network := labrpc.MakeNetwork()
+	c, err := NewClusterWithDataDir(3, network, "RecoveryCluster", dataDir)
+	if err != nil {
+		t.Fatalf("cannot create cluster: %v", err)
+	}
+	cli := connectEnd(network, "RecoveryClient", c.Name)
+
+	schema := &TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+	}}
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{"sid": [...]map[string]interface{}{{"op": ">=", "val": 0}}},
+			"column":    [...]string{"sid", "name"},
+			"storage":   storage,
+		},
+	}
+	rules, _ := json.Marshal(m)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	return c, network, cli
+}
+
+func scanNode(network *labrpc.Network, nodeId string, tableName string) Dataset {
+	dataset := Dataset{}
+	connectEnd(network, "RecoveryScanner"+nodeId, nodeId).Call("Node.ScanTable", tableName, &dataset)
+	return dataset
+}
+
+func testRestartNode(t *testing.T, dataDir string, storage string) {
+	c, network, cli := setupRecoveryCluster(t, dataDir, storage)
+	reply := ""
+	for i, name := range []string{"John", "Smith", "Hana"} {
+		cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{i, name}}, &reply)
+	}
+
+	// Node1 misses the writes while it is down
+	network.DeleteServer("Node1")
+	for i, name := range []string{"Alice", "Bob"} {
+		cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{i + 3, name}}, &reply)
+		if reply != "0 OK" {
+			t.Errorf("the row should be written to Node0: %v", reply)
+		}
+	}
+
+	cli.Call("Cluster.RestartNode", "Node1", &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot restart Node1: %v", reply)
+	}
+	expected := scanNode(network, "Node0", "student|0")
+	actual := scanNode(network, "Node1", "student|0")
+	if len(expected.Rows) != 5 || !compareDataset(expected, actual) {
+		t.Errorf("expected %v on Node1 after restart, actual %v", expected, actual)
+	}
+
+	// the restarted node keeps serving writes
+	cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{5, "Carol"}}, &reply)
+	if actual := scanNode(network, "Node1", "student|0"); len(actual.Rows) != 6 {
+		t.Errorf("expected 6 rows on Node1, actual %v", actual.Rows)
+	}
+	if c.rowCount("student") != 6 {
+		t.Errorf("expected 6 rows at the coordinator, actual %d", c.rowCount("student"))
+	}
+}
+
+func TestRestartNodeInMemory(t *testing.T) {
+	testRestartNode(t, "", "memory")
+}
+
+func TestRestartNodeDurable(t *testing.T) {
+	testRestartNode(t, t.TempDir(), "durable")
+}
+
+func TestCopyFragment(t *testing.T) {
+	c, network, _ := setupRecoveryCluster(t, "", "memory")
+	rows := make([]Row, 2*transferBatchSize+10)
+	for i := range rows {
+		rows[i] = Row{i, "student" + strconv.Itoa(i)}
+	}
+	if result := c.InsertRows("student", rows); result.Inserted != len(rows) {
+		t.Fatalf("cannot insert rows: %+v", result)
+	}
+
+	// the copy spans several ranges of ids, and overwrites the rows that differ on the target
+	stale, ok := c.nodes["Node1"].getTable("student|0")
+	if !ok {
+		t.Fatalf("Node1 should hold student|0")
+	}
+	changed, _ := stale.ScanIds(300, 1)
+	stale.Remove(&changed[0])
+	changed[0][2] = "changed"
+	stale.Insert(&changed[0])
+	missing, _ := stale.ScanIds(500, 1)
+	stale.Remove(&missing[0])
+	if err := c.copyFragment("student|0", "Node0", "Node1"); err != nil {
+		t.Fatalf("cannot copy the fragment: %v", err)
+	}
+	expected := scanNode(network, "Node0", "student|0")
+	actual := scanNode(network, "Node1", "student|0")
+	if len(expected.Rows) != len(rows) || !compareDataset(expected, actual) {
+		t.Errorf("expected %d rows on Node1 equal to those on Node0, actual %d", len(rows), len(actual.Rows))
+	}
+}
+
+func TestRestartUnknownNode(t *testing.T) {
+	_, _, cli := setupRecoveryCluster(t, "", "")
+	reply := ""
+	cli.Call("Cluster.RestartNode", "Node9", &reply)
+	if reply == "0 OK" {
+		t.Errorf("an unknown node should not be restarted")
+	}
+}
+
+func TestPersisterCopy(t *testing.T) {
+	ps := MakePersister()
+	n, err := MakeNode("Node0", "", ps)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	n.CreateTable(&TableSchema{TableName: "table1", ColumnSchemas: []ColumnSchema{{Name: "a", DataType: TypeInt32}}})
+	copied := ps.Copy()
+	n.CreateTable(&TableSchema{TableName: "table2", ColumnSchemas: []ColumnSchema{{Name: "a", DataType: TypeInt32}}})
+
+	restarted, err := MakeNode("Node0", "", copied)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tables := make([]string, 0)
+	restarted.ListTables("", &tables)
+	if len(tables) != 1 || tables[0] != "table1" {
+		t.Errorf("expected only table1 to be restored, actual %v", tables)
+	}
+}
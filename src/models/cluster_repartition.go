@@ -0,0 +1,198 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// layout is a partitioning of a table: its fragments, and its hash partitioning or nil if it is partitioned by rules.
+// A pending layout without fragments is reserved: its fragments are being created and receive no writes yet.
+type layout struct {
+	fragments []fragment
+	hashing   *HashPartitioning
+}
+
+// AlterPartitioning replaces the partition rules of a distributed table with new ones, or with a hash partitioning,
+// while the table keeps accepting writes, see Repartition.
+// params: tableName string, rules []byte, hashing HashPartitioning (optional, the rules must be empty then)
+func (c *Cluster) AlterPartitioning(params []interface{}, reply *string) {
+	var hashing *HashPartitioning
+	if len(params) > 2 {
+		h := params[2].(HashPartitioning)
+		hashing = &h
+	}
+	if err := c.Repartition(params[0].(string), params[1].([]byte), hashing); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// Repartition repartitions a distributed table like AlterPartitioning does. The new fragments are created next to the
+// old ones, with new numbers, and from then on every write of the table goes to both. The rows already in the table
+// are copied into the new fragments in batches of transferBatchSize rows, each batch blocking the deletions but not
+// the insertions. Once every row is copied, the catalog is switched to the new fragments at once, and the old fragments
+// are dropped from their nodes; a node that is down keeps its replicas of the old fragments.
+// If the new partitioning is invalid, or a row of the table cannot be routed to it, the new fragments are dropped and
+// the table is left as it was.
+func (c *Cluster) Repartition(tableName string, rules []byte, hashing *HashPartitioning) error {
+	// the slot of the table is reserved before the new fragments are created, so that a concurrent repartitioning
+	// neither takes the same fragment numbers nor drops the fragments of this one
+	pending := &layout{hashing: hashing}
+	c.mu.Lock()
+	schema, ok := c.tableSchemas[tableName]
+	_, busy := c.pendingLayouts[tableName]
+	if ok && !busy {
+		c.pendingLayouts[tableName] = pending
+	}
+	first := nextFragmentNumber(c.tableFragments[tableName])
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such table %s", tableName)
+	}
+	if busy {
+		return fmt.Errorf("%s is already being repartitioned", tableName)
+	}
+	abort := func(fragments []fragment) {
+		c.writeMu.Lock()
+		c.mu.Lock()
+		delete(c.pendingLayouts, tableName)
+		c.mu.Unlock()
+		c.writeMu.Unlock()
+		c.dropFragments(fragments)
+	}
+	fragmentRules, err := c.fragmentRules(schema, rules, hashing)
+	if err != nil {
+		abort(nil)
+		return err
+	}
+	fragments, err := c.createFragments(schema, fragmentRules, first)
+	if err != nil {
+		abort(fragments)
+		return err
+	}
+
+	// the writes in progress finish before the new fragments start receiving the writes, so that each row is either
+	// written to the new fragments or among the rows to copy
+	c.writeMu.Lock()
+	c.mu.Lock()
+	pending.fragments = fragments
+	old := append([]fragment(nil), c.tableFragments[tableName]...)
+	floor, next := c.tableIds[tableName].floor, c.tableIds[tableName].next
+	c.mu.Unlock()
+	c.writeMu.Unlock()
+
+	// the rows inserted from now on are written to the new fragments, the ones before are copied by ranges of ids
+	for lo := floor; lo < next; lo += transferBatchSize {
+		hi := lo + transferBatchSize
+		if hi > next {
+			hi = next
+		}
+		if err := c.copyRows(schema, old, pending, lo, hi); err != nil {
+			abort(fragments)
+			return err
+		}
+	}
+
+	c.writeMu.Lock()
+	c.mu.Lock()
+	c.tableFragments[tableName] = fragments
+	if hashing != nil {
+		c.tableHashing[tableName] = hashing
+	} else {
+		delete(c.tableHashing, tableName)
+	}
+	delete(c.pendingLayouts, tableName)
+	c.mu.Unlock()
+	c.writeMu.Unlock()
+	c.dropFragments(old)
+	return nil
+}
+
+// copyRows copies the rows of a table whose ids are in [lo, hi) from the old fragments into the new layout, skipping
+// the rows that are already there, and the rows that are deleted meanwhile. Each old fragment is read from every
+// reachable replica, so that a row missing from one replica is still copied.
+func (c *Cluster) copyRows(schema *TableSchema, old []fragment, pending *layout, lo int64, hi int64) error {
+	// a deletion must not remove a row between its read and its copy
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+	predicate := Predicate{idColumnName: {{Op: OpBetween, Val: []interface{}{lo, hi - 1}}}}
+
+	width := len(schema.ColumnSchemas)
+	rows := make(map[int64]Row)
+	for _, f := range old {
+		fragmentRows, err := c.readFragment(schema, f, predicate)
+		if err != nil {
+			return err
+		}
+		for _, fragmentRow := range fragmentRows {
+			id := rowId(fragmentRow[width])
+			row, ok := rows[id]
+			if !ok {
+				row = make(Row, width+1)
+				row[width] = id
+				rows[id] = row
+			}
+			for _, column := range f.columns {
+				position := schema.ColumnIndex(column)
+				row[position] = fragmentRow[position]
+			}
+		}
+	}
+
+	batches := make(map[string][]Row)
+	for id := lo; id < hi; id++ {
+		row, ok := rows[id]
+		if !ok {
+			continue
+		}
+		targets, err := route(schema, pending.fragments, pending.hashing, row[:width])
+		if err != nil {
+			return fmt.Errorf("cannot repartition %s: %v", schema.TableName, err)
+		}
+		for _, f := range targets {
+			batches[f.name] = append(batches[f.name], f.project(schema, row[:width], id))
+		}
+	}
+	for _, f := range pending.fragments {
+		if len(batches[f.name]) == 0 {
+			continue
+		}
+		// like an insertion, a replica that is down misses the rows and copies them after a restart
+		stored := false
+		for _, nodeId := range f.nodes {
+			replyMsg := ""
+			if c.nodeEnd(nodeId).Call("Node.RPCMergeRows", []interface{}{f.name, batches[f.name]}, &replyMsg) &&
+				replyMsg[0] == '0' {
+				stored = true
+			}
+		}
+		if !stored {
+			return fmt.Errorf("cannot repartition %s: no replica of %s stored the rows", schema.TableName, f.name)
+		}
+	}
+	return nil
+}
+
+// dropFragments drops the replicas of the given fragments from their nodes, the nodes that are down are skipped.
+func (c *Cluster) dropFragments(fragments []fragment) {
+	for _, f := range fragments {
+		for _, nodeId := range f.nodes {
+			replyMsg := ""
+			c.nodeEnd(nodeId).Call("Node.RPCDropTable", []interface{}{f.name}, &replyMsg)
+		}
+	}
+}
+
+// nextFragmentNumber returns the number following the numbers of the given fragments of a table.
+func nextFragmentNumber(fragments []fragment) int {
+	next := 0
+	for _, f := range fragments {
+		number, err := strconv.Atoi(f.name[strings.LastIndex(f.name, "|")+1:])
+		if err == nil && number >= next {
+			next = number + 1
+		}
+	}
+	return next
+}
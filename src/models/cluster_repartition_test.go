@@ -0,0 +1,144 @@
+package models
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRepartition(t *testing.T) {
+	c, network, cli := newTestCluster(3, "Repartition")
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	reply := ""
+	if err := buildTestTable(cli, schema, []byte(`{
+		"0": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["sid", "name"]},
+		"1": {"predicate": {"grade": [{"op": "<", "val": 3}]}, "column": ["grade"]},
+		"2|0": {"predicate": {"grade": [{"op": ">=", "val": 3}]}, "column": ["sid", "name", "grade"]}
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	rowNum := transferBatchSize + 44
+	for i := 0; i < rowNum; i++ {
+		if err := c.Insert("student", Row{i, "s" + string(rune('a'+i%26)), float64(i%5) + 0.5}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+	oldFragments := c.fragmentNames("student")
+
+	// a row that the new rules do not route leaves the table as it was
+	cli.Call("Cluster.AlterPartitioning", []interface{}{"student", []byte(`{
+		"1": {"predicate": {"grade": [{"op": "<", "val": 4}]}, "column": ["sid", "name", "grade"]}
+	}`)}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("the rows with a grade of 4.5 should not be routable")
+	}
+	if names := c.fragmentNames("student"); len(names) != 3 || names[0] != oldFragments[0] {
+		t.Errorf("expected the old fragments, actual %v", names)
+	}
+	for _, nodeId := range c.nodeIds {
+		tables := make([]string, 0)
+		c.nodes[nodeId].ListTables("", &tables)
+		for _, table := range tables {
+			if table == "student|3" {
+				t.Errorf("the new fragments should be dropped from %s", nodeId)
+			}
+		}
+	}
+
+	// a row missing from one replica of an old fragment is copied from the other
+	f := c.tableFragments["student"][2]
+	stored := Dataset{}
+	c.nodes[f.nodes[0]].RPCSelect([]interface{}{f.name, Predicate{"sid": {{Op: "=", Val: 3}}}}, &stored)
+	c.nodes[f.nodes[0]].RPCDeleteRows([]interface{}{f.name, []int64{rowId(stored.Rows[0][0])}}, &reply)
+
+	// the rows inserted during the repartitioning are not lost
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := rowNum; i < rowNum+50; i++ {
+			if err := c.Insert("student", Row{i, "new", 1.0}); err != nil {
+				t.Errorf("cannot insert row %d: %v", i, err)
+			}
+		}
+	}()
+	hashing := HashPartitioning{Key: []string{"sid"}, Buckets: 4, Replicas: 2}
+	cli.Call("Cluster.AlterPartitioning", []interface{}{"student", []byte{}, hashing}, &reply)
+	wg.Wait()
+	if reply != "0 OK" {
+		t.Fatalf("cannot repartition: %v", reply)
+	}
+
+	names := c.fragmentNames("student")
+	if len(names) != 4 || names[0] != "student|3" {
+		t.Errorf("expected the fragments student|3 to student|6, actual %v", names)
+	}
+	for _, nodeId := range c.nodeIds {
+		tables := make([]string, 0)
+		c.nodes[nodeId].ListTables("", &tables)
+		for _, table := range tables {
+			for _, old := range oldFragments {
+				if table == old {
+					t.Errorf("%s should be dropped from %s", old, nodeId)
+				}
+			}
+		}
+	}
+	selected, err := c.Select("student", Predicate{})
+	if err != nil || len(selected.Rows) != rowNum+50 {
+		t.Fatalf("expected %d rows, actual %d (%v)", rowNum+50, len(selected.Rows), err)
+	}
+	for i, row := range selected.Rows {
+		if row[0] != int32(i) || (i < rowNum && (row[1] != "s"+string(rune('a'+i%26)) || row[2] != float64(i%5)+0.5)) {
+			t.Errorf("unexpected row %d: %v", i, row)
+		}
+	}
+	before := network.GetTotalCount()
+	if selected, err = c.Select("student", Predicate{"sid": {{Op: "=", Val: 42}}}); err != nil ||
+		len(selected.Rows) != 1 || network.GetTotalCount()-before != hashing.Replicas {
+		t.Errorf("expected row 42 read from a single bucket, actual %v (%v)", selected.Rows, err)
+	}
+
+	// the deletions reach the new fragments
+	if deleted, err := c.Delete("student", Predicate{"grade": {{Op: "<", Val: 1.0}}}); err != nil || deleted != 60 {
+		t.Errorf("expected 60 rows deleted, actual %d (%v)", deleted, err)
+	}
+	if selected, _ = c.Select("student", Predicate{}); len(selected.Rows) != rowNum+50-60 {
+		t.Errorf("expected %d rows, actual %d", rowNum+50-60, len(selected.Rows))
+	}
+
+	// of two concurrent repartitionings, one may be rejected but neither drops the fragments of the other
+	results := make([]string, 2)
+	for i, rules := range [][]byte{[]byte(`{"0|1": {"predicate": {}, "column": ["sid", "name", "grade"]}}`),
+		[]byte(`{"1|2": {"predicate": {}, "column": ["sid", "name", "grade"]}}`)} {
+		wg.Add(1)
+		go func(i int, rules []byte) {
+			defer wg.Done()
+			cli.Call("Cluster.AlterPartitioning", []interface{}{"student", rules}, &results[i])
+		}(i, rules)
+	}
+	wg.Wait()
+	if results[0] != "0 OK" && results[1] != "0 OK" {
+		t.Fatalf("expected a repartitioning to succeed, actual %v", results)
+	}
+	for _, f := range c.fragmentsOf("student", false) {
+		for _, nodeName := range f.nodes {
+			tables := make([]string, 0)
+			c.nodes[nodeName].ListTables("", &tables)
+			found := false
+			for _, table := range tables {
+				found = found || table == f.name
+			}
+			if !found {
+				t.Errorf("%s should be on %s", f.name, nodeName)
+			}
+		}
+	}
+	if selected, _ = c.Select("student", Predicate{}); len(selected.Rows) != rowNum+50-60 {
+		t.Errorf("expected %d rows, actual %d", rowNum+50-60, len(selected.Rows))
+	}
+}
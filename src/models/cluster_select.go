@@ -0,0 +1,236 @@
+package models
+
+import (
+	"fmt"
+)
+
+// FragmentRead returns the rows of a distributed table satisfying the given predicate, with the columns in the order of
+// the schema given to BuildTable.
+// params: tableName string, predicate Predicate
+func (c *Cluster) FragmentRead(params []interface{}, reply *Dataset) {
+	if dataset, err := c.Select(params[0].(string), params[1].(Predicate)); err == nil {
+		*reply = dataset
+	}
+}
+
+// Select returns the rows of a distributed table satisfying the predicate like FragmentRead does, in the order they are
+// inserted. If the table is partitioned by hash and the predicate restricts its partition key with "=" or IN, only the
+// buckets that may hold the rows are read, otherwise every fragment is. The predicate is sent to the nodes, which
+// filter the rows with the indexes of the fragments, unless a fragment holds only some columns of the table: the rows
+// are then assembled from all the fragments and filtered by the coordinator. The virtual tables of InformationSchema
+// are read as well.
+func (c *Cluster) Select(tableName string, predicate Predicate) (Dataset, error) {
+	if isVirtualTable(tableName) {
+		return c.selectVirtual(tableName, predicate)
+	}
+	c.mu.RLock()
+	schema, ok := c.tableSchemas[tableName]
+	hashing := c.tableHashing[tableName]
+	fragments := append([]fragment(nil), c.tableFragments[tableName]...)
+	c.mu.RUnlock()
+	if !ok {
+		return Dataset{}, fmt.Errorf("no such table %s", tableName)
+	}
+	predicate = predicate.clone()
+	if err := predicate.bind(schema.ColumnSchemas); err != nil {
+		return Dataset{}, err
+	}
+
+	var rows []Row
+	if hashing == nil && !holdWholeRows(schema, fragments) {
+		scanned, err := c.scanRows(tableName)
+		if err != nil {
+			return Dataset{}, err
+		}
+		for _, row := range scanned {
+			if matchRow(schema.ColumnSchemas, row, predicate) {
+				rows = append(rows, row)
+			}
+		}
+	} else {
+		if hashing != nil {
+			if buckets, ok := hashing.bucketsOf(schema, predicate); ok {
+				selected := make([]fragment, len(buckets))
+				for i, b := range buckets {
+					selected[i] = fragments[b]
+				}
+				fragments = selected
+			}
+		}
+		// a row routed to several fragments is read once
+		read := make(map[int64]bool)
+		for _, f := range fragments {
+			fragmentRows, err := c.readFragment(schema, f, predicate)
+			if err != nil {
+				return Dataset{}, err
+			}
+			for _, row := range fragmentRows {
+				if id := rowId(row[len(row)-1]); !read[id] {
+					read[id] = true
+					rows = append(rows, row)
+				}
+			}
+		}
+		sortByIds(rows)
+	}
+
+	// the ids are internal to the cluster
+	width := len(schema.ColumnSchemas)
+	for i := range rows {
+		rows[i] = rows[i][:width]
+	}
+	return Dataset{Schema: *schema, Rows: rows}, nil
+}
+
+// holdWholeRows tells whether each of the fragments holds all the columns of the table.
+func holdWholeRows(schema *TableSchema, fragments []fragment) bool {
+	for _, f := range fragments {
+		if len(f.columns) < len(schema.ColumnSchemas) {
+			return false
+		}
+	}
+	return true
+}
+
+// readFragment returns the rows of a fragment satisfying the predicate, which the nodes filter, with the columns in the
+// order of the schema followed by the id, in the order they are inserted. The columns the fragment does not hold are
+// null. Like scanRows, the fragment is read from every reachable replica, so that a replica missing some rows is made
+// up for by the others, and the rows that are not rows of the table on a replica, e.g., rows being inserted, are
+// skipped, see rowIds.visible. An error is returned if no replica is reachable.
+func (c *Cluster) readFragment(schema *TableSchema, f fragment, predicate Predicate) ([]Row, error) {
+	width := len(schema.ColumnSchemas)
+	rows := make(map[int64]Row)
+	reached := false
+	for _, nodeId := range f.nodes {
+		dataset := Dataset{}
+		// a node that is down is skipped, the fragment is read from its other replicas
+		if !c.nodeEnd(nodeId).Call("Node.RPCSelect", []interface{}{f.name, predicate}, &dataset) ||
+			dataset.Schema.TableName == "" {
+			continue
+		}
+		reached = true
+		for _, fragmentRow := range c.visibleRows(replica{f.name, nodeId}, dataset.Rows) {
+			id := rowId(fragmentRow[0])
+			if _, ok := rows[id]; ok {
+				continue
+			}
+			row := make(Row, width+1)
+			row[width] = fragmentRow[0]
+			for j, cs := range dataset.Schema.ColumnSchemas[1:] {
+				if position := schema.ColumnIndex(cs.Name); position >= 0 {
+					row[position] = fragmentRow[j+1]
+				}
+			}
+			rows[id] = row
+		}
+	}
+	if !reached {
+		return nil, fmt.Errorf("no replica of %s is reachable", f.name)
+	}
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, row)
+	}
+	sortByIds(result)
+	return result, nil
+}
+
+// readRow returns the row of a table with the given id, assembled from its fragments, or false if no fragment holds
+// it. Each fragment is read from every reachable replica.
+func (c *Cluster) readRow(schema *TableSchema, id int64) (Row, bool) {
+	predicate := Predicate{idColumnName: {{Op: "=", Val: id}}}
+	var row Row
+	for _, f := range c.fragmentsOf(schema.TableName, false) {
+		rows, err := c.readFragment(schema, f, predicate)
+		if err != nil || len(rows) == 0 {
+			continue
+		}
+		if row == nil {
+			row = make(Row, len(schema.ColumnSchemas))
+		}
+		for _, column := range f.columns {
+			position := schema.ColumnIndex(column)
+			row[position] = rows[0][position]
+		}
+	}
+	return row, row != nil
+}
+
+// colocated tells whether two tables are partitioned by hash on the same columns into the same number of buckets, in
+// which case the rows of the two tables having the same values of the key are in buckets of the same number, and a
+// join on the common columns including the key can be done bucket by bucket.
+func (c *Cluster) colocated(tableName1 string, tableName2 string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	hashing1, ok1 := c.tableHashing[tableName1]
+	hashing2, ok2 := c.tableHashing[tableName2]
+	if !ok1 || !ok2 || hashing1.Buckets != hashing2.Buckets || len(hashing1.Key) != len(hashing2.Key) {
+		return false
+	}
+	schema1 := c.tableSchemas[tableName1]
+	schema2 := c.tableSchemas[tableName2]
+	for i, column := range hashing1.Key {
+		if hashing2.Key[i] != column || !sameColumn(schema1.ColumnSchemas[schema1.ColumnIndex(column)],
+			schema2.ColumnSchemas[schema2.ColumnIndex(column)]) {
+			return false
+		}
+	}
+	return true
+}
+
+// joinBuckets joins two colocated tables on their common columns bucket by bucket, so that no row is compared with the
+// rows of the other buckets. The result has the columns of the first table followed by the other columns of the
+// second, like Join.
+func (c *Cluster) joinBuckets(tableName1 string, tableName2 string) (Dataset, error) {
+	c.mu.RLock()
+	schema1, schema2 := c.tableSchemas[tableName1], c.tableSchemas[tableName2]
+	fragments1 := append([]fragment(nil), c.tableFragments[tableName1]...)
+	fragments2 := append([]fragment(nil), c.tableFragments[tableName2]...)
+	c.mu.RUnlock()
+
+	newColumns := make([]ColumnSchema, 0)
+	same1 := make([]int, 0)
+	same2 := make([]int, 0)
+	createJoinSchema([]interface{}{append([]ColumnSchema(nil), schema1.ColumnSchemas...),
+		append([]ColumnSchema(nil), schema2.ColumnSchemas...)}, &newColumns, &same1, &same2)
+	joined := make(map[int]bool, len(same2))
+	for _, i := range same2 {
+		joined[i] = true
+	}
+
+	result := make([]Row, 0)
+	for b := range fragments1 {
+		rows1, err := c.readFragment(schema1, fragments1[b], Predicate{})
+		if err != nil {
+			return Dataset{}, err
+		}
+		rows2, err := c.readFragment(schema2, fragments2[b], Predicate{})
+		if err != nil {
+			return Dataset{}, err
+		}
+		sortByIds(rows1)
+		sortByIds(rows2)
+		for _, row1 := range rows1 {
+			for _, row2 := range rows2 {
+				match := true
+				for i := range same1 {
+					if !ValuesEqual(row1[same1[i]], row2[same2[i]]) {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				row := append(Row(nil), row1[:len(schema1.ColumnSchemas)]...)
+				for i, value := range row2[:len(schema2.ColumnSchemas)] {
+					if !joined[i] {
+						row = append(row, value)
+					}
+				}
+				result = append(result, row)
+			}
+		}
+	}
+	return Dataset{Schema: TableSchema{TableName: "", ColumnSchemas: newColumns}, Rows: result}, nil
+}
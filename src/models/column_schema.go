@@ -1,7 +1,28 @@
-package models
-
-// ColumnSchema defines the name and the datatype of a column
-type ColumnSchema struct {
-	Name string
-	DataType int // one of datatype.go
-}
+package models
+
+// ColumnSchema defines the name and the datatype of a column
+type ColumnSchema struct {
+	Name string
+	DataType int // one of datatype.go
+	// whether null is rejected in the column
+	NotNull bool
+	// the value of the column in a row that is too short to have it, null if nil
+	Default interface{}
+	// the condition a row must satisfy, written in the grammar of the predicates in rules and usually restricting
+	// this column only. As in SQL, an atom on a null value is unknown rather than false and does not reject the row.
+	// It is a pointer so that column schemas remain comparable.
+	Check *Predicate
+}
+
+// check returns the CHECK predicate of the column, empty if it has none.
+func (cs *ColumnSchema) check() Predicate {
+	if cs.Check == nil {
+		return nil
+	}
+	return *cs.Check
+}
+
+// sameColumn tells whether two columns have the same name and datatype, regardless of their constraints.
+func sameColumn(a, b ColumnSchema) bool {
+	return a.Name == b.Name && a.DataType == b.DataType
+}
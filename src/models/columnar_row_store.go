@@ -0,0 +1,417 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnarRowStore keeps the rows column by column, each column in a vector typed by its DataType instead of boxing
+// every value into an interface{}. It suits vertically partitioned fragments, which have only a few columns and are
+// mostly scanned with predicates on one column at a time.
+// Values are normalized into the canonical Go type of their column (see NormalizeValue) when they are inserted, so the
+// rows read back may have different Go types from the inserted ones, e.g., an int inserted into an int32 column is
+// read back as an int32.
+type ColumnarRowStore struct {
+	schema  *TableSchema
+	columns []columnVector
+	size    int
+}
+
+// columnVector is a column of a ColumnarRowStore, null values are tracked in a separate flag slice.
+type columnVector interface {
+	// appends a normalized value or nil
+	appendValue(value interface{})
+	get(i int) interface{}
+	removeAt(i int)
+	// match clears selection[i] for each row i whose value does not satisfy the atom
+	match(atom *Atom, selection []bool)
+}
+
+func NewColumnarRowStore(schema *TableSchema) *ColumnarRowStore {
+	s := &ColumnarRowStore{schema: schema, columns: make([]columnVector, len(schema.ColumnSchemas))}
+	for i, cs := range schema.ColumnSchemas {
+		switch cs.DataType {
+		case TypeInt32:
+			s.columns[i] = &int32Vector{}
+		case TypeInt64:
+			s.columns[i] = &int64Vector{}
+		case TypeFloat:
+			s.columns[i] = &float32Vector{}
+		case TypeDouble:
+			s.columns[i] = &float64Vector{}
+		case TypeBoolean:
+			s.columns[i] = &boolVector{}
+		default:
+			s.columns[i] = &stringVector{}
+		}
+	}
+	return s
+}
+
+// normalize converts a row into the types of the columns, or returns an error if it does not fit the schema.
+func (s *ColumnarRowStore) normalize(row *Row) (Row, error) {
+	if len(*row) != len(s.columns) {
+		return nil, fmt.Errorf("expected %d values, actual %d", len(s.columns), len(*row))
+	}
+	normalized := make(Row, len(*row))
+	for i, value := range *row {
+		v, err := NormalizeValue(value, s.schema.ColumnSchemas[i].DataType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", s.schema.ColumnSchemas[i].Name, err)
+		}
+		normalized[i] = v
+	}
+	return normalized, nil
+}
+
+func (s *ColumnarRowStore) row(i int) Row {
+	row := make(Row, len(s.columns))
+	for j, column := range s.columns {
+		row[j] = column.get(i)
+	}
+	return row
+}
+
+func (s *ColumnarRowStore) count() int {
+	return s.size
+}
+
+func (s *ColumnarRowStore) iterator() RowIterator {
+	return &ColumnarRowIterator{store: s}
+}
+
+func (s *ColumnarRowStore) insert(row *Row) error {
+	normalized, err := s.normalize(row)
+	if err != nil {
+		return err
+	}
+	for i, column := range s.columns {
+		column.appendValue(normalized[i])
+	}
+	s.size++
+	return nil
+}
+
+func (s *ColumnarRowStore) remove(row *Row) error {
+	normalized, err := s.normalize(row)
+	if err != nil {
+		// a row that does not fit the schema cannot be in the store
+		return nil
+	}
+	for i := 0; i < s.size; i++ {
+		matched := true
+		for j, column := range s.columns {
+			if column.get(i) != normalized[j] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			for _, column := range s.columns {
+				column.removeAt(i)
+			}
+			s.size--
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *ColumnarRowStore) close() error {
+	return nil
+}
+
+// filter evaluates the predicate column by column and returns the rows satisfying it. Atoms on columns that are not
+// in the store are ignored, as the store cannot decide them. Logical operators are evaluated on the selected rows.
+func (s *ColumnarRowStore) filter(predicate Predicate) []Row {
+	selection := make([]bool, s.size)
+	for i := range selection {
+		selection[i] = true
+	}
+	for i, cs := range s.schema.ColumnSchemas {
+		for j := range predicate[cs.Name] {
+			atom := &predicate[cs.Name][j]
+			if isComparisonOp(atom.Op) {
+				s.columns[i].match(atom, selection)
+				continue
+			}
+			// the other operators are checked value by value
+			for k := range selection {
+				selection[k] = selection[k] && atom.Check(s.columns[i].get(k))
+			}
+		}
+	}
+	logical := make(Predicate)
+	for key, atoms := range predicate {
+		if isLogicalOp(key) {
+			logical[key] = atoms
+		}
+	}
+	rows := make([]Row, 0)
+	for i, selected := range selection {
+		if selected {
+			row := s.row(i)
+			if len(logical) == 0 || matchRow(s.schema.ColumnSchemas, row, logical) {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows
+}
+
+// ColumnarRowIterator assembles the rows of a ColumnarRowStore one at a time.
+type ColumnarRowIterator struct {
+	store *ColumnarRowStore
+	next  int
+}
+
+func (iter *ColumnarRowIterator) HasNext() bool {
+	return iter.next < iter.store.size
+}
+
+func (iter *ColumnarRowIterator) Next() *Row {
+	if iter.next >= iter.store.size {
+		return nil
+	}
+	row := iter.store.row(iter.next)
+	iter.next++
+	return &row
+}
+
+// matchNulls decides the null values and the atoms without a value with Atom.Check, and returns whether the other
+// values are left to be compared by the vector.
+func matchNulls(atom *Atom, nulls []bool, selection []bool) bool {
+	if atom.Val == nil {
+		for i := range selection {
+			if selection[i] {
+				if nulls[i] {
+					selection[i] = atom.Check(nil)
+				} else {
+					selection[i] = atom.Op == "!=" || atom.Op == "<>"
+				}
+			}
+		}
+		return false
+	}
+	for i := range selection {
+		if selection[i] && nulls[i] {
+			selection[i] = atom.Check(nil)
+		}
+	}
+	return true
+}
+
+// matchIntegers compares integer values with the number in the atom, exactly if the number is an integer.
+func matchIntegers(atom *Atom, length int, value func(i int) int64, nulls []bool, selection []bool) {
+	if !matchNulls(atom, nulls, selection) {
+		return
+	}
+	if target, err := atom.NumberValue.Int64(); err == nil {
+		for i := 0; i < length; i++ {
+			if selection[i] && !nulls[i] {
+				selection[i] = compareResult(compareInt64(value(i), target), atom.Op)
+			}
+		}
+		return
+	}
+	target, err := atom.NumberValue.Float64()
+	for i := 0; i < length; i++ {
+		if selection[i] && !nulls[i] {
+			selection[i] = err == nil && compareResult(compareFloat64(float64(value(i)), target), atom.Op)
+		}
+	}
+}
+
+// matchFloats compares floating point values with the number in the atom, at the precision of float32 for the values
+// of float32 as compareValues does.
+func matchFloats(atom *Atom, length int, value func(i int) float64, float32Values bool, nulls []bool,
+	selection []bool) {
+	if !matchNulls(atom, nulls, selection) {
+		return
+	}
+	target, err := atom.NumberValue.Float64()
+	if float32Values {
+		target = float64(float32(target))
+	}
+	for i := 0; i < length; i++ {
+		if selection[i] && !nulls[i] {
+			selection[i] = err == nil && compareResult(compareFloat64(value(i), target), atom.Op)
+		}
+	}
+}
+
+type int32Vector struct {
+	values []int32
+	nulls  []bool
+}
+
+func (v *int32Vector) appendValue(value interface{}) {
+	i, _ := value.(int32)
+	v.values = append(v.values, i)
+	v.nulls = append(v.nulls, value == nil)
+}
+
+func (v *int32Vector) get(i int) interface{} {
+	if v.nulls[i] {
+		return nil
+	}
+	return v.values[i]
+}
+
+func (v *int32Vector) removeAt(i int) {
+	v.values = append(v.values[:i], v.values[i+1:]...)
+	v.nulls = append(v.nulls[:i], v.nulls[i+1:]...)
+}
+
+func (v *int32Vector) match(atom *Atom, selection []bool) {
+	matchIntegers(atom, len(v.values), func(i int) int64 { return int64(v.values[i]) }, v.nulls, selection)
+}
+
+type int64Vector struct {
+	values []int64
+	nulls  []bool
+}
+
+func (v *int64Vector) appendValue(value interface{}) {
+	i, _ := value.(int64)
+	v.values = append(v.values, i)
+	v.nulls = append(v.nulls, value == nil)
+}
+
+func (v *int64Vector) get(i int) interface{} {
+	if v.nulls[i] {
+		return nil
+	}
+	return v.values[i]
+}
+
+func (v *int64Vector) removeAt(i int) {
+	v.values = append(v.values[:i], v.values[i+1:]...)
+	v.nulls = append(v.nulls[:i], v.nulls[i+1:]...)
+}
+
+func (v *int64Vector) match(atom *Atom, selection []bool) {
+	matchIntegers(atom, len(v.values), func(i int) int64 { return v.values[i] }, v.nulls, selection)
+}
+
+type float32Vector struct {
+	values []float32
+	nulls  []bool
+}
+
+func (v *float32Vector) appendValue(value interface{}) {
+	f, _ := value.(float32)
+	v.values = append(v.values, f)
+	v.nulls = append(v.nulls, value == nil)
+}
+
+func (v *float32Vector) get(i int) interface{} {
+	if v.nulls[i] {
+		return nil
+	}
+	return v.values[i]
+}
+
+func (v *float32Vector) removeAt(i int) {
+	v.values = append(v.values[:i], v.values[i+1:]...)
+	v.nulls = append(v.nulls[:i], v.nulls[i+1:]...)
+}
+
+func (v *float32Vector) match(atom *Atom, selection []bool) {
+	matchFloats(atom, len(v.values), func(i int) float64 { return float64(v.values[i]) }, true, v.nulls, selection)
+}
+
+type float64Vector struct {
+	values []float64
+	nulls  []bool
+}
+
+func (v *float64Vector) appendValue(value interface{}) {
+	f, _ := value.(float64)
+	v.values = append(v.values, f)
+	v.nulls = append(v.nulls, value == nil)
+}
+
+func (v *float64Vector) get(i int) interface{} {
+	if v.nulls[i] {
+		return nil
+	}
+	return v.values[i]
+}
+
+func (v *float64Vector) removeAt(i int) {
+	v.values = append(v.values[:i], v.values[i+1:]...)
+	v.nulls = append(v.nulls[:i], v.nulls[i+1:]...)
+}
+
+func (v *float64Vector) match(atom *Atom, selection []bool) {
+	matchFloats(atom, len(v.values), func(i int) float64 { return v.values[i] }, false, v.nulls, selection)
+}
+
+type boolVector struct {
+	values []bool
+	nulls  []bool
+}
+
+func (v *boolVector) appendValue(value interface{}) {
+	b, _ := value.(bool)
+	v.values = append(v.values, b)
+	v.nulls = append(v.nulls, value == nil)
+}
+
+func (v *boolVector) get(i int) interface{} {
+	if v.nulls[i] {
+		return nil
+	}
+	return v.values[i]
+}
+
+func (v *boolVector) removeAt(i int) {
+	v.values = append(v.values[:i], v.values[i+1:]...)
+	v.nulls = append(v.nulls[:i], v.nulls[i+1:]...)
+}
+
+func (v *boolVector) match(atom *Atom, selection []bool) {
+	if !matchNulls(atom, v.nulls, selection) {
+		return
+	}
+	for i, value := range v.values {
+		if selection[i] && !v.nulls[i] {
+			selection[i] = compareResult(compareBool(value, atom.BoolValue), atom.Op)
+		}
+	}
+}
+
+type stringVector struct {
+	values []string
+	nulls  []bool
+}
+
+func (v *stringVector) appendValue(value interface{}) {
+	s, _ := value.(string)
+	v.values = append(v.values, s)
+	v.nulls = append(v.nulls, value == nil)
+}
+
+func (v *stringVector) get(i int) interface{} {
+	if v.nulls[i] {
+		return nil
+	}
+	return v.values[i]
+}
+
+func (v *stringVector) removeAt(i int) {
+	v.values = append(v.values[:i], v.values[i+1:]...)
+	v.nulls = append(v.nulls[:i], v.nulls[i+1:]...)
+}
+
+func (v *stringVector) match(atom *Atom, selection []bool) {
+	if !matchNulls(atom, v.nulls, selection) {
+		return
+	}
+	for i, value := range v.values {
+		if selection[i] && !v.nulls[i] {
+			selection[i] = compareResult(strings.Compare(value, atom.StringValue), atom.Op)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var columnarTestSchema = &TableSchema{TableName: "columnar", ColumnSchemas: []ColumnSchema{
+	{Name: "c_int32", DataType: TypeInt32},
+	{Name: "c_int64", DataType: TypeInt64},
+	{Name: "c_float", DataType: TypeFloat},
+	{Name: "c_double", DataType: TypeDouble},
+	{Name: "c_bool", DataType: TypeBoolean},
+	{Name: "c_string", DataType: TypeString},
+}}
+
+func TestColumnarRowStore(t *testing.T) {
+	s := NewColumnarRowStore(columnarTestSchema)
+	rows := []Row{
+		{1, json.Number("10000000000"), 1.5, 2.5, true, "a"},
+		{int32(2), int64(20), float32(2.5), 3.5, false, "b"},
+		{nil, nil, nil, nil, nil, nil},
+	}
+	for _, row := range rows {
+		if err := s.insert(&row); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := s.insert(&Row{"not a number", 1, 1.0, 1.0, true, "c"}); err == nil {
+		t.Errorf("a string should not be stored in an int32 column")
+	}
+	if err := s.insert(&Row{1, 2}); err == nil {
+		t.Errorf("a row shorter than the schema should not be stored")
+	}
+
+	expected := []Row{
+		{int32(1), int64(10000000000), float32(1.5), 2.5, true, "a"},
+		{int32(2), int64(20), float32(2.5), 3.5, false, "b"},
+		{nil, nil, nil, nil, nil, nil},
+	}
+	if actual := collectRows(s.iterator()); !compareRows(actual, expected, []int{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("expected %v, actual %v", expected, actual)
+	}
+
+	// the row to be removed is normalized before it is compared
+	s.remove(&rows[0])
+	if s.count() != 2 {
+		t.Errorf("expected 2 rows after the removal, actual %d", s.count())
+	}
+	if actual := collectRows(s.iterator()); !compareRows(actual, expected[1:], []int{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("expected %v, actual %v", expected[1:], actual)
+	}
+}
+
+// the vectorized evaluation must agree with the evaluation of Atom.Check row by row
+func TestColumnarFilter(t *testing.T) {
+	columnar := NewTable(columnarTestSchema, NewColumnarRowStore(columnarTestSchema))
+	list := NewTable(columnarTestSchema, NewMemoryListRowStore())
+	for i := 0; i < 20; i++ {
+		row := Row{int32(i), int64(i * 1000), float32(i) / 2, float64(i) / 4, i%3 == 0, string(rune('a' + i))}
+		if i%7 == 0 {
+			row[1] = nil
+			row[5] = nil
+		}
+		columnar.Insert(&row)
+		list.Insert(&row)
+	}
+
+	predicates := []Predicate{
+		{"c_int32": {{Op: ">=", Val: json.Number("5")}, {Op: "<", Val: json.Number("12.5")}}},
+		{"c_int64": {{Op: ">", Val: json.Number("3000")}}, "c_bool": {{Op: "<=", Val: true}}},
+		{"c_float": {{Op: "<=", Val: json.Number("4")}}, "c_double": {{Op: ">", Val: json.Number("0.5")}}},
+		{"c_string": {{Op: ">", Val: "e"}, {Op: "!=", Val: nil}}},
+		{"c_string": {{Op: "=", Val: nil}}},
+		{"c_bool": {{Op: "!=", Val: true}}},
+		{"unknown": {{Op: "=", Val: json.Number("1")}}},
+	}
+	for i, predicate := range predicates {
+		expected, err := list.Select(predicate)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		actual, err := columnar.Select(predicate)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !compareRows(actual, expected, []int{0, 1, 2, 3, 4, 5}) {
+			t.Errorf("predicate %d: expected %v, actual %v", i, expected, actual)
+		}
+	}
+}
+
+func TestColumnarNodeTable(t *testing.T) {
+	n := NewNode("Node0")
+	schema := &TableSchema{TableName: "student|0", ColumnSchemas: []ColumnSchema{
+		{Name: "id", DataType: TypeString},
+		{Name: "grade", DataType: TypeFloat},
+	}}
+	if err := n.CreateTableWithStorage(schema, StorageColumnar); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i, grade := range []float32{3.6, 4.0, 2.0} {
+		row := Row{string(rune('a' + i)), grade}
+		if err := n.Insert("student|0", &row); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	dataset := Dataset{}
+	n.RPCSelect([]interface{}{"student|0", Predicate{"grade": {{Op: ">", Val: json.Number("3.5")}}}}, &dataset)
+	expected := []Row{{"a", float32(3.6)}, {"b", float32(4.0)}}
+	if !compareRows(dataset.Rows, expected, []int{0, 1}) {
+		t.Errorf("expected %v, actual %v", expected, dataset.Rows)
+	}
+}
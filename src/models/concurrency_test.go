@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	"../labrpc"
+)
+
+// the tests in this file are meant to be run with "go test -race", they issue requests from many clients at the same
+// time and check that no write is lost
+
+const stressClients = 8
+const stressRowsPerClient = 25
+
+func setupStressCluster(t *testing.T) (*Cluster, *labrpc.Network) {
+	c, network, cli := newTestCluster(3, "Stress")
+
+	schema := &TableSchema{TableName: "stress", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{"op": "<", "val": 50}},
+			},
+			"column": [...]string{"sid", "name", "grade"},
+		},
+		"1|2": map[string]interface{}{
+			"predicate": map[string]interface{}{
+				"grade": [...]map[string]interface{}{{"op": ">=", "val": 50}},
+			},
+			"column": [...]string{"sid", "name", "grade"},
+		},
+	}
+	rules, _ := json.Marshal(m)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	return c, network
+}
+
+// countReplicas sums the rows of every fragment of the given table on every node
+func countReplicas(network *labrpc.Network, c *Cluster, tableName string) int {
+	total := 0
+	for _, nodeId := range c.nodeIds {
+		endName := "StressCounter" + nodeId
+		end := connectEnd(network, endName, nodeId)
+		for _, name := range c.fragmentNames(tableName) {
+			dataset := Dataset{}
+			end.Call("Node.ScanTable", name, &dataset)
+			total += len(dataset.Rows)
+		}
+	}
+	return total
+}
+
+func TestConcurrentFragmentWrite(t *testing.T) {
+	c, network := setupStressCluster(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < stressClients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			endName := "StressClient" + strconv.Itoa(client)
+			cli := connectEnd(network, endName, c.Name)
+			for j := 0; j < stressRowsPerClient; j++ {
+				sid := client*stressRowsPerClient + j
+				row := Row{sid, "student" + strconv.Itoa(sid), float64(sid % 100)}
+				reply := ""
+				cli.Call("Cluster.FragmentWrite", []interface{}{"stress", row}, &reply)
+				if reply != "0 OK" {
+					t.Errorf("insert of %v failed: %v", row, reply)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	expected := stressClients * stressRowsPerClient
+	if c.rowCount("stress") != expected {
+		t.Errorf("expected %d rows at the coordinator, actual %d", expected, c.rowCount("stress"))
+	}
+	// every row is held by two replicas
+	if actual := countReplicas(network, c, "stress"); actual != 2*expected {
+		t.Errorf("expected %d stored rows, actual %d", 2*expected, actual)
+	}
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	c, network := setupStressCluster(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < stressClients; i++ {
+		wg.Add(2)
+		go func(client int) {
+			defer wg.Done()
+			endName := "StressWriter" + strconv.Itoa(client)
+			cli := connectEnd(network, endName, c.Name)
+			for j := 0; j < stressRowsPerClient; j++ {
+				sid := client*stressRowsPerClient + j
+				reply := ""
+				cli.Call("Cluster.FragmentWrite", []interface{}{"stress", Row{sid, "s", float64(j)}}, &reply)
+			}
+		}(i)
+		go func(client int) {
+			defer wg.Done()
+			nodeId := c.nodeIds[client%len(c.nodeIds)]
+			endName := "StressReader" + strconv.Itoa(client)
+			end := connectEnd(network, endName, nodeId)
+			for j := 0; j < stressRowsPerClient; j++ {
+				dataset := Dataset{}
+				end.Call("Node.ScanTable", "stress|"+strconv.Itoa(j%2), &dataset)
+				schema := make([]ColumnSchema, 0)
+				end.Call("Node.GetFullSchema", "stress|0", &schema)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if actual := countReplicas(network, c, "stress"); actual != 2*stressClients*stressRowsPerClient {
+		t.Errorf("expected %d stored rows, actual %d", 2*stressClients*stressRowsPerClient, actual)
+	}
+}
+
+func TestConcurrentNodeAccess(t *testing.T) {
+	n := NewNode("StressNode")
+
+	var wg sync.WaitGroup
+	for i := 0; i < stressClients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			tableName := "table" + strconv.Itoa(client%2)
+			// only one of the creations of each table succeeds
+			n.CreateTable(&TableSchema{TableName: tableName, ColumnSchemas: []ColumnSchema{
+				{Name: "value", DataType: TypeInt32},
+			}})
+			for j := 0; j < stressRowsPerClient; j++ {
+				row := Row{client*stressRowsPerClient + j}
+				if err := n.Insert(tableName, &row); err != nil {
+					t.Error(err.Error())
+				}
+				iter, err := n.IterateTable(tableName)
+				if err != nil {
+					t.Error(err.Error())
+					continue
+				}
+				for iter.HasNext() {
+					iter.Next()
+				}
+				if j%2 == 0 {
+					n.Remove(tableName, &row)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := 0; i < 2; i++ {
+		count, err := n.count("table" + strconv.Itoa(i))
+		if err != nil {
+			t.Error(err.Error())
+		}
+		total += count
+	}
+	// rows with an even j are removed right after being inserted
+	if expected := stressClients * (stressRowsPerClient / 2); total != expected {
+		t.Errorf("expected %d rows, actual %d", expected, total)
+	}
+}
@@ -0,0 +1,339 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// the kinds of constraints reported by ConstraintError
+const (
+	ConstraintPrimaryKey = "PRIMARY KEY"
+	ConstraintUnique     = "UNIQUE"
+	ConstraintForeignKey = "FOREIGN KEY"
+	ConstraintNotNull    = "NOT NULL"
+	ConstraintCheck      = "CHECK"
+)
+
+// ConstraintError is returned when a write is rejected because the row violates a constraint declared by the
+// TableSchema of the table. The coordinator replies to a rejected FragmentWrite with "1 " followed by Error().
+type ConstraintError struct {
+	Table string
+	// one of the constants above
+	Constraint string
+	Columns    []string
+	// the values of Columns in the rejected row
+	Values []interface{}
+	// what is wrong with the values, e.g., "duplicate key"
+	Reason string
+}
+
+func (e *ConstraintError) Error() string {
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = fmt.Sprint(v)
+	}
+	return fmt.Sprintf("%s (%s)=(%s) violates %s (%s) of %s", e.Reason, strings.Join(e.Columns, ", "),
+		strings.Join(values, ", "), e.Constraint, strings.Join(e.Columns, ", "), e.Table)
+}
+
+// bindChecks binds the CHECK predicate of each column to the columns of the schema, and verifies that the defaults
+// conform to the types of their columns. It is called once when the table is built, so that the predicates are
+// only read afterwards.
+func (s *TableSchema) bindChecks() error {
+	for _, cs := range s.ColumnSchemas {
+		if !CheckType(cs.Default, cs.DataType) {
+			return fmt.Errorf("the default %v of %s does not conform to %s", cs.Default, cs.Name,
+				DataTypeName(cs.DataType))
+		}
+		for column := range cs.check() {
+			if s.ColumnIndex(column) < 0 {
+				return fmt.Errorf("%s of %s refers to unknown column %s", ConstraintCheck, cs.Name, column)
+			}
+		}
+		if err := cs.check().bind(s.ColumnSchemas); err != nil {
+			return fmt.Errorf("%s of %s: %v", ConstraintCheck, cs.Name, err)
+		}
+	}
+	return nil
+}
+
+// completeRow returns the row with the defaults of the columns it is too short to have appended, or an error if it
+// has more values than the columns.
+func (s *TableSchema) completeRow(row Row) (Row, error) {
+	if len(row) > len(s.ColumnSchemas) {
+		return nil, fmt.Errorf("expected at most %d values, actual %d", len(s.ColumnSchemas), len(row))
+	}
+	complete := make(Row, len(s.ColumnSchemas))
+	copy(complete, row)
+	for i := len(row); i < len(complete); i++ {
+		complete[i] = s.ColumnSchemas[i].Default
+	}
+	return complete, nil
+}
+
+// normalizeRow converts each value of a complete row into the canonical Go type of its column, see NormalizeValue.
+func (s *TableSchema) normalizeRow(row Row) (Row, error) {
+	normalized := make(Row, len(row))
+	for i, value := range row {
+		v, err := NormalizeValue(value, s.ColumnSchemas[i].DataType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s of %s: %v", s.ColumnSchemas[i].Name, s.TableName, err)
+		}
+		normalized[i] = v
+	}
+	return normalized, nil
+}
+
+// checkColumns checks a complete row against the NOT NULL and CHECK constraints of the columns of the table.
+func (s *TableSchema) checkColumns(row Row) error {
+	for _, cs := range s.ColumnSchemas {
+		if cs.NotNull && row[s.ColumnIndex(cs.Name)] == nil {
+			return &ConstraintError{Table: s.TableName, Constraint: ConstraintNotNull, Columns: []string{cs.Name},
+				Values: []interface{}{nil}, Reason: "null value in"}
+		}
+		for column, atoms := range cs.check() {
+			value := row[s.ColumnIndex(column)]
+			for i := range atoms {
+				// comparing null with a value is unknown, which satisfies a CHECK
+				if value == nil && atoms[i].Val != nil {
+					continue
+				}
+				if !atoms[i].Check(value) {
+					return &ConstraintError{Table: s.TableName, Constraint: ConstraintCheck,
+						Columns: []string{column}, Values: []interface{}{value}, Reason: "value"}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueKey is a primary key or a unique constraint of a table, with the positions of its columns in the schema.
+type uniqueKey struct {
+	constraint string
+	columns    []string
+	positions  []int
+}
+
+// uniqueKeys returns the primary key, if any, followed by the unique constraints of the schema, in the order they
+// are declared. An error is returned if a constraint refers to a column that is not in the schema.
+func (s *TableSchema) uniqueKeys() ([]uniqueKey, error) {
+	groups := make([][]string, 0, len(s.Unique)+1)
+	constraints := make([]string, 0, len(s.Unique)+1)
+	if len(s.PrimaryKey) > 0 {
+		groups = append(groups, s.PrimaryKey)
+		constraints = append(constraints, ConstraintPrimaryKey)
+	}
+	for _, group := range s.Unique {
+		groups = append(groups, group)
+		constraints = append(constraints, ConstraintUnique)
+	}
+	keys := make([]uniqueKey, len(groups))
+	for i, group := range groups {
+		if len(group) == 0 {
+			return nil, fmt.Errorf("%s of %s has no column", constraints[i], s.TableName)
+		}
+		keys[i] = uniqueKey{constraint: constraints[i], columns: group, positions: make([]int, len(group))}
+		for j, column := range group {
+			if keys[i].positions[j] = s.ColumnIndex(column); keys[i].positions[j] < 0 {
+				return nil, fmt.Errorf("%s of %s refers to unknown column %s", constraints[i], s.TableName, column)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// values returns the values of the key in the row, normalized into the types of the columns so that, e.g., 1 and
+// int32(1) are the same key. Columns missing from a short row are null.
+func (k *uniqueKey) values(schema *TableSchema, row Row) []interface{} {
+	values := make([]interface{}, len(k.positions))
+	for i, position := range k.positions {
+		if position >= len(row) {
+			continue
+		}
+		values[i] = row[position]
+		if normalized, err := NormalizeValue(row[position], schema.ColumnSchemas[position].DataType); err == nil {
+			values[i] = normalized
+		}
+	}
+	return values
+}
+
+// encodeKey encodes the values of a key into a string that can be used as a map key.
+func encodeKey(values []interface{}) string {
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprint(values)
+	}
+	return string(encoded)
+}
+
+// encodeKeys returns the encoded values of each unique key of the table in the row, or an empty string for a key
+// having a null in it.
+func encodeKeys(schema *TableSchema, keys []uniqueKey, row Row) []string {
+	encoded := make([]string, len(keys))
+	for i := range keys {
+		if values := keys[i].values(schema, row); !hasNull(values) {
+			encoded[i] = encodeKey(values)
+		}
+	}
+	return encoded
+}
+
+func hasNull(values []interface{}) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveKeys checks the row against the primary key and the unique constraints of the table, and registers its keys
+// for the row with the given id if none of them is taken. It returns the encoded keys that are registered, which must
+// be released if the row is not written in the end. The caller must hold c.mu exclusively.
+func (c *Cluster) reserveKeys(tableName string, row Row, id int64) ([]string, error) {
+	schema := c.tableSchemas[tableName]
+	keys, err := schema.uniqueKeys()
+	if err != nil {
+		return nil, err
+	}
+	encoded := encodeKeys(schema, keys, row)
+	for i := range keys {
+		if encoded[i] == "" {
+			if keys[i].constraint == ConstraintPrimaryKey {
+				return nil, &ConstraintError{Table: tableName, Constraint: keys[i].constraint,
+					Columns: keys[i].columns, Values: keys[i].values(schema, row), Reason: "null value in"}
+			}
+			// rows with a null in a unique key never conflict
+			continue
+		}
+		if _, taken := c.uniqueKeyIds[tableName][i][encoded[i]]; taken {
+			return nil, &ConstraintError{Table: tableName, Constraint: keys[i].constraint,
+				Columns: keys[i].columns, Values: keys[i].values(schema, row), Reason: "duplicate key"}
+		}
+	}
+	for i, key := range encoded {
+		if key != "" {
+			c.uniqueKeyIds[tableName][i][key] = id
+		}
+	}
+	return encoded, nil
+}
+
+// releaseKeys removes the keys registered by reserveKeys. The caller must hold c.mu exclusively.
+func (c *Cluster) releaseKeys(tableName string, encoded []string) {
+	for i, key := range encoded {
+		if key != "" {
+			delete(c.uniqueKeyIds[tableName][i], key)
+		}
+	}
+}
+
+// foreignKey is a ForeignKey resolved against the schemas of the child and the parent table.
+type foreignKey struct {
+	ForeignKey
+	// the positions of Columns in the child table
+	positions []int
+	// the position of the referenced key in the uniqueKeys of the parent table
+	refKey int
+	// the types of the referenced columns, the values of the child are converted into them before being compared
+	refTypes []int
+}
+
+// foreignKeys resolves the foreign keys of the schema, looking up the schemas of the referenced tables with the given
+// function. An error is returned if a foreign key refers to unknown columns or tables, or if the referenced columns
+// are neither the primary key nor a unique group of the referenced table.
+func (s *TableSchema) foreignKeys(lookup func(tableName string) *TableSchema) ([]foreignKey, error) {
+	fks := make([]foreignKey, len(s.ForeignKeys))
+	for i, fk := range s.ForeignKeys {
+		parent := lookup(fk.RefTable)
+		if parent == nil {
+			return nil, fmt.Errorf("%s of %s refers to unknown table %s", ConstraintForeignKey, s.TableName,
+				fk.RefTable)
+		}
+		if len(fk.RefColumns) == 0 {
+			fk.RefColumns = parent.PrimaryKey
+		}
+		switch fk.OnDelete = strings.ToUpper(fk.OnDelete); fk.OnDelete {
+		case "":
+			fk.OnDelete = OnDeleteRestrict
+		case OnDeleteRestrict, OnDeleteCascade:
+		default:
+			return nil, fmt.Errorf("unknown action ON DELETE %s of %s", fk.OnDelete, s.TableName)
+		}
+		if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.RefColumns) {
+			return nil, fmt.Errorf("%s (%s) of %s does not match the referenced columns (%s) of %s",
+				ConstraintForeignKey, strings.Join(fk.Columns, ", "), s.TableName, strings.Join(fk.RefColumns, ", "),
+				fk.RefTable)
+		}
+		fks[i] = foreignKey{ForeignKey: fk, positions: make([]int, len(fk.Columns)), refKey: -1,
+			refTypes: make([]int, len(fk.Columns))}
+		for j, column := range fk.Columns {
+			if fks[i].positions[j] = s.ColumnIndex(column); fks[i].positions[j] < 0 {
+				return nil, fmt.Errorf("%s of %s refers to unknown column %s", ConstraintForeignKey, s.TableName,
+					column)
+			}
+		}
+		keys, err := parent.uniqueKeys()
+		if err != nil {
+			return nil, err
+		}
+		for k := range keys {
+			if strings.Join(keys[k].columns, "\x00") == strings.Join(fk.RefColumns, "\x00") {
+				fks[i].refKey = k
+				for j, position := range keys[k].positions {
+					fks[i].refTypes[j] = parent.ColumnSchemas[position].DataType
+				}
+				break
+			}
+		}
+		if fks[i].refKey < 0 {
+			return nil, fmt.Errorf("the columns (%s) referenced by %s are not a key of %s",
+				strings.Join(fk.RefColumns, ", "), s.TableName, fk.RefTable)
+		}
+	}
+	return fks, nil
+}
+
+// values returns the values of the foreign key in a row of the child table, converted into the types of the
+// referenced columns.
+func (fk *foreignKey) values(row Row) []interface{} {
+	values := make([]interface{}, len(fk.positions))
+	for i, position := range fk.positions {
+		if position >= len(row) {
+			continue
+		}
+		values[i] = row[position]
+		if normalized, err := NormalizeValue(row[position], fk.refTypes[i]); err == nil {
+			values[i] = normalized
+		}
+	}
+	return values
+}
+
+// lookupSchema returns the schema of a table, or nil if there is no such table. The caller must hold c.mu.
+func (c *Cluster) lookupSchema(tableName string) *TableSchema {
+	return c.tableSchemas[tableName]
+}
+
+// checkForeignKeys checks that the row references existing rows by each of the foreign keys of the table. The caller
+// must hold c.mu.
+func (c *Cluster) checkForeignKeys(tableName string, row Row) error {
+	fks, err := c.tableSchemas[tableName].foreignKeys(c.lookupSchema)
+	if err != nil {
+		return err
+	}
+	for i := range fks {
+		values := fks[i].values(row)
+		if hasNull(values) {
+			continue
+		}
+		if _, ok := c.uniqueKeyIds[fks[i].RefTable][fks[i].refKey][encodeKey(values)]; !ok {
+			return &ConstraintError{Table: tableName, Constraint: ConstraintForeignKey, Columns: fks[i].Columns,
+				Values: values, Reason: "no referenced key"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"../labrpc"
+)
+
+func setupConstraintCluster(t *testing.T, schema *TableSchema) (*Cluster, *labrpc.ClientEnd) {
+	c, _, cli := newTestCluster(2, "Constraint")
+
+	// the rows of a key may go to either of the horizontal fragments
+	m := map[string]interface{}{
+		"0": map[string]interface{}{
+			"predicate": map[string]interface{}{"grade": [...]map[string]interface{}{{"op": "<", "val": 3.0}}},
+			"column":    [...]string{"sid", "name", "email", "grade"},
+		},
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{"grade": [...]map[string]interface{}{{"op": ">=", "val": 3.0}}},
+			"column":    [...]string{"sid", "name", "email", "grade"},
+		},
+	}
+	rules, _ := json.Marshal(m)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	return c, cli
+}
+
+func constraintTestSchema() *TableSchema {
+	return &TableSchema{
+		TableName: "student",
+		ColumnSchemas: []ColumnSchema{
+			{Name: "sid", DataType: TypeInt32},
+			{Name: "name", DataType: TypeString},
+			{Name: "email", DataType: TypeString},
+			{Name: "grade", DataType: TypeDouble},
+		},
+		PrimaryKey: []string{"sid"},
+		Unique:     [][]string{{"email"}, {"name", "grade"}},
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	c, cli := setupConstraintCluster(t, constraintTestSchema())
+	reply := ""
+	cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{0, "John", "john@a.com", 2.0}}, &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot insert the first row: %v", reply)
+	}
+
+	// the duplicate goes to the other fragment, and is still rejected
+	cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{0, "Smith", "smith@a.com", 3.6}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a duplicate primary key should be rejected")
+	}
+	// keys are compared after the values are converted into the type of the column
+	err := c.Insert("student", Row{int32(0), "Smith", "smith@a.com", 3.6})
+	var constraintErr *ConstraintError
+	if !errors.As(err, &constraintErr) || constraintErr.Constraint != ConstraintPrimaryKey ||
+		constraintErr.Reason != "duplicate key" {
+		t.Errorf("expected a duplicate primary key, actual %v", err)
+	}
+	err = c.Insert("student", Row{nil, "Smith", "smith@a.com", 3.6})
+	if !errors.As(err, &constraintErr) || constraintErr.Reason != "null value in" {
+		t.Errorf("expected a null primary key, actual %v", err)
+	}
+
+	if err := c.Insert("student", Row{1, "Smith", "smith@a.com", 3.6}); err != nil {
+		t.Errorf("cannot insert a row with a new key: %v", err)
+	}
+	if c.rowCount("student") != 2 {
+		t.Errorf("expected 2 rows at the coordinator, actual %d", c.rowCount("student"))
+	}
+}
+
+func TestUniqueConstraints(t *testing.T) {
+	c, _ := setupConstraintCluster(t, constraintTestSchema())
+	if err := c.Insert("student", Row{0, "John", "john@a.com", 2.0}); err != nil {
+		t.Fatal(err.Error())
+	}
+	var constraintErr *ConstraintError
+	err := c.Insert("student", Row{1, "Smith", "john@a.com", 3.6})
+	if !errors.As(err, &constraintErr) || constraintErr.Constraint != ConstraintUnique ||
+		constraintErr.Columns[0] != "email" {
+		t.Errorf("expected a duplicate email, actual %v", err)
+	}
+	// only the combination of the columns of a group has to be unique
+	if err := c.Insert("student", Row{2, "John", "john@b.com", 3.6}); err != nil {
+		t.Errorf("cannot insert a row with a new name and grade: %v", err)
+	}
+	err = c.Insert("student", Row{3, "John", "john@c.com", 2})
+	if !errors.As(err, &constraintErr) || len(constraintErr.Columns) != 2 {
+		t.Errorf("expected a duplicate name and grade, actual %v", err)
+	}
+	// rows with null in a unique group never conflict
+	for i := 4; i < 6; i++ {
+		if err := c.Insert("student", Row{i, "Hana", nil, 4.0 + float64(i)}); err != nil {
+			t.Errorf("cannot insert a row with a null email: %v", err)
+		}
+	}
+	// the keys of a rejected row are not kept
+	if err := c.Insert("student", Row{1, "Smith", "smith@a.com", 3.6}); err != nil {
+		t.Errorf("the keys of the rejected row should be released: %v", err)
+	}
+}
+
+func TestInvalidConstraints(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(1, network, "InvalidConstraintCluster")
+	schema := constraintTestSchema()
+	schema.Unique = [][]string{{"unknown"}}
+	reply := ""
+	c.BuildTable([]interface{}{*schema, []byte("{}")}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a constraint on an unknown column should be rejected")
+	}
+	if err := c.Insert("student", Row{0, "John", "john@a.com", 2.0}); err == nil {
+		t.Errorf("a row should not be inserted into a table that is not built")
+	}
+}
+
+// student is on Node0 and courseRegistration on Node1, courseRegistration.sid references student.sid
+func setupForeignKeyCluster(t *testing.T, onDelete string) (*Cluster, *labrpc.ClientEnd) {
+	c, _, cli := newTestCluster(2, "ForeignKey")
+
+	student := &TableSchema{
+		TableName:     "student",
+		ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}, {Name: "name", DataType: TypeString}},
+		PrimaryKey:    []string{"sid"},
+	}
+	courseRegistration := &TableSchema{
+		TableName:     "courseRegistration",
+		ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt64}, {Name: "courseId", DataType: TypeInt32}},
+		ForeignKeys:   []ForeignKey{{Columns: []string{"sid"}, RefTable: "student", OnDelete: onDelete}},
+	}
+	for i, schema := range []*TableSchema{student, courseRegistration} {
+		partitionKey := []string{"sid", "courseId"}[i]
+		m := map[string]interface{}{
+			strconv.Itoa(i): map[string]interface{}{
+				"predicate": map[string]interface{}{partitionKey: [...]map[string]interface{}{{"op": ">=", "val": 0}}},
+				"column":    [...]string{schema.ColumnSchemas[0].Name, schema.ColumnSchemas[1].Name},
+			},
+		}
+		rules, _ := json.Marshal(m)
+		if err := buildTestTable(cli, schema, rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range []string{"John", "Smith", "Hana"} {
+		if err := c.Insert("student", Row{i, name}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	for _, registration := range []Row{{0, 0}, {0, 1}, {1, 0}} {
+		if err := c.Insert("courseRegistration", registration); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	return c, cli
+}
+
+func TestForeignKeyInsert(t *testing.T) {
+	c, cli := setupForeignKeyCluster(t, "")
+	reply := ""
+	cli.Call("Cluster.FragmentWrite", []interface{}{"courseRegistration", Row{5, 0}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a registration of an unknown student should be rejected")
+	}
+	var constraintErr *ConstraintError
+	if err := c.Insert("courseRegistration", Row{int64(5), 0}); !errors.As(err, &constraintErr) ||
+		constraintErr.Constraint != ConstraintForeignKey {
+		t.Errorf("expected a foreign key violation, actual %v", err)
+	}
+	// a null foreign key references nothing
+	if err := c.Insert("courseRegistration", Row{nil, 2}); err != nil {
+		t.Errorf("cannot insert a registration without a student: %v", err)
+	}
+	if err := c.Insert("courseRegistration", Row{int64(2), 2}); err != nil {
+		t.Errorf("cannot insert a registration of Hana: %v", err)
+	}
+}
+
+func TestForeignKeyRestrict(t *testing.T) {
+	c, cli := setupForeignKeyCluster(t, OnDeleteRestrict)
+	reply := ""
+	cli.Call("Cluster.FragmentDelete", []interface{}{"student", Predicate{"sid": {{Op: "<", Val: 1}}}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a referenced student should not be deleted")
+	}
+	var constraintErr *ConstraintError
+	if _, err := c.Delete("student", Predicate{"name": {{Op: "!=", Val: "Hana"}}}); !errors.As(err, &constraintErr) {
+		t.Errorf("expected a foreign key violation, actual %v", err)
+	}
+	if rows, _ := c.scanRows("student"); len(rows) != 3 {
+		t.Errorf("no student should be deleted, actual %v", rows)
+	}
+
+	deleted, err := c.Delete("student", Predicate{"name": {{Op: "=", Val: "Hana"}}})
+	if err != nil || deleted != 1 {
+		t.Fatalf("cannot delete Hana: %d, %v", deleted, err)
+	}
+	if rows, _ := c.scanRows("student"); len(rows) != 2 || c.rowCount("student") != 2 {
+		t.Errorf("expected 2 students, actual %v", rows)
+	}
+	// the key of the deleted row can be used again, and is no longer referenced
+	if err := c.Insert("courseRegistration", Row{2, 0}); err == nil {
+		t.Errorf("the deleted student should not be referenced")
+	}
+	if err := c.Insert("student", Row{2, "Alice"}); err != nil {
+		t.Errorf("the key of the deleted student should be released: %v", err)
+	}
+}
+
+func TestForeignKeyCascade(t *testing.T) {
+	c, _ := setupForeignKeyCluster(t, OnDeleteCascade)
+	deleted, err := c.Delete("student", Predicate{"name": {{Op: "=", Val: "John"}}})
+	if err != nil || deleted != 1 {
+		t.Fatalf("cannot delete John: %d, %v", deleted, err)
+	}
+	rows, _ := c.scanRows("courseRegistration")
+	if len(rows) != 1 || fmt.Sprint(rows[0][0]) != "1" {
+		t.Errorf("the registrations of John should be deleted, actual %v", rows)
+	}
+	if _, err := c.Delete("student", Predicate{}); err != nil {
+		t.Errorf("cannot delete all students: %v", err)
+	}
+	if rows, _ := c.scanRows("courseRegistration"); len(rows) != 0 {
+		t.Errorf("all registrations should be deleted, actual %v", rows)
+	}
+}
+
+func TestInvalidForeignKey(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(1, network, "InvalidForeignKeyCluster")
+	schema := TableSchema{
+		TableName:     "courseRegistration",
+		ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}},
+		ForeignKeys:   []ForeignKey{{Columns: []string{"sid"}, RefTable: "student"}},
+	}
+	reply := ""
+	c.BuildTable([]interface{}{schema, []byte("{}")}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a foreign key to an unknown table should be rejected")
+	}
+	// a table can reference its own primary key
+	schema.TableName = "student"
+	schema.PrimaryKey = []string{"sid"}
+	c.BuildTable([]interface{}{schema, []byte(`{"0": {"default": true, "column": ["sid"]}}`)}, &reply)
+	if reply != "0 OK" {
+		t.Errorf("a foreign key to the table itself should be accepted: %v", reply)
+	}
+}
+
+func TestColumnConstraints(t *testing.T) {
+	schema := &TableSchema{
+		TableName: "student",
+		ColumnSchemas: []ColumnSchema{
+			{Name: "sid", DataType: TypeInt32, NotNull: true},
+			{Name: "name", DataType: TypeString, Default: "anonymous"},
+			{Name: "email", DataType: TypeString, Check: &Predicate{"email": {{Op: "!=", Val: ""}}}},
+			{Name: "grade", DataType: TypeDouble, Default: json.Number("2.5"),
+				Check: &Predicate{"grade": {{Op: ">=", Val: 0}, {Op: "<=", Val: json.Number("4")}}}},
+		},
+	}
+	c, cli := setupConstraintCluster(t, schema)
+	reply := ""
+	cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{0, "John", "john@a.com", 4.5}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a grade above 4 should be rejected")
+	}
+	var constraintErr *ConstraintError
+	if err := c.Insert("student", Row{1, "Smith", nil, -1}); !errors.As(err, &constraintErr) ||
+		constraintErr.Constraint != ConstraintCheck {
+		t.Errorf("expected a CHECK violation, actual %v", err)
+	}
+	if err := c.Insert("student", Row{nil, "Smith"}); !errors.As(err, &constraintErr) ||
+		constraintErr.Constraint != ConstraintNotNull || constraintErr.Columns[0] != "sid" {
+		t.Errorf("expected a NOT NULL violation, actual %v", err)
+	}
+	if err := c.Insert("student", Row{0, "John", nil, 4.0, "extra"}); err == nil {
+		t.Errorf("a row longer than the schema should be rejected")
+	}
+
+	// a null email is not rejected by the CHECK, and the short row takes the defaults
+	if err := c.Insert("student", Row{0, "John", "", 4.0}); !errors.As(err, &constraintErr) {
+		t.Errorf("expected a CHECK violation, actual %v", err)
+	}
+	if err := c.Insert("student", Row{0, "John", nil, 4.0}); err != nil {
+		t.Errorf("cannot insert a null email: %v", err)
+	}
+	if err := c.Insert("student", Row{1}); err != nil {
+		t.Errorf("cannot insert a short row: %v", err)
+	}
+	rows, _ := c.scanRows("student")
+	if len(rows) != 2 || rows[1][1] != "anonymous" || rows[1][2] != nil || fmt.Sprint(rows[1][3]) != "2.5" {
+		t.Errorf("expected the defaults in the short row, actual %v", rows)
+	}
+	// the constraints keep the column schemas comparable
+	if c.tableSchemas["student"].ColumnSchemas[0] != (ColumnSchema{Name: "sid", DataType: TypeInt32, NotNull: true}) {
+		t.Errorf("unexpected column %v", c.tableSchemas["student"].ColumnSchemas[0])
+	}
+}
+
+func TestInvalidColumnConstraints(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(1, network, "InvalidColumnConstraintCluster")
+	for _, cs := range []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32, Default: "zero"},
+		{Name: "sid", DataType: TypeInt32, Check: &Predicate{"sid": {{Op: ">", Val: "zero"}}}},
+		{Name: "sid", DataType: TypeInt32, Check: &Predicate{"unknown": {{Op: ">", Val: 0}}}},
+	} {
+		reply := ""
+		c.BuildTable([]interface{}{TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{cs}},
+			[]byte("{}")}, &reply)
+		if reply == "0 OK" {
+			t.Errorf("the constraints of %v should be rejected", cs)
+		}
+	}
+}
+
+func TestTypeValidation(t *testing.T) {
+	c, cli := setupConstraintCluster(t, constraintTestSchema())
+	reply := ""
+	cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{"zero", "John", "john@a.com", 2.0}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a string should not be inserted into an int32 column")
+	}
+	for _, row := range []Row{
+		{int64(1) << 40, "John", "john@a.com", 2.0},
+		{0.5, "John", "john@a.com", 2.0},
+		{0, 1, "john@a.com", 2.0},
+		{0, "John", "john@a.com", true},
+	} {
+		if err := c.Insert("student", row); err == nil {
+			t.Errorf("%v should be rejected", row)
+		}
+	}
+
+	// the values are stored in the canonical types of their columns
+	for i, row := range []Row{
+		{0, "John", "john@a.com", 2},
+		{json.Number("1"), "Smith", "smith@a.com", json.Number("3.6")},
+		{2.0, "Hana", "hana@a.com", float32(4)},
+	} {
+		if err := c.Insert("student", row); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+	rows, _ := c.scanRows("student")
+	for i, row := range rows {
+		if row[0] != int32(i) {
+			t.Errorf("expected sid int32(%d), actual %v (%T)", i, row[0], row[0])
+		}
+		if _, ok := row[3].(float64); !ok {
+			t.Errorf("expected a float64 grade, actual %v (%T)", row[3], row[3])
+		}
+	}
+}
@@ -1,6 +1,6 @@
-package models
-
-type Dataset struct {
-	Schema TableSchema
-	Rows []Row
-}
+package models
+
+type Dataset struct {
+	Schema TableSchema
+	Rows []Row
+}
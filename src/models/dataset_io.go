@@ -0,0 +1,329 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// enumeration of the formats rows are imported from and exported to
+const (
+	// FormatCSV is comma-separated values with a header naming the columns, null being nullField
+	FormatCSV = iota
+	// FormatJSONL is JSON Lines, an object per row whose keys are the names of the columns
+	FormatJSONL
+)
+
+// ParseFileFormat converts the name of a format, e.g., "csv" or "jsonl", into one of the constants above.
+func ParseFileFormat(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return FormatCSV, nil
+	case "jsonl", "ndjson":
+		return FormatJSONL, nil
+	}
+	return -1, fmt.Errorf("unknown format %s", name)
+}
+
+// FileFormatName returns the name of a format, e.g., "jsonl" for FormatJSONL.
+func FileFormatName(format int) string {
+	switch format {
+	case FormatCSV:
+		return "csv"
+	case FormatJSONL:
+		return "jsonl"
+	}
+	return "unknown"
+}
+
+// FileFormatOf returns the format of a file from its extension, e.g., FormatCSV for "student.csv".
+func FileFormatOf(path string) (int, error) {
+	return ParseFileFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// ReadRows decodes the rows of a table from a reader. The columns are matched with the schema by name, in any order,
+// and the columns of the schema a file does not have take their defaults. Each value is converted into the canonical
+// Go type of its column, see NormalizeValue, and a row that cannot be converted is reported as a failure with its
+// position in the file, the others being returned in order. The position of a row is the index of its record after
+// the header in FormatCSV, and of its line in FormatJSONL, blank lines included. An error is returned if the file
+// itself is malformed, e.g., a header names an unknown column.
+func ReadRows(r io.Reader, format int, schema TableSchema) ([]Row, []RowFailure, error) {
+	rows, _, failures, err := readRows(r, format, schema)
+	return rows, failures, err
+}
+
+// readRows decodes rows like ReadRows does, and also returns the position in the file of each row returned.
+func readRows(r io.Reader, format int, schema TableSchema) ([]Row, []int, []RowFailure, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r, schema)
+	case FormatJSONL:
+		return readJSONL(r, schema)
+	}
+	return nil, nil, nil, fmt.Errorf("unknown format %d", format)
+}
+
+func readCSV(r io.Reader, schema TableSchema) ([]Row, []int, []RowFailure, error) {
+	reader := csv.NewReader(r)
+	// a record of another length than the header is a failure of its row rather than of the file
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil, fmt.Errorf("no header")
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	positions, err := columnPositions(schema, header)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rows := make([]Row, 0)
+	rowPositions := make([]int, 0)
+	failures := make([]RowFailure, 0)
+	for position := 0; ; position++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(record) != len(header) {
+			failures = append(failures, RowFailure{Row: position,
+				Reason: fmt.Sprintf("expected %d fields, actual %d", len(header), len(record))})
+			continue
+		}
+		row := defaultRow(schema)
+		for i, field := range record {
+			cs := schema.ColumnSchemas[positions[i]]
+			if row[positions[i]], err = parseValue(field, cs.DataType); err != nil {
+				err = fmt.Errorf("column %s of %s: %v", cs.Name, schema.TableName, err)
+				break
+			}
+		}
+		if err != nil {
+			failures = append(failures, RowFailure{Row: position, Reason: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+		rowPositions = append(rowPositions, position)
+	}
+	return rows, rowPositions, failures, nil
+}
+
+func readJSONL(r io.Reader, schema TableSchema) ([]Row, []int, []RowFailure, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	rows := make([]Row, 0)
+	rowPositions := make([]int, 0)
+	failures := make([]RowFailure, 0)
+	for position := 0; scanner.Scan(); position++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row, err := decodeJSONRow(line, schema)
+		if err != nil {
+			failures = append(failures, RowFailure{Row: position, Reason: err.Error()})
+		} else {
+			rows = append(rows, row)
+			rowPositions = append(rowPositions, position)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return rows, rowPositions, failures, nil
+}
+
+// decodeJSONRow decodes a line of JSON Lines into a row of the table.
+func decodeJSONRow(line []byte, schema TableSchema) (Row, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	object := make(map[string]interface{})
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	row := defaultRow(schema)
+	for name, value := range object {
+		i := schema.ColumnIndex(name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		v, err := NormalizeValue(value, schema.ColumnSchemas[i].DataType)
+		if err != nil {
+			return nil, fmt.Errorf("column %s of %s: %v", name, schema.TableName, err)
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// columnPositions returns the position in the schema of each column of a header.
+func columnPositions(schema TableSchema, header []string) ([]int, error) {
+	positions := make([]int, len(header))
+	seen := make(map[int]bool)
+	for i, name := range header {
+		positions[i] = schema.ColumnIndex(strings.TrimSpace(name))
+		if positions[i] < 0 {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		if seen[positions[i]] {
+			return nil, fmt.Errorf("duplicate column %s", name)
+		}
+		seen[positions[i]] = true
+	}
+	return positions, nil
+}
+
+// defaultRow returns a row of the table holding the default of each column.
+func defaultRow(schema TableSchema) Row {
+	row := make(Row, len(schema.ColumnSchemas))
+	for i, cs := range schema.ColumnSchemas {
+		row[i] = cs.Default
+	}
+	return row
+}
+
+// nullField is null in a CSV file, which cannot tell an empty field from an empty quoted one. A string made of
+// backslashes followed by N is written with one more backslash, so that it is not read as null, see formatValue.
+const nullField = `\N`
+
+// parseValue converts a field of a CSV file into the canonical Go type of a datatype. Besides nullField, an empty
+// field is null unless the column is a string.
+func parseValue(field string, dataType int) (interface{}, error) {
+	if field == nullField || field == "" && dataType != TypeString {
+		return nil, nil
+	}
+	var value interface{}
+	var err error
+	switch dataType {
+	case TypeInt32:
+		var v int64
+		v, err = strconv.ParseInt(strings.TrimSpace(field), 10, 32)
+		value = int32(v)
+	case TypeInt64:
+		value, err = strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+	case TypeFloat:
+		var v float64
+		v, err = strconv.ParseFloat(strings.TrimSpace(field), 32)
+		value = float32(v)
+	case TypeDouble:
+		value, err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+	case TypeBoolean:
+		value, err = strconv.ParseBool(strings.TrimSpace(field))
+	case TypeString:
+		value = field
+		if isEscapedNull(field) {
+			value = field[1:]
+		}
+	default:
+		err = fmt.Errorf("unknown datatype %d", dataType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q does not conform to %s", field, DataTypeName(dataType))
+	}
+	return value, nil
+}
+
+// WriteDataset encodes the rows of a dataset to a writer, with the names of the columns of its schema: a header in
+// FormatCSV, where null is written as nullField, or the keys of each object in FormatJSONL, in the order of the
+// columns.
+func WriteDataset(w io.Writer, format int, dataset Dataset) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, dataset)
+	case FormatJSONL:
+		return writeJSONL(w, dataset)
+	}
+	return fmt.Errorf("unknown format %d", format)
+}
+
+func writeCSV(w io.Writer, dataset Dataset) error {
+	writer := csv.NewWriter(w)
+	record := make([]string, len(dataset.Schema.ColumnSchemas))
+	for i, cs := range dataset.Schema.ColumnSchemas {
+		record[i] = cs.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+	for _, row := range dataset.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = formatValue(row[i])
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatValue writes a value as a field of a CSV file, which parseValue reads back.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return nullField
+	case string:
+		if v == nullField || isEscapedNull(v) {
+			return `\` + v
+		}
+		return v
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// isEscapedNull tells whether a field is made of several backslashes followed by N, i.e., is a string ending with
+// nullField written with one more backslash.
+func isEscapedNull(field string) bool {
+	return len(field) > len(nullField) && strings.TrimLeft(field, `\`) == "N"
+}
+
+func writeJSONL(w io.Writer, dataset Dataset) error {
+	writer := bufio.NewWriter(w)
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	for _, row := range dataset.Rows {
+		buffer.Reset()
+		buffer.WriteByte('{')
+		for i, cs := range dataset.Schema.ColumnSchemas {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			var value interface{}
+			if i < len(row) {
+				value = row[i]
+			}
+			if err := encoder.Encode(cs.Name); err != nil {
+				return err
+			}
+			buffer.Truncate(buffer.Len() - 1)
+			buffer.WriteByte(':')
+			if err := encoder.Encode(value); err != nil {
+				return fmt.Errorf("column %s: %v", cs.Name, err)
+			}
+			buffer.Truncate(buffer.Len() - 1)
+		}
+		buffer.WriteString("}\n")
+		if _, err := writer.Write(buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
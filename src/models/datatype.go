@@ -1,11 +1,129 @@
-package models
-
-// enumeration of datatype
-const (
-	TypeInt32 = iota
-	TypeInt64
-	TypeFloat
-	TypeDouble
-	TypeBoolean
-	TypeString
-)
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// enumeration of datatype
+const (
+	TypeInt32 = iota
+	TypeInt64
+	TypeFloat
+	TypeDouble
+	TypeBoolean
+	TypeString
+)
+
+// NormalizeValue converts a value into the canonical Go type of the given datatype, which is int32, int64, float32,
+// float64, bool or string respectively. Other Go numbers and json.Number are converted if CheckType accepts them, and
+// nil is kept as it is. An error is returned if the value does not conform to the datatype.
+func NormalizeValue(value interface{}, dataType int) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if !CheckType(value, dataType) {
+		return nil, fmt.Errorf("%v (%T) does not conform to %s", value, value, DataTypeName(dataType))
+	}
+	switch dataType {
+	case TypeInt32:
+		v, _ := toInt64(value)
+		return int32(v), nil
+	case TypeInt64:
+		v, _ := toInt64(value)
+		return v, nil
+	case TypeFloat:
+		v, _ := toFloat64(value)
+		return float32(v), nil
+	case TypeDouble:
+		v, _ := toFloat64(value)
+		return v, nil
+	}
+	// booleans and strings are accepted by CheckType only if they already have the right type
+	return value, nil
+}
+
+// DataTypeName returns the name of a datatype used in messages, e.g., "int32".
+func DataTypeName(dataType int) string {
+	switch dataType {
+	case TypeInt32:
+		return "int32"
+	case TypeInt64:
+		return "int64"
+	case TypeFloat:
+		return "float"
+	case TypeDouble:
+		return "double"
+	case TypeBoolean:
+		return "boolean"
+	case TypeString:
+		return "string"
+	}
+	return "unknown"
+}
+
+// toInt64 converts any Go number or json.Number holding an integer into an int64.
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		f, err := v.Float64()
+		return int64(f), err == nil && float64(int64(f)) == f
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float32:
+		return int64(v), float32(int64(v)) == v
+	case float64:
+		return int64(v), float64(int64(v)) == v
+	}
+	return 0, false
+}
+
+// toFloat64 converts any Go number or json.Number into a float64.
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// ValuesEqual compares two values of a row, numbers being compared by their values regardless of their Go types,
+// e.g., 1 equals int32(1) and json.Number("1"). A float32 is compared with another number at the precision of
+// float32, so that float32(3.6) equals 3.6. Null only equals null.
+func ValuesEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	c, ok := compareValues(a, b)
+	return ok && c == 0
+}
+
+// isInteger tells whether a value is a Go integer or a json.Number written as an integer.
+func isInteger(value interface{}) bool {
+	switch v := value.(type) {
+	case int, int32, int64:
+		return true
+	case json.Number:
+		_, err := v.Int64()
+		return err == nil
+	}
+	return false
+}
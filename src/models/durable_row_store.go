@@ -0,0 +1,408 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"../labgob"
+)
+
+// operations recorded in the write-ahead log
+const (
+	walInsert = iota
+	walRemove
+)
+
+// the default number of log records after which the rows are checkpointed into a new segment
+const defaultCheckpointThreshold = 1024
+
+// DurableRowStore keeps a copy of the rows in memory to serve reads, and makes every modification durable by appending
+// it to a write-ahead log (WAL) before applying it to the memory copy.
+// Once the log holds checkpointThreshold records, all rows are written into a new segment file and a new empty log is
+// started. The files of generation k are "segment-k", the rows as of the checkpoint, and "wal-k", the modifications
+// after it, so a crash in the middle of a checkpoint never makes a record applied twice.
+// Every record in a file is framed as [length uint32][crc32 uint32][payload] so that a torn write at the tail of the
+// log is detected and discarded during recovery.
+type DurableRowStore struct {
+	dir                 string
+	memory              *MemoryListRowStore
+	wal                 *os.File
+	walSize             int64
+	generation          int
+	walRecords          int
+	checkpointThreshold int
+}
+
+type walRecord struct {
+	Op  int
+	Row Row
+}
+
+func init() {
+	labgob.Register(json.Number(""))
+	labgob.Register([]interface{}{})
+}
+
+// OpenDurableRowStore opens the store kept in the given directory, creating the directory if it does not exist, and
+// recovers the rows from the latest segment and the log written after it.
+func OpenDurableRowStore(dir string) (*DurableRowStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &DurableRowStore{dir: dir, memory: NewMemoryListRowStore(), checkpointThreshold: defaultCheckpointThreshold}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *DurableRowStore) segmentPath(generation int) string {
+	return filepath.Join(s.dir, "segment-"+strconv.Itoa(generation))
+}
+
+func (s *DurableRowStore) walPath(generation int) string {
+	return filepath.Join(s.dir, "wal-"+strconv.Itoa(generation))
+}
+
+// recover loads the newest segment, replays its log and truncates the log after the last intact record.
+func (s *DurableRowStore) recover() error {
+	generations, err := s.segmentGenerations()
+	if err != nil {
+		return err
+	}
+	if len(generations) > 0 {
+		s.generation = generations[len(generations)-1]
+		rows, err := readSegment(s.segmentPath(s.generation))
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			s.memory.insert(&rows[i])
+		}
+	}
+
+	wal, err := os.OpenFile(s.walPath(s.generation), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := wal.Stat()
+	if err != nil {
+		wal.Close()
+		return err
+	}
+	valid := int64(0)
+	reader := bufio.NewReader(wal)
+	for {
+		payload, n, err := readFrame(reader, info.Size()-valid)
+		if err != nil {
+			// a torn or corrupted record can only be the tail of the log, everything after it is discarded
+			break
+		}
+		record := walRecord{}
+		if err := labgob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+			break
+		}
+		s.apply(&record)
+		valid += n
+		s.walRecords++
+	}
+	if err := wal.Truncate(valid); err != nil {
+		wal.Close()
+		return err
+	}
+	if _, err := wal.Seek(valid, io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+	s.wal = wal
+	s.walSize = valid
+	// files of older generations are left behind when a crash happens right after a checkpoint
+	for _, generation := range generations {
+		if generation < s.generation {
+			os.Remove(s.segmentPath(generation))
+			os.Remove(s.walPath(generation))
+		}
+	}
+	return nil
+}
+
+// segmentGenerations lists the generations of the segments in the directory in ascending order.
+func (s *DurableRowStore) segmentGenerations() ([]int, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	generations := make([]int, 0)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "segment-") {
+			continue
+		}
+		// temporary files of unfinished checkpoints do not parse and are ignored
+		if generation, err := strconv.Atoi(strings.TrimPrefix(file.Name(), "segment-")); err == nil {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Ints(generations)
+	return generations, nil
+}
+
+func (s *DurableRowStore) apply(record *walRecord) {
+	switch record.Op {
+	case walInsert:
+		s.memory.insert(&record.Row)
+	case walRemove:
+		s.memory.remove(&record.Row)
+	}
+}
+
+// log appends a record to the WAL and flushes it to the disk, and then applies it to the memory copy. An error is
+// returned only if the record is not in the log.
+func (s *DurableRowStore) log(record *walRecord) error {
+	buffer := new(bytes.Buffer)
+	if err := labgob.NewEncoder(buffer).Encode(record); err != nil {
+		return err
+	}
+	framed := frame(buffer.Bytes())
+	if _, err := s.wal.Write(framed); err != nil {
+		// drop the partially written record, otherwise the records appended after it could not be recovered
+		s.wal.Truncate(s.walSize)
+		s.wal.Seek(s.walSize, io.SeekStart)
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.walSize += int64(len(framed))
+	s.apply(record)
+	s.walRecords++
+	if s.walRecords >= s.checkpointThreshold && s.checkpoint() != nil {
+		// the record is durable in the log already, the checkpoint is tried again after as many records
+		s.walRecords = 0
+	}
+	return nil
+}
+
+// checkpoint writes all rows into the segment of the next generation and switches to an empty log.
+func (s *DurableRowStore) checkpoint() error {
+	rows := make([]Row, 0, s.memory.count())
+	iterator := s.memory.iterator()
+	for iterator.HasNext() {
+		rows = append(rows, *iterator.Next())
+	}
+	buffer := new(bytes.Buffer)
+	if err := labgob.NewEncoder(buffer).Encode(rows); err != nil {
+		return err
+	}
+	next := s.generation + 1
+	if err := writeFileAtomically(s.segmentPath(next), frame(buffer.Bytes())); err != nil {
+		return err
+	}
+	wal, err := os.OpenFile(s.walPath(next), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.wal.Close()
+	os.Remove(s.walPath(s.generation))
+	os.Remove(s.segmentPath(s.generation))
+	s.wal = wal
+	s.walSize = 0
+	s.generation = next
+	s.walRecords = 0
+	return nil
+}
+
+func (s *DurableRowStore) count() int {
+	return s.memory.count()
+}
+
+func (s *DurableRowStore) iterator() RowIterator {
+	return s.memory.iterator()
+}
+
+func (s *DurableRowStore) insert(row *Row) error {
+	return s.log(&walRecord{Op: walInsert, Row: *row})
+}
+
+func (s *DurableRowStore) remove(row *Row) error {
+	return s.log(&walRecord{Op: walRemove, Row: *row})
+}
+
+func (s *DurableRowStore) close() error {
+	return s.wal.Close()
+}
+
+// frame prefixes the payload with its length and checksum.
+func frame(payload []byte) []byte {
+	buffer := make([]byte, 8+len(payload))
+	binary.LittleEndian.PutUint32(buffer[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buffer[4:8], crc32.ChecksumIEEE(payload))
+	copy(buffer[8:], payload)
+	return buffer
+}
+
+// readFrame reads a framed payload out of the given number of remaining bytes, and returns it with the number of bytes
+// consumed. An error is returned if the frame is incomplete, e.g., its length exceeds the remaining bytes, or if its
+// checksum does not match.
+func readFrame(reader io.Reader, remaining int64) ([]byte, int64, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[0:4]))
+	if length > remaining-int64(len(header)) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	return payload, int64(8 + len(payload)), nil
+}
+
+func readSegment(path string) ([]Row, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	payload, _, err := readFrame(file, fileSize(file))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted segment %s: %v", path, err)
+	}
+	rows := make([]Row, 0)
+	if err := labgob.NewDecoder(bytes.NewReader(payload)).Decode(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// fileSize returns the size of an open file, or 0 if it cannot be known.
+func fileSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// writeFileAtomically makes the file either contain the whole content or not exist, even if the process crashes.
+func writeFileAtomically(path string, content []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// tableDir is the directory holding the files of a table. Fragment names like "student|0" are escaped.
+func tableDir(dataDir string, tableName string) string {
+	return filepath.Join(dataDir, url.PathEscape(tableName))
+}
+
+// the suffixes of the directories a durable table is rewritten in, see Node.replaceDurableTable. The escaped names of
+// the tables never contain "#", so that these directories are not taken for those of other tables.
+const (
+	newTableDirSuffix = "#new"
+	oldTableDirSuffix = "#old"
+)
+
+// finishReplace completes the rewriting of the durable table in the given directory if it was interrupted, before the
+// table is recovered. The new rows are kept if they were complete, i.e., if the old directory was moved away already,
+// and the old rows otherwise.
+func finishReplace(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err := os.Rename(dir+newTableDirSuffix, dir)
+		if os.IsNotExist(err) {
+			err = os.Rename(dir+oldTableDirSuffix, dir)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.RemoveAll(dir + newTableDirSuffix); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir + oldTableDirSuffix)
+}
+
+// tableMeta is what a node needs besides the rows to recover a durable table.
+type tableMeta struct {
+	Schema     TableSchema
+	FullSchema *TableSchema
+	Predicate  *Predicate
+	Storage    int
+	// column name -> kind of index
+	Indexes map[string]int
+}
+
+func writeTableMeta(dir string, meta *tableMeta) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	buffer := new(bytes.Buffer)
+	if err := labgob.NewEncoder(buffer).Encode(meta); err != nil {
+		return err
+	}
+	return writeFileAtomically(filepath.Join(dir, "meta"), frame(buffer.Bytes()))
+}
+
+// recoverTable reopens the durable table kept in the given directory, or returns (nil, nil) if the directory does not
+// hold a table.
+func recoverTable(dir string) (*Table, error) {
+	file, err := os.Open(filepath.Join(dir, "meta"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	payload, _, err := readFrame(file, fileSize(file))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted table meta in %s: %v", dir, err)
+	}
+	meta := tableMeta{}
+	if err := labgob.NewDecoder(bytes.NewReader(payload)).Decode(&meta); err != nil {
+		return nil, err
+	}
+	store, err := OpenDurableRowStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTable(&meta.Schema, store)
+	t.fullSchema = meta.FullSchema
+	t.predicate = meta.Predicate
+	t.storage = StorageDurable
+	if err := t.createIndexes(meta.Indexes); err != nil {
+		store.close()
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"../labrpc"
+)
+
+func collectRows(iterator RowIterator) []Row {
+	rows := make([]Row, 0)
+	for iterator.HasNext() {
+		rows = append(rows, *iterator.Next())
+	}
+	return rows
+}
+
+func TestDurableRowStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows := []Row{
+		{"John", 22, 4.0},
+		{"Smith", 23, 3.6},
+		{"Hana", 21, json.Number("4.0")},
+	}
+	for _, row := range rows {
+		if err := s.insert(&row); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := s.remove(&rows[1]); err != nil {
+		t.Fatal(err.Error())
+	}
+	s.close()
+
+	s, err = OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s.close()
+	recovered := collectRows(s.iterator())
+	if !compareRows(recovered, []Row{rows[0], rows[2]}, []int{0, 1, 2}) {
+		t.Errorf("expected %v after recovery, actual %v", []Row{rows[0], rows[2]}, recovered)
+	}
+}
+
+// a crash in the middle of appending a record leaves a torn record at the tail of the log
+func TestDurableRowStoreTruncatedLog(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	rows := []Row{{0, "a"}, {1, "b"}, {2, "c"}}
+	for _, row := range rows {
+		s.insert(&row)
+	}
+	s.close()
+
+	wal := s.walPath(0)
+	info, err := os.Stat(wal)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// cut the last record in half
+	if err := os.Truncate(wal, info.Size()-5); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	s, err = OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if recovered := collectRows(s.iterator()); !compareRows(recovered, rows[:2], []int{0, 1}) {
+		t.Errorf("expected %v after recovery, actual %v", rows[:2], recovered)
+	}
+	// the torn record is discarded, so new records are recoverable
+	row := Row{3, "d"}
+	s.insert(&row)
+	s.close()
+
+	s, err = OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s.close()
+	expected := []Row{rows[0], rows[1], row}
+	if recovered := collectRows(s.iterator()); !compareRows(recovered, expected, []int{0, 1}) {
+		t.Errorf("expected %v after recovery, actual %v", expected, recovered)
+	}
+}
+
+// a corrupted header of the last record must not be mistaken for a valid record
+func TestDurableRowStoreCorruptedLog(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenDurableRowStore(dir)
+	rows := []Row{{0, "a"}, {1, "b"}}
+	for _, row := range rows {
+		s.insert(&row)
+	}
+	s.close()
+
+	content, _ := ioutil.ReadFile(s.walPath(0))
+	content[len(content)-1] ^= 0xff
+	ioutil.WriteFile(s.walPath(0), content, 0644)
+
+	s, err := OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if recovered := collectRows(s.iterator()); !compareRows(recovered, rows[:1], []int{0, 1}) {
+		t.Errorf("expected %v after recovery, actual %v", rows[:1], recovered)
+	}
+	s.close()
+
+	// nor a length beyond the end of the log
+	content, _ = ioutil.ReadFile(s.walPath(0))
+	ioutil.WriteFile(s.walPath(0), append(content, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0), 0644)
+	s, err = OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s.close()
+	if recovered := collectRows(s.iterator()); !compareRows(recovered, rows[:1], []int{0, 1}) {
+		t.Errorf("expected %v after recovery, actual %v", rows[:1], recovered)
+	}
+	if info, _ := os.Stat(s.walPath(0)); info.Size() != int64(len(content)) {
+		t.Errorf("expected the log truncated to %d bytes, actual %d", len(content), info.Size())
+	}
+}
+
+func TestDurableRowStoreCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenDurableRowStore(dir)
+	s.checkpointThreshold = 4
+	expected := make([]Row, 0)
+	for i := 0; i < 10; i++ {
+		row := Row{i, "value"}
+		s.insert(&row)
+		expected = append(expected, row)
+	}
+	s.remove(&expected[0])
+	expected = expected[1:]
+	if s.generation != 2 {
+		t.Errorf("expected 2 checkpoints, actual %d", s.generation)
+	}
+	s.close()
+
+	// an unfinished checkpoint leaves a temporary segment behind
+	ioutil.WriteFile(filepath.Join(dir, "segment-3.tmp"), []byte("garbage"), 0644)
+
+	s, err := OpenDurableRowStore(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s.close()
+	if recovered := collectRows(s.iterator()); !compareRows(recovered, expected, []int{0, 1}) {
+		t.Errorf("expected %v after recovery, actual %v", expected, recovered)
+	}
+}
+
+// a write is not failed by a checkpoint that fails after it is logged
+func TestDurableRowStoreCheckpointFailure(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := OpenDurableRowStore(dir)
+	defer s.close()
+	s.checkpointThreshold = 2
+	// the segment of the next generation cannot be written
+	blocked := s.segmentPath(1) + ".tmp"
+	os.Mkdir(blocked, 0755)
+	rows := []Row{{0, "a"}, {1, "b"}, {2, "c"}, {3, "d"}}
+	for i := range rows[:2] {
+		if err := s.insert(&rows[i]); err != nil {
+			t.Errorf("the insertion of %v should succeed, actual %v", rows[i], err)
+		}
+	}
+	if s.generation != 0 || s.count() != 2 {
+		t.Errorf("expected 2 rows and no checkpoint, actual %d rows in generation %d", s.count(), s.generation)
+	}
+	// the checkpoint is tried again at the next threshold
+	os.Remove(blocked)
+	for i := range rows[2:] {
+		s.insert(&rows[2+i])
+	}
+	if s.generation != 1 || s.count() != 4 {
+		t.Errorf("expected 4 rows and a checkpoint, actual %d rows in generation %d", s.count(), s.generation)
+	}
+}
+
+func TestDurableNodeRecovery(t *testing.T) {
+	dir := t.TempDir()
+	n, err := NewNodeWithDataDir("Node0", dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	schema := &TableSchema{TableName: "table|0", ColumnSchemas: []ColumnSchema{
+		{Name: "name", DataType: TypeString},
+		{Name: "age", DataType: TypeInt32},
+	}}
+	if err := n.CreateTableWithStorage(schema, StorageDurable); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := n.CreateTable(&TableSchema{TableName: "volatile", ColumnSchemas: schema.ColumnSchemas}); err != nil {
+		t.Fatal(err.Error())
+	}
+	rows := []Row{{"John", 22}, {"Smith", 23}}
+	for _, row := range rows {
+		n.Insert("table|0", &row)
+		n.Insert("volatile", &row)
+	}
+	n.Close()
+
+	n, err = NewNodeWithDataDir("Node0", dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer n.Close()
+	if _, err := n.IterateTable("volatile"); err == nil {
+		t.Errorf("in-memory tables should not be recovered")
+	}
+	iterator, err := n.IterateTable("table|0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if recovered := collectRows(iterator); !compareRows(recovered, rows, []int{0, 1}) {
+		t.Errorf("expected %v after recovery, actual %v", rows, recovered)
+	}
+	if err := n.CreateTableWithStorage(schema, StorageDurable); err == nil {
+		t.Errorf("a recovered table should not be created again")
+	}
+}
+
+func TestDurableTableRewrite(t *testing.T) {
+	dir := t.TempDir()
+	n, err := NewNodeWithDataDir("Node0", dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	schema := &TableSchema{TableName: "table|0", ColumnSchemas: []ColumnSchema{{Name: "name", DataType: TypeString}}}
+	if err := n.CreateTableWithStorage(schema, StorageDurable); err != nil {
+		t.Fatal(err.Error())
+	}
+	n.Insert("table|0", &Row{"John"})
+	altered := &TableSchema{TableName: "table|0", ColumnSchemas: []ColumnSchema{
+		{Name: "name", DataType: TypeString},
+		{Name: "age", DataType: TypeInt32, Default: int32(18)},
+	}}
+	if err := n.alterTable(altered, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	n.Close()
+
+	expected := []Row{{"John", int32(18)}}
+	reopen := func() {
+		n, err := NewNodeWithDataDir("Node0", dir)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer n.Close()
+		iterator, err := n.IterateTable("table|0")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if recovered := collectRows(iterator); !compareRows(recovered, expected, []int{0, 1}) {
+			t.Errorf("expected %v after recovery, actual %v", expected, recovered)
+		}
+		for _, suffix := range []string{newTableDirSuffix, oldTableDirSuffix} {
+			if _, err := os.Stat(tableDir(dir, "table|0") + suffix); !os.IsNotExist(err) {
+				t.Errorf("the directory %s should be removed by the recovery", suffix)
+			}
+		}
+	}
+	reopen()
+
+	// a crash after the old rows are moved away keeps the new ones
+	path := tableDir(dir, "table|0")
+	if err := os.Rename(path, path+newTableDirSuffix); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.Mkdir(path+oldTableDirSuffix, 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	reopen()
+
+	// a crash before it keeps the old ones
+	if err := os.Mkdir(path+newTableDirSuffix, 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	reopen()
+}
+
+func TestDurableFragment(t *testing.T) {
+	dir := t.TempDir()
+	network := labrpc.MakeNetwork()
+	c, err := NewClusterWithDataDir(2, network, "DurableCluster", dir)
+	if err != nil {
+		t.Fatalf("cannot create cluster: %v", err)
+	}
+	cli := connectEnd(network, "DurableClient", c.Name)
+
+	schema := &TableSchema{TableName: "durable", ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}}}
+	m := map[string]interface{}{
+		"1": map[string]interface{}{
+			"predicate": map[string]interface{}{"sid": [...]map[string]interface{}{{"op": ">=", "val": 0}}},
+			"column":    [...]string{"sid"},
+			"storage":   "durable",
+		},
+	}
+	rules, _ := json.Marshal(m)
+	reply := ""
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	cli.Call("Cluster.FragmentWrite", []interface{}{"durable", Row{1}}, &reply)
+
+	// the fragment is recovered by a node created with the same directory
+	n, err := NewNodeWithDataDir("Node1", filepath.Join(dir, "Node1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer n.Close()
+	if count, err := n.count("durable|0"); err != nil || count != 1 {
+		t.Errorf("expected the fragment to hold 1 row after recovery, actual %d (%v)", count, err)
+	}
+
+	// a cluster whose nodes cannot open their directories is not created
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := NewClusterWithDataDir(2, labrpc.MakeNetwork(), "FileCluster", file); err == nil {
+		t.Errorf("a cluster should not be created under a file")
+	}
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// HashPartitioning partitions the rows of a table by a stable hash of their partition key into buckets, as an
+// alternative to partition rules. It is given to BuildTable as a third parameter, with empty rules, and each bucket
+// becomes a fragment holding all the columns: the b-th fragment of the table holds the bucket b, on the nodes b, b+1,
+// ..., b+Replicas-1 modulo the number of nodes, in the order of the nodes of the cluster.
+type HashPartitioning struct {
+	// the columns whose values decide the bucket of a row
+	Key []string
+	// the number of buckets, i.e., of fragments
+	Buckets int
+	// the number of nodes holding each bucket, 1 if it is 0
+	Replicas int
+	// the kind of RowStore holding the buckets, see Rule.Storage
+	Storage string
+}
+
+// fragmentRule is the definition of one fragment of a table, either given by a partition rule or generated from a
+// hash partitioning.
+type fragmentRule struct {
+	// the nodes holding the fragment, e.g., "0|1"
+	nodes string
+	rule  Rule
+	// the bucket of a hash partitioning, or -1
+	bucket int
+}
+
+// fragmentRules decodes and validates the partition rules of a table and returns them in the order of their keys, or
+// generates them from its hash partitioning if it is not nil, in which case the rules must be empty.
+func (c *Cluster) fragmentRules(schema *TableSchema, data []byte, hashing *HashPartitioning) ([]fragmentRule, error) {
+	if hashing == nil {
+		rules, err := DecodeRules(data)
+		if err != nil {
+			return nil, err
+		}
+		if report := validatePartitionRules(schema, rules, c.hasNode, c.RejectGaps); !report.OK() {
+			return nil, errors.New("invalid partition rules: " + report.String())
+		}
+		// the fragments are numbered in the order of the keys, so that the same rules always give the same fragments
+		keys := make([]string, 0, len(rules))
+		for key := range rules {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fragmentRules := make([]fragmentRule, len(keys))
+		for i, key := range keys {
+			fragmentRules[i] = fragmentRule{nodes: key, rule: rules[key], bucket: -1}
+		}
+		return fragmentRules, nil
+	}
+
+	if trimmed := strings.TrimSpace(string(data)); trimmed != "" && trimmed != "{}" {
+		return nil, errors.New("a table partitioned by hash takes no partition rules")
+	}
+	nodeIds := c.nodeList()
+	if err := hashing.validate(schema, len(nodeIds)); err != nil {
+		return nil, err
+	}
+	replicas := hashing.Replicas
+	if replicas == 0 {
+		replicas = 1
+	}
+	columns := make([]string, len(schema.ColumnSchemas))
+	for i, cs := range schema.ColumnSchemas {
+		columns[i] = cs.Name
+	}
+	fragmentRules := make([]fragmentRule, hashing.Buckets)
+	for b := range fragmentRules {
+		nodes := make([]string, replicas)
+		for r := range nodes {
+			nodes[r] = strings.TrimPrefix(nodeIds[(b+r)%len(nodeIds)], nodeNamePrefix)
+		}
+		fragmentRules[b] = fragmentRule{nodes: strings.Join(nodes, "|"),
+			rule: Rule{Column: columns, Storage: hashing.Storage}, bucket: b}
+	}
+	return fragmentRules, nil
+}
+
+func (h *HashPartitioning) validate(schema *TableSchema, nodeNum int) error {
+	if len(h.Key) == 0 {
+		return errors.New("the partition key is empty")
+	}
+	for i, column := range h.Key {
+		if schema.ColumnIndex(column) < 0 {
+			return fmt.Errorf("unknown column %s in the partition key", column)
+		}
+		for _, another := range h.Key[:i] {
+			if another == column {
+				return fmt.Errorf("column %s appears twice in the partition key", column)
+			}
+		}
+	}
+	if h.Buckets < 1 {
+		return fmt.Errorf("invalid number of buckets %d", h.Buckets)
+	}
+	if h.Replicas < 0 || h.Replicas > nodeNum {
+		return fmt.Errorf("invalid number of replicas %d for %d nodes", h.Replicas, nodeNum)
+	}
+	_, err := ParseStorage(h.Storage)
+	return err
+}
+
+// keyValues returns the values of the partition key in a normalized row of the table.
+func (h *HashPartitioning) keyValues(schema *TableSchema, row Row) []interface{} {
+	values := make([]interface{}, len(h.Key))
+	for i, column := range h.Key {
+		values[i] = row[schema.ColumnIndex(column)]
+	}
+	return values
+}
+
+// bucketOf returns the bucket of the normalized values of a partition key. The hash only depends on the values, so
+// that the bucket of a row is the same across restarts and coordinators.
+func (h *HashPartitioning) bucketOf(values []interface{}) int {
+	hash := fnv.New64a()
+	hash.Write([]byte(encodeKey(values)))
+	return int(hash.Sum64() % uint64(h.Buckets))
+}
+
+// bucketsOf returns the buckets that may hold the rows satisfying a predicate, if the predicate restricts each column
+// of the partition key to a few values with "=" or IN. Otherwise it returns false, and any bucket may hold them.
+func (h *HashPartitioning) bucketsOf(schema *TableSchema, predicate Predicate) ([]int, bool) {
+	// the combinations of the values of the key columns seen so far
+	combinations := [][]interface{}{{}}
+	for _, column := range h.Key {
+		dataType := schema.ColumnSchemas[schema.ColumnIndex(column)].DataType
+		var candidates []interface{}
+		found := false
+		for _, atom := range predicate[column] {
+			switch normalizeOp(atom.Op) {
+			case "=", "==":
+				if atom.Val != nil {
+					candidates, found = []interface{}{atom.Val}, true
+				}
+			case OpIn:
+				if list, ok := atom.Val.([]interface{}); ok {
+					candidates, found = list, true
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found || len(combinations)*len(candidates) > h.Buckets {
+			return nil, false
+		}
+		next := make([][]interface{}, 0, len(combinations)*len(candidates))
+		for _, candidate := range candidates {
+			// a value that does not fit the column, e.g., 3.5 for an integer, is in no row
+			value, err := NormalizeValue(candidate, dataType)
+			if err != nil || value == nil {
+				continue
+			}
+			for _, combination := range combinations {
+				next = append(next, append(append([]interface{}(nil), combination...), value))
+			}
+		}
+		combinations = next
+	}
+
+	seen := make(map[int]bool)
+	buckets := make([]int, 0, len(combinations))
+	for _, values := range combinations {
+		if b := h.bucketOf(values); !seen[b] {
+			seen[b] = true
+			buckets = append(buckets, b)
+		}
+	}
+	return buckets, true
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"../labrpc"
+)
+
+func buildHashedTable(t *testing.T, cli *labrpc.ClientEnd, schema TableSchema, hashing HashPartitioning) {
+	if err := buildTestTable(cli, schema, []byte("{}"), hashing); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashPartitioning(t *testing.T) {
+	c, network, cli := newTestCluster(3, "Hash")
+
+	student := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+	}}
+	course := TableSchema{TableName: "course", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "course", DataType: TypeString},
+	}}
+	hashing := HashPartitioning{Key: []string{"sid"}, Buckets: 4, Replicas: 2}
+	buildHashedTable(t, cli, student, hashing)
+	buildHashedTable(t, cli, course, hashing)
+
+	for _, invalid := range []struct {
+		rules   string
+		hashing HashPartitioning
+	}{
+		{"{}", HashPartitioning{Key: []string{"age"}, Buckets: 2}},
+		{"{}", HashPartitioning{Key: []string{"sid", "sid"}, Buckets: 2}},
+		{"{}", HashPartitioning{Key: []string{"sid"}}},
+		{"{}", HashPartitioning{Key: []string{"sid"}, Buckets: 2, Replicas: 4}},
+		{`{"0": {"predicate": {}, "column": ["sid", "name"]}}`, HashPartitioning{Key: []string{"sid"}, Buckets: 2}},
+	} {
+		reply := ""
+		schema := student
+		schema.TableName = "invalid"
+		cli.Call("Cluster.BuildTable", []interface{}{schema, []byte(invalid.rules), invalid.hashing}, &reply)
+		if reply == "0 OK" {
+			t.Errorf("%v with rules %s should be rejected", invalid.hashing, invalid.rules)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := c.Insert("student", Row{i, string(rune('A' + i))}); err != nil {
+			t.Fatalf("cannot insert student %d: %v", i, err)
+		}
+		if err := c.Insert("course", Row{int32(i % 5), "course" + string(rune('a'+i))}); err != nil {
+			t.Fatalf("cannot insert course %d: %v", i, err)
+		}
+	}
+
+	// each row is in the bucket given by the hash of its key, on the replicas of the bucket
+	for b, f := range c.tableFragments["student"] {
+		if f.bucket != b || len(f.nodes) != 2 || f.nodes[0] != c.nodeIds[b%3] || f.nodes[1] != c.nodeIds[(b+1)%3] {
+			t.Errorf("unexpected placement of bucket %d: %v", b, f.nodes)
+		}
+		for _, nodeId := range f.nodes {
+			dataset := Dataset{}
+			c.nodes[nodeId].ScanTable(f.name, &dataset)
+			for _, row := range dataset.Rows {
+				if bucket := hashing.bucketOf([]interface{}{row[1]}); bucket != b {
+					t.Errorf("row %v should be in bucket %d, not %d", row, bucket, b)
+				}
+			}
+		}
+	}
+
+	// a select on the key reads a single bucket with one RPC per replica, a replica missing the row being made up for
+	// by the other
+	f := c.tableFragments["student"][hashing.bucketOf([]interface{}{int32(7)})]
+	stored := Dataset{}
+	c.nodes[f.nodes[0]].RPCSelect([]interface{}{f.name, Predicate{"sid": {{Op: "=", Val: 7}}}}, &stored)
+	reply := ""
+	c.nodes[f.nodes[0]].RPCDeleteRows([]interface{}{f.name, []int64{rowId(stored.Rows[0][0])}}, &reply)
+	before := network.GetTotalCount()
+	dataset := Dataset{}
+	cli.Call("Cluster.FragmentRead", []interface{}{"student", Predicate{"sid": {{Op: "=", Val: 7}}}}, &dataset)
+	if count := network.GetTotalCount() - before; count != 1+hashing.Replicas {
+		t.Errorf("expected one RPC to the cluster and one to each replica, actual %d", count)
+	}
+	if len(dataset.Rows) != 1 || dataset.Rows[0][0] != int32(7) || dataset.Rows[0][1] != "H" {
+		t.Errorf("expected student 7, actual %v", dataset.Rows)
+	}
+	selected, err := c.Select("student", Predicate{"sid": {{Op: "IN", Val: []interface{}{3, 12, 3.5, 40}}}})
+	if err != nil || len(selected.Rows) != 2 || selected.Rows[0][0] != int32(3) || selected.Rows[1][0] != int32(12) {
+		t.Errorf("expected students 3 and 12, actual %v (%v)", selected.Rows, err)
+	}
+	selected, err = c.Select("student", Predicate{"name": {{Op: "LIKE", Val: "%"}}})
+	if err != nil || len(selected.Rows) != 20 || selected.Rows[19][0] != int32(19) {
+		t.Errorf("expected all students in order, actual %v (%v)", selected.Rows, err)
+	}
+
+	// the tables are joined bucket by bucket, with each bucket read once
+	before = network.GetTotalCount()
+	joined := Dataset{}
+	cli.Call("Cluster.Join", []string{"student", "course"}, &joined)
+	if count := network.GetTotalCount() - before; count != 1+2*hashing.Buckets*hashing.Replicas {
+		t.Errorf("expected one RPC per replica of the buckets of each table, actual %d", count)
+	}
+	if len(joined.Rows) != 20 || len(joined.Schema.ColumnSchemas) != 3 {
+		t.Fatalf("expected 20 rows of 3 columns, actual %v", joined)
+	}
+	for _, row := range joined.Rows {
+		sid := row[0].(int32)
+		// the i-th course is taken by the student i % 5
+		if row[1] != string(rune('A'+sid)) || int32(row[2].(string)[6]-'a')%5 != sid {
+			t.Errorf("unexpected joined row %v", row)
+		}
+	}
+
+	// a bucket that cannot be read does not empty the join, the rows of the reachable replicas are joined
+	network.DeleteServer("Node0")
+	network.DeleteServer("Node1")
+	joined = Dataset{}
+	cli.Call("Cluster.Join", []string{"student", "course"}, &joined)
+	if len(joined.Rows) == 0 || len(joined.Rows) >= 20 || len(joined.Schema.ColumnSchemas) != 3 {
+		t.Errorf("expected the rows of the reachable buckets to be joined, actual %v", joined)
+	}
+
+	// a table whose fragments cannot all be created is not built, nor left half built on the reachable nodes
+	enrolment := TableSchema{TableName: "enrolment", ColumnSchemas: student.ColumnSchemas}
+	if err := buildTestTable(cli, enrolment, []byte("{}"), hashing); err == nil {
+		t.Errorf("the table should not be built while Node0 and Node1 are down")
+	}
+	if err := c.Insert("enrolment", Row{1, "A"}); err == nil {
+		t.Errorf("a table that is not built should not take rows")
+	}
+	tables := make([]string, 0)
+	c.nodes["Node2"].ListTables("", &tables)
+	for _, table := range tables {
+		if strings.HasPrefix(table, "enrolment|") {
+			t.Errorf("%s should be dropped from Node2", table)
+		}
+	}
+	for _, nodeId := range []string{"Node0", "Node1"} {
+		server := labrpc.MakeServer()
+		server.AddService(labrpc.MakeService(c.nodes[nodeId]))
+		network.AddServer(nodeId, server)
+	}
+	buildHashedTable(t, cli, enrolment, hashing)
+	if err := c.Insert("enrolment", Row{1, "A"}); err != nil {
+		t.Errorf("cannot insert once the table is built: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// enumeration of the kinds of index a table can have on a column
+const (
+	// IndexHash answers equality atoms
+	IndexHash = iota
+	// IndexBTree answers equality atoms and the range atoms "<", "<=", ">" and ">="
+	IndexBTree
+)
+
+// ParseIndexKind converts the name of an index kind, "hash" or "btree", into one of the constants above.
+func ParseIndexKind(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "hash":
+		return IndexHash, nil
+	case "btree", "b-tree":
+		return IndexBTree, nil
+	}
+	return -1, fmt.Errorf("unknown index kind %s", name)
+}
+
+// index maps the values of a column to the rows holding them. The keys are values normalized into the type of the
+// column (see NormalizeValue), and null values are never indexed.
+type index interface {
+	insert(key interface{}, row Row)
+	remove(key interface{}, row Row)
+	// returns the rows whose key equals the given one
+	get(key interface{}) []Row
+}
+
+// rangeIndex is an index that can also return the rows whose keys are within a range.
+type rangeIndex interface {
+	index
+	// returns the rows whose keys are within [lo, hi], a nil bound means unbounded and an exclusive bound is
+	// represented by the flags
+	scan(lo interface{}, loInclusive bool, hi interface{}, hiInclusive bool) []Row
+}
+
+// columnIndex is an index on a column of a Table. Rows whose value cannot be normalized into the type of the column
+// are kept aside in unindexed and returned by every lookup, so that an index never hides a row from a scan.
+type columnIndex struct {
+	column    int
+	dataType  int
+	kind      int
+	index     index
+	unindexed []Row
+}
+
+func newColumnIndex(column int, dataType int, kind int) *columnIndex {
+	ci := &columnIndex{column: column, dataType: dataType, kind: kind}
+	if kind == IndexBTree {
+		ci.index = newBTreeIndex()
+	} else {
+		ci.index = hashIndex{}
+	}
+	return ci
+}
+
+func (ci *columnIndex) insert(row Row) {
+	if row[ci.column] == nil {
+		return
+	}
+	if key, err := NormalizeValue(row[ci.column], ci.dataType); err == nil {
+		ci.index.insert(key, row)
+	} else {
+		ci.unindexed = append(ci.unindexed, row)
+	}
+}
+
+func (ci *columnIndex) remove(row Row) {
+	if row[ci.column] == nil {
+		return
+	}
+	if key, err := NormalizeValue(row[ci.column], ci.dataType); err == nil {
+		ci.index.remove(key, row)
+	} else {
+		ci.unindexed = removeRow(ci.unindexed, row)
+	}
+}
+
+// lookup returns the candidate rows for an atom on the indexed column, and false if the index cannot answer the atom,
+// in which case the caller has to scan the whole table.
+func (ci *columnIndex) lookup(atom *Atom) ([]Row, bool) {
+	switch atom.Op {
+	case OpIn:
+		rows := make([]Row, 0)
+		seen := make(map[interface{}]bool)
+		for _, value := range atom.Val.([]interface{}) {
+			key, err := NormalizeValue(value, ci.dataType)
+			if err != nil {
+				return nil, false
+			}
+			if !seen[key] {
+				seen[key] = true
+				rows = append(rows, ci.index.get(key)...)
+			}
+		}
+		return append(rows, ci.unindexed...), true
+	case OpBetween:
+		ranged, ok := ci.index.(rangeIndex)
+		bounds := atom.Val.([]interface{})
+		lower, err1 := NormalizeValue(bounds[0], ci.dataType)
+		upper, err2 := NormalizeValue(bounds[1], ci.dataType)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, false
+		}
+		rows := ranged.scan(lower, true, upper, true)
+		return append(append(make([]Row, 0, len(rows)+len(ci.unindexed)), rows...), ci.unindexed...), true
+	}
+	if atom.Val == nil {
+		return nil, false
+	}
+	key, err := NormalizeValue(atom.Val, ci.dataType)
+	if err != nil {
+		return nil, false
+	}
+	var rows []Row
+	switch atom.Op {
+	case "==", "=":
+		rows = ci.index.get(key)
+	case "<", "<=", ">", ">=":
+		ranged, ok := ci.index.(rangeIndex)
+		if !ok {
+			return nil, false
+		}
+		switch atom.Op {
+		case "<":
+			rows = ranged.scan(nil, false, key, false)
+		case "<=":
+			rows = ranged.scan(nil, false, key, true)
+		case ">":
+			rows = ranged.scan(key, false, nil, false)
+		case ">=":
+			rows = ranged.scan(key, true, nil, false)
+		}
+	default:
+		return nil, false
+	}
+	return append(append(make([]Row, 0, len(rows)+len(ci.unindexed)), rows...), ci.unindexed...), true
+}
+
+// removeRow removes the first row that equals the given one.
+func removeRow(rows []Row, row Row) []Row {
+	for i := range rows {
+		if rows[i].Equals(&row) {
+			return append(rows[:i], rows[i+1:]...)
+		}
+	}
+	return rows
+}
+
+// compareKeys compares two normalized values of the same datatype, false is less than true.
+func compareKeys(a, b interface{}) int {
+	switch x := a.(type) {
+	case int32:
+		return compareInt64(int64(x), int64(b.(int32)))
+	case int64:
+		return compareInt64(x, b.(int64))
+	case float32:
+		return compareFloat64(float64(x), float64(b.(float32)))
+	case float64:
+		return compareFloat64(x, b.(float64))
+	case string:
+		return strings.Compare(x, b.(string))
+	case bool:
+		return compareBool(x, b.(bool))
+	}
+	return 0
+}
+
+// hashIndex answers equality lookups with a Go map.
+type hashIndex map[interface{}][]Row
+
+func (h hashIndex) insert(key interface{}, row Row) {
+	h[key] = append(h[key], row)
+}
+
+func (h hashIndex) remove(key interface{}, row Row) {
+	if rows := removeRow(h[key], row); len(rows) > 0 {
+		h[key] = rows
+	} else {
+		delete(h, key)
+	}
+}
+
+func (h hashIndex) get(key interface{}) []Row {
+	return h[key]
+}
+
+// the maximum number of keys in a node of a bTreeIndex
+const bTreeMaxKeys = 32
+
+// bTreeIndex is a B+ tree: the keys and their rows are in the leaves, which are linked for range scans, and the inner
+// nodes only hold separators. A key whose last row is removed is deleted from its leaf, but the nodes are never merged,
+// which keeps deletion simple at the cost of possibly underfull nodes.
+type bTreeIndex struct {
+	root *bTreeNode
+}
+
+type bTreeNode struct {
+	leaf bool
+	keys []interface{}
+	// inner nodes: children[i] holds the keys in [keys[i-1], keys[i])
+	children []*bTreeNode
+	// leaves: rows[i] holds the rows of keys[i]
+	rows [][]Row
+	next *bTreeNode
+}
+
+func newBTreeIndex() *bTreeIndex {
+	return &bTreeIndex{root: &bTreeNode{leaf: true}}
+}
+
+// search returns the position of the first key in the node that is not less than the given one.
+func (node *bTreeNode) search(key interface{}) int {
+	return sort.Search(len(node.keys), func(i int) bool { return compareKeys(node.keys[i], key) >= 0 })
+}
+
+// child returns the position of the child that may contain the given key.
+func (node *bTreeNode) child(key interface{}) int {
+	return sort.Search(len(node.keys), func(i int) bool { return compareKeys(node.keys[i], key) > 0 })
+}
+
+func (b *bTreeIndex) insert(key interface{}, row Row) {
+	separator, right := b.root.insert(key, row)
+	if right != nil {
+		b.root = &bTreeNode{keys: []interface{}{separator}, children: []*bTreeNode{b.root, right}}
+	}
+}
+
+// insert adds a row to the subtree, and returns the separator and the new right sibling if the node is split.
+func (node *bTreeNode) insert(key interface{}, row Row) (interface{}, *bTreeNode) {
+	if node.leaf {
+		i := node.search(key)
+		if i < len(node.keys) && compareKeys(node.keys[i], key) == 0 {
+			node.rows[i] = append(node.rows[i], row)
+			return nil, nil
+		}
+		node.keys = append(node.keys, nil)
+		copy(node.keys[i+1:], node.keys[i:])
+		node.keys[i] = key
+		node.rows = append(node.rows, nil)
+		copy(node.rows[i+1:], node.rows[i:])
+		node.rows[i] = []Row{row}
+		if len(node.keys) <= bTreeMaxKeys {
+			return nil, nil
+		}
+		half := len(node.keys) / 2
+		right := &bTreeNode{leaf: true, next: node.next}
+		right.keys = append([]interface{}(nil), node.keys[half:]...)
+		right.rows = append([][]Row(nil), node.rows[half:]...)
+		node.keys = node.keys[:half:half]
+		node.rows = node.rows[:half:half]
+		node.next = right
+		return right.keys[0], right
+	}
+
+	i := node.child(key)
+	separator, child := node.children[i].insert(key, row)
+	if child == nil {
+		return nil, nil
+	}
+	node.keys = append(node.keys, nil)
+	copy(node.keys[i+1:], node.keys[i:])
+	node.keys[i] = separator
+	node.children = append(node.children, nil)
+	copy(node.children[i+2:], node.children[i+1:])
+	node.children[i+1] = child
+	if len(node.keys) <= bTreeMaxKeys {
+		return nil, nil
+	}
+	// the middle key moves up, the keys on its right go to the new sibling
+	half := len(node.keys) / 2
+	separator = node.keys[half]
+	right := &bTreeNode{}
+	right.keys = append([]interface{}(nil), node.keys[half+1:]...)
+	right.children = append([]*bTreeNode(nil), node.children[half+1:]...)
+	node.keys = node.keys[:half:half]
+	node.children = node.children[: half+1 : half+1]
+	return separator, right
+}
+
+// leaf returns the leaf that may contain the given key, or the leftmost leaf if the key is nil.
+func (b *bTreeIndex) leaf(key interface{}) *bTreeNode {
+	node := b.root
+	for !node.leaf {
+		if key == nil {
+			node = node.children[0]
+		} else {
+			node = node.children[node.child(key)]
+		}
+	}
+	return node
+}
+
+func (b *bTreeIndex) remove(key interface{}, row Row) {
+	node := b.leaf(key)
+	i := node.search(key)
+	if i == len(node.keys) || compareKeys(node.keys[i], key) != 0 {
+		return
+	}
+	if node.rows[i] = removeRow(node.rows[i], row); len(node.rows[i]) == 0 {
+		node.keys = append(node.keys[:i], node.keys[i+1:]...)
+		node.rows = append(node.rows[:i], node.rows[i+1:]...)
+	}
+}
+
+func (b *bTreeIndex) get(key interface{}) []Row {
+	node := b.leaf(key)
+	if i := node.search(key); i < len(node.keys) && compareKeys(node.keys[i], key) == 0 {
+		return node.rows[i]
+	}
+	return nil
+}
+
+// scanAfter returns the rows of at most limit keys greater than the given one, or of the first keys if it is nil, in
+// the order of the keys.
+func (b *bTreeIndex) scanAfter(lo interface{}, limit int) []Row {
+	rows := make([]Row, 0, limit)
+	node := b.leaf(lo)
+	i := 0
+	if lo != nil {
+		i = node.search(lo)
+	}
+	for keys := 0; node != nil; node, i = node.next, 0 {
+		for ; i < len(node.keys); i++ {
+			if lo != nil && compareKeys(node.keys[i], lo) == 0 {
+				continue
+			}
+			if keys == limit {
+				return rows
+			}
+			rows = append(rows, node.rows[i]...)
+			keys++
+		}
+	}
+	return rows
+}
+
+func (b *bTreeIndex) scan(lo interface{}, loInclusive bool, hi interface{}, hiInclusive bool) []Row {
+	rows := make([]Row, 0)
+	node := b.leaf(lo)
+	i := 0
+	if lo != nil {
+		i = node.search(lo)
+	}
+	for ; node != nil; node, i = node.next, 0 {
+		for ; i < len(node.keys); i++ {
+			if lo != nil && !loInclusive && compareKeys(node.keys[i], lo) == 0 {
+				continue
+			}
+			if hi != nil {
+				if c := compareKeys(node.keys[i], hi); c > 0 || (c == 0 && !hiInclusive) {
+					return rows
+				}
+			}
+			rows = append(rows, node.rows[i]...)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBTreeIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	b := newBTreeIndex()
+	// key -> number of rows with the key
+	expected := make(map[int64]int)
+	for i := 0; i < 5000; i++ {
+		key := int64(r.Intn(1000))
+		row := Row{key, i % 3}
+		if r.Intn(4) == 0 && expected[key] > 0 {
+			// remove one of the rows with the key, they are all equal to one of three rows
+			for j := 0; j < 3; j++ {
+				before := len(b.get(key))
+				b.remove(key, Row{key, j})
+				if len(b.get(key)) < before {
+					expected[key]--
+					break
+				}
+			}
+			continue
+		}
+		b.insert(key, row)
+		expected[key]++
+	}
+
+	keys := make([]int64, 0)
+	for key, count := range expected {
+		if len(b.get(key)) != count {
+			t.Fatalf("expected %d rows of key %d, actual %d", count, key, len(b.get(key)))
+		}
+		for i := 0; i < count; i++ {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for i := 0; i < 100; i++ {
+		lo, hi := int64(r.Intn(1000)), int64(r.Intn(1000))
+		loInclusive, hiInclusive := r.Intn(2) == 0, r.Intn(2) == 0
+		count := 0
+		for _, key := range keys {
+			if (key > lo || (loInclusive && key == lo)) && (key < hi || (hiInclusive && key == hi)) {
+				count++
+			}
+		}
+		rows := b.scan(lo, loInclusive, hi, hiInclusive)
+		if len(rows) != count {
+			t.Errorf("expected %d rows in range %d-%d, actual %d", count, lo, hi, len(rows))
+		}
+		for j := 1; j < len(rows); j++ {
+			if rows[j-1][0].(int64) > rows[j][0].(int64) {
+				t.Errorf("the range scan is not ordered")
+				break
+			}
+		}
+	}
+	if rows := b.scan(nil, false, nil, false); len(rows) != len(keys) {
+		t.Errorf("expected %d rows in the full scan, actual %d", len(keys), len(rows))
+	}
+}
+
+// an indexed table must return the same rows as a table without indexes
+func TestIndexedSelect(t *testing.T) {
+	schema := &TableSchema{TableName: "indexed", ColumnSchemas: []ColumnSchema{
+		{Name: "id", DataType: TypeString},
+		{Name: "age", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+	}}
+	indexed := NewTable(schema, NewMemoryListRowStore())
+	if err := indexed.CreateIndex("age", IndexBTree); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := indexed.CreateIndex("name", IndexHash); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := indexed.CreateIndex("age", IndexHash); err == nil {
+		t.Errorf("a column should not have two kinds of index")
+	}
+	plain := NewTable(&TableSchema{TableName: "plain", ColumnSchemas: schema.ColumnSchemas}, NewMemoryListRowStore())
+
+	rows := make([]Row, 0)
+	for i := 0; i < 200; i++ {
+		row := Row{string(rune('A'+i%26)) + string(rune('a'+i/26)), int32(i % 50), string(rune('a' + i%7))}
+		if i%31 == 0 {
+			row[1] = nil
+		}
+		rows = append(rows, row)
+		indexed.Insert(&row)
+		plain.Insert(&row)
+	}
+	for i := 0; i < len(rows); i += 3 {
+		indexed.Remove(&rows[i])
+		plain.Remove(&rows[i])
+	}
+
+	predicates := []Predicate{
+		{"age": {{Op: ">=", Val: json.Number("10")}, {Op: "<", Val: json.Number("20")}}},
+		{"age": {{Op: ">", Val: json.Number("45")}}, "name": {{Op: "=", Val: "c"}}},
+		{"name": {{Op: "=", Val: "d"}}},
+		{"age": {{Op: "<=", Val: json.Number("3")}}},
+		{"age": {{Op: "!=", Val: nil}}},
+	}
+	for i, predicate := range predicates {
+		expected, _ := plain.Select(predicate)
+		actual, err := indexed.Select(predicate)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !compareRows(actual, expected, []int{0, 1, 2}) {
+			t.Errorf("predicate %d: expected %v, actual %v", i, expected, actual)
+		}
+	}
+
+	// the hidden id column has a primary index
+	if _, ok := indexed.indexes[idColumnName]; !ok {
+		t.Errorf("the id column should be indexed")
+	}
+	found, _ := indexed.Lookup(idColumnName, rows[1][0])
+	if len(found) != 1 || !found[0].Equals(&rows[1]) {
+		t.Errorf("expected %v, actual %v", rows[1], found)
+	}
+	if found, _ := indexed.Lookup(idColumnName, rows[0][0]); len(found) != 0 {
+		t.Errorf("a removed row should not be found, actual %v", found)
+	}
+}
+
+func TestClusterCreateIndex(t *testing.T) {
+	c, _, cli := newTestCluster(3, "Index")
+
+	schema := &TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	m := map[string]interface{}{
+		"0|1": map[string]interface{}{
+			"predicate": map[string]interface{}{"sid": [...]map[string]interface{}{{"op": ">=", "val": 0}}},
+			"column":    [...]string{"sid"},
+		},
+		"2": map[string]interface{}{
+			"predicate": map[string]interface{}{"sid": [...]map[string]interface{}{{"op": ">=", "val": 0}}},
+			"column":    [...]string{"grade"},
+		},
+	}
+	rules, _ := json.Marshal(m)
+	reply := ""
+	cli.Call("Cluster.BuildTable", []interface{}{schema, rules}, &reply)
+	for i := 0; i < 10; i++ {
+		cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{i, float64(i) / 2}}, &reply)
+	}
+
+	cli.Call("Cluster.CreateIndex", []interface{}{"student", "grade", "btree"}, &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot create the index: %v", reply)
+	}
+	cli.Call("Cluster.CreateIndex", []interface{}{"student", "unknown", "hash"}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("an index should not be created on an unknown column")
+	}
+
+	c.mu.RLock()
+	node := c.nodes["Node2"]
+	c.mu.RUnlock()
+	dataset := Dataset{}
+	for name := range node.TableMap {
+		node.RPCSelect([]interface{}{name, Predicate{"grade": {{Op: ">", Val: json.Number("3")}}}}, &dataset)
+	}
+	if len(dataset.Rows) != 3 {
+		t.Errorf("expected 3 rows with grade > 3, actual %v", dataset.Rows)
+	}
+
+	// the index survives a restart of the node, although the rows of an in-memory fragment without other replicas
+	// do not
+	cli.Call("Cluster.RestartNode", "Node2", &reply)
+	c.mu.RLock()
+	node = c.nodes["Node2"]
+	c.mu.RUnlock()
+	for name, table := range node.TableMap {
+		if _, ok := table.indexes["grade"]; !ok {
+			t.Errorf("the index on %s is lost after the restart", name)
+		}
+	}
+}
@@ -52,21 +52,22 @@ func defineTablesLab3() {
 	}
 
 	joinedTableSchema = TableSchema{
-		"",
-		[]ColumnSchema{
-			{"sid", TypeInt32},
-			{"name", TypeString},
-			{"age", TypeInt32},
-			{"grade", TypeFloat},
-			{"courseId", TypeInt32},
+		TableName: "",
+		ColumnSchemas: []ColumnSchema{
+			{Name: "sid", DataType: TypeInt32},
+			{Name: "name", DataType: TypeString},
+			{Name: "age", DataType: TypeInt32},
+			{Name: "grade", DataType: TypeFloat},
+			{Name: "courseId", DataType: TypeInt32},
 		},
 	}
 
+	// the values are converted into the types of their columns when they are inserted
 	joinedTableContent = []Row{
-		{0, "John", 22, 4.0, 0},
-		{0, "John", 22, 4.0, 1},
-		{1, "Smith", 23, 3.6, 0},
-		{2, "Hana", 21, 4.0, 2},
+		{int32(0), "John", int32(22), float32(4.0), int32(0)},
+		{int32(0), "John", int32(22), float32(4.0), int32(1)},
+		{int32(1), "Smith", int32(23), float32(3.6), int32(0)},
+		{int32(2), "Hana", int32(21), float32(4.0), int32(2)},
 	}
 }
 
@@ -1,272 +1,798 @@
-package models
-
-import (
-	"encoding/json"
-	"errors"
-	"fmt"
-)
-
-// Node manages some tables defined in models/table.go
-type Node struct {
-	// the name of the Node, and it should be unique across the cluster
-	Identifier string
-	// tableName -> table
-	TableMap map[string]*Table
-}
-
-// NewNode creates a new node with the given name and an empty set of tables
-func NewNode(id string) *Node {
-	return &Node{TableMap: make(map[string]*Table), Identifier: id}
-}
-
-// SayHello is an example about how to create a method that can be accessed by RPC (remote procedure call, methods that
-// can be called through network from another node). RPC methods should have exactly two arguments, the first one is the
-// actual argument (or an argument list), while the second one is a reference to the result.
-func (n *Node) SayHello(args interface{}, reply *string) {
-	// NOTICE: use reply (the second parameter) to pass the return value instead of "return" statements.
-	*reply = fmt.Sprintf("Hello %s, I am Node %s", args, n.Identifier)
-}
-
-// CreateTable creates a Table on this node with the provided schema. It returns nil if the table is created
-// successfully, or an error if another table with the same name already exists.
-func (n *Node) CreateTable(schema *TableSchema) error {
-	// check if the table already exists
-	if _, ok := n.TableMap[schema.TableName]; ok {
-		return errors.New("table already exists")
-	}
-	// create a table and store it in the map
-	t := NewTable(
-		schema,
-		NewMemoryListRowStore(),
-	)
-	n.TableMap[schema.TableName] = t
-	return nil
-}
-
-// Insert inserts a row into the specified table, and returns nil if succeeds or an error if the table does not exist.
-func (n *Node) Insert(tableName string, row *Row) error {
-	if t, ok := n.TableMap[tableName]; ok {
-		t.Insert(row)
-		return nil
-	} else {
-		return errors.New("no such table")
-	}
-}
-
-// Remove removes a row from the specified table, and returns nil if succeeds or an error if the table does not exist.
-// It does not concern whether the provided row exists in the table.
-func (n *Node) Remove(tableName string, row *Row) error {
-	if t, ok := n.TableMap[tableName]; ok {
-		t.Remove(row)
-		return nil
-	} else {
-		return errors.New("no such table")
-	}
-}
-
-// IterateTable returns an iterator of the table through which the caller can retrieve all rows in the table in the
-// order they are inserted. It returns (iterator, nil) if the Table can be found, or (nil, err) if the Table does not
-// exist.
-func (n *Node) IterateTable(tableName string) (RowIterator, error) {
-	if t, ok := n.TableMap[tableName]; ok {
-		return t.RowIterator(), nil
-	} else {
-		return nil, errors.New("no such table")
-	}
-}
-
-// IterateTable returns the count of rows in a table. It returns (cnt, nil) if the Table can be found, or (-1, err)
-// if the Table does not exist.
-func (n *Node) count(tableName string) (int, error) {
-	if t, ok := n.TableMap[tableName]; ok {
-		return t.Count(), nil
-	} else {
-		return -1, errors.New("no such table")
-	}
-}
-
-// ScanTable returns all rows in a table by the specified name or nothing if it does not exist.
-// This method is recommended only to be used for TEST PURPOSE, and try not to use this method in your implementation,
-// but you can use it in your own test cases.
-// The reason why we deprecate this method is that in practice, every table is so large that you cannot transfer a whole
-// table through network all at once, so sending a whole table in one RPC is very impractical. One recommended way is to
-// fetch a batch of Rows a time.
-func (n *Node) ScanTable(tableName string, dataset *Dataset) {
-	if t, ok := n.TableMap[tableName]; ok {
-		resultSet := Dataset{}
-
-		tableRows := make([]Row, t.Count())
-		i := 0
-		iterator := t.RowIterator()
-		for iterator.HasNext() {
-			tableRows[i] = *iterator.Next()
-			i = i + 1
-		}
-
-		resultSet.Rows = tableRows
-		resultSet.Schema = *t.schema
-		*dataset = resultSet
-	}
-}
-
-// return a row which has id in tableName
-// args: tableName string, id string
-func (n *Node) ScanLineData(args []interface{}, dataset *Dataset) {
-	tableName := args[0].(string)
-	id := args[1].(string)
-
-	if t, ok := n.TableMap[tableName]; ok {
-		resultSet := Dataset{}
-
-		tableRows := make([]Row, 1)
-
-		iterator := t.RowIterator()
-		for iterator.HasNext() {
-			row := *iterator.Next()
-			if row[0] == id {
-				tableRows[0] = row
-				break
-			}
-		}
-
-		resultSet.Rows = tableRows
-		resultSet.Schema = *t.schema
-		*dataset = resultSet
-
-	}
-}
-
-// return a full schema of TableName
-func (n *Node) GetFullSchema(tableName string, schema *[]ColumnSchema) {
-	res := make([]ColumnSchema, 0)
-	if t, ok := n.TableMap[tableName]; ok {
-		res = t.fullSchema.ColumnSchemas[0 : len(t.fullSchema.ColumnSchemas)-1]
-	}
-	*schema = res
-}
-
-func (n *Node) RPCCreateTable(args []interface{}, reply *string) {
-	schema := args[0].(TableSchema)
-	predicate := args[1].(Predicate)
-	fullSchema := args[2].(TableSchema)
-	for k, v := range predicate {
-		for _, cs := range fullSchema.ColumnSchemas {
-			if cs.Name == k {
-				for i, value := range v {
-					if value.Val == nil {
-						if OpIsEqualOrNotEqual(value.Op) {
-							predicate[k][i].RealType = cs.DataType
-							continue
-						} else {
-							*reply = "1 Operator Not Suitable For null"
-							return
-						}
-					}
-					var ok bool
-					switch cs.DataType {
-					case TypeInt32, TypeInt64, TypeFloat, TypeDouble:
-						predicate[k][i].NumberValue, ok = value.Val.(json.Number)
-						if ok {
-							if _, err1 := predicate[k][i].NumberValue.Float64(); err1 != nil {
-								if _, err2 := predicate[k][i].NumberValue.Int64(); err2 != nil {
-									ok = false
-								}
-							}
-						}
-					case TypeBoolean:
-						predicate[k][i].BoolValue, ok = value.Val.(bool)
-					case TypeString:
-						predicate[k][i].StringValue, ok = value.Val.(string)
-					}
-					if !ok {
-						*reply = "1 TypeError"
-						return
-					}
-					predicate[k][i].RealType = cs.DataType
-				}
-				break
-			}
-		}
-	}
-	if err := n.CreateTable(&schema); err != nil {
-		*reply = fmt.Sprintf("1 %v", err)
-	} else {
-		if t, ok := n.TableMap[schema.TableName]; ok {
-			t.predicate = &predicate
-			t.fullSchema = &fullSchema
-			*reply = "0 OK"
-		} else {
-			*reply = "1 Create Table Fail"
-		}
-	}
-}
-
-func (n *Node) RPCInsert(args []interface{}, reply *string) {
-	tableName := args[0].(string)
-	if t, ok := n.TableMap[tableName]; ok {
-		row := args[1].(Row)
-		var subRow Row
-		for i, v := range row {
-			if atoms, exist := (*t.predicate)[t.fullSchema.ColumnSchemas[i].Name]; exist {
-				for _, atom := range atoms {
-					if !atom.Check(v) {
-						*reply = "1 Predicate Check Fail"
-						return
-					}
-				}
-			}
-		}
-		for _, v := range t.schema.ColumnSchemas {
-			for i, cs := range t.fullSchema.ColumnSchemas {
-				if cs.Name == v.Name {
-					subRow = append(subRow, row[i])
-					break
-				}
-			}
-		}
-		if err := n.Insert(tableName, &subRow); err != nil {
-			*reply = fmt.Sprintf("1 %v", err)
-			return
-		}
-	}
-	*reply = "0 OK"
-}
-
-func OpIsEqualOrNotEqual(op string) bool {
-	return op == "==" || op == "=" || op == "!=" || op == "<>" || op == ">=" || op == "<="
-}
-
-func (n *Node) RPCJoin(args []interface{}, reply *string) {
-	tableName := args[0].(string)
-	if t, ok := n.TableMap[tableName]; ok {
-		row := args[1].(Row)
-		var subRow Row
-		for i, v := range row {
-			if !CheckType(v, t.fullSchema.ColumnSchemas[i].DataType) {
-				*reply = fmt.Sprintf("1 %v's value doesn't conform its type", t.fullSchema.ColumnSchemas[i].Name)
-				return
-			}
-			if atoms, exist := (*t.predicate)[t.fullSchema.ColumnSchemas[i].Name]; exist {
-				for _, atom := range atoms {
-					if !atom.Check(v) {
-						*reply = "1 Predicate Check Fail"
-						return
-					}
-				}
-			}
-		}
-		for _, v := range t.schema.ColumnSchemas {
-			for i, cs := range t.fullSchema.ColumnSchemas {
-				if cs.Name == v.Name {
-					subRow = append(subRow, row[i])
-					break
-				}
-			}
-		}
-		if err := n.Insert(tableName, &subRow); err != nil {
-			*reply = fmt.Sprintf("1 %v", err)
-			return
-		}
-	}
-	*reply = "0 OK"
-}
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"../labgob"
+)
+
+// Node manages some tables defined in models/table.go
+type Node struct {
+	// the name of the Node, and it should be unique across the cluster
+	Identifier string
+	// tableName -> table
+	TableMap map[string]*Table
+	// mu guards TableMap only, RPCs are dispatched concurrently by labrpc so every access to the map must hold it.
+	// The rows of each table are guarded by the lock of that table.
+	mu sync.RWMutex
+	// the directory holding the durable tables of this node, empty if the node can only hold in-memory tables
+	dataDir string
+	// where the definitions of the tables are saved, nil if the node is not meant to be restarted
+	persister *Persister
+}
+
+// NewNode creates a new node with the given name and an empty set of tables
+func NewNode(id string) *Node {
+	return &Node{TableMap: make(map[string]*Table), Identifier: id}
+}
+
+// NewNodeWithDataDir creates a node whose durable tables are kept under the given directory. The durable tables
+// created by a previous node with the same directory are recovered, while in-memory tables are not.
+func NewNodeWithDataDir(id string, dataDir string) (*Node, error) {
+	return MakeNode(id, dataDir, nil)
+}
+
+// MakeNode creates a node which saves the definitions of its tables into the given persister, and restores the tables
+// saved by a previous incarnation of the node. In-memory tables are restored empty and durable tables are restored
+// with their rows. If the persister is nil or empty, the durable tables found in dataDir are restored instead.
+func MakeNode(id string, dataDir string, persister *Persister) (*Node, error) {
+	n := NewNode(id)
+	n.dataDir = dataDir
+	n.persister = persister
+	if dataDir != "" {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if persister != nil && persister.NodeStateSize() > 0 {
+		if err := n.readPersist(persister.ReadNodeState()); err != nil {
+			n.Close()
+			return nil, err
+		}
+		return n, nil
+	}
+	if dataDir == "" {
+		return n, nil
+	}
+	files, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	recovered := make(map[string]bool)
+	for _, file := range files {
+		// the directories a table is being rewritten in are recovered with the table, see replaceDurableTable
+		name := strings.SplitN(file.Name(), "#", 2)[0]
+		if !file.IsDir() || recovered[name] {
+			continue
+		}
+		recovered[name] = true
+		dir := filepath.Join(dataDir, name)
+		err := finishReplace(dir)
+		var t *Table
+		if err == nil {
+			t, err = recoverTable(dir)
+		}
+		if err != nil {
+			n.Close()
+			return nil, err
+		}
+		if t != nil {
+			n.TableMap[t.schema.TableName] = t
+		}
+	}
+	n.persist()
+	return n, nil
+}
+
+// persist saves the definitions of all tables into the persister, the caller must hold n.mu.
+func (n *Node) persist() {
+	if n.persister == nil {
+		return
+	}
+	metas := make([]tableMeta, 0, len(n.TableMap))
+	for _, t := range n.TableMap {
+		metas = append(metas, t.meta())
+	}
+	buffer := new(bytes.Buffer)
+	if err := labgob.NewEncoder(buffer).Encode(metas); err != nil {
+		log.Fatalf("%s cannot persist its tables: %v", n.Identifier, err)
+	}
+	n.persister.SaveNodeState(buffer.Bytes())
+}
+
+// readPersist restores the tables saved by persist.
+func (n *Node) readPersist(state []byte) error {
+	metas := make([]tableMeta, 0)
+	if err := labgob.NewDecoder(bytes.NewReader(state)).Decode(&metas); err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		var t *Table
+		if meta.Storage == StorageDurable {
+			dir := tableDir(n.dataDir, meta.Schema.TableName)
+			if err := finishReplace(dir); err != nil {
+				return err
+			}
+			recovered, err := recoverTable(dir)
+			if err != nil {
+				return err
+			}
+			if recovered == nil {
+				return fmt.Errorf("the files of table %s are missing", meta.Schema.TableName)
+			}
+			t = recovered
+		} else {
+			schema := meta.Schema
+			rowStore, err := newVolatileRowStore(&schema, meta.Storage)
+			if err != nil {
+				return err
+			}
+			t = NewTable(&schema, rowStore)
+			t.fullSchema = meta.FullSchema
+			t.predicate = meta.Predicate
+			t.storage = meta.Storage
+			if err := t.createIndexes(meta.Indexes); err != nil {
+				return err
+			}
+		}
+		n.TableMap[t.schema.TableName] = t
+	}
+	return nil
+}
+
+// Close releases the files held by the durable tables of the node.
+func (n *Node) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var result error
+	for _, t := range n.TableMap {
+		t.mu.Lock()
+		if err := t.rowStore.close(); err != nil {
+			result = err
+		}
+		t.mu.Unlock()
+	}
+	return result
+}
+
+// SayHello is an example about how to create a method that can be accessed by RPC (remote procedure call, methods that
+// can be called through network from another node). RPC methods should have exactly two arguments, the first one is the
+// actual argument (or an argument list), while the second one is a reference to the result.
+func (n *Node) SayHello(args interface{}, reply *string) {
+	// NOTICE: use reply (the second parameter) to pass the return value instead of "return" statements.
+	*reply = fmt.Sprintf("Hello %s, I am Node %s", args, n.Identifier)
+}
+
+// Heartbeat answers the heartbeats of the failure detector of the cluster.
+func (n *Node) Heartbeat(args interface{}, reply *string) {
+	*reply = "0 OK"
+}
+
+// CreateTable creates a Table on this node with the provided schema. It returns nil if the table is created
+// successfully, or an error if another table with the same name already exists.
+func (n *Node) CreateTable(schema *TableSchema) error {
+	return n.CreateTableWithStorage(schema, StorageMemory)
+}
+
+// CreateTableWithStorage creates a Table like CreateTable, and stores its rows in the kind of RowStore specified by
+// storage, which is one of the constants in row_store.go.
+func (n *Node) CreateTableWithStorage(schema *TableSchema, storage int) error {
+	return n.createTable(schema, nil, nil, storage)
+}
+
+// createTable publishes a fully initialized table in TableMap, so that concurrent requests never observe a table whose
+// predicate or full schema is not set yet.
+func (n *Node) createTable(schema *TableSchema, fullSchema *TableSchema, predicate *Predicate, storage int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// check if the table already exists
+	if _, ok := n.TableMap[schema.TableName]; ok {
+		return errors.New("table already exists")
+	}
+	rowStore, err := n.newRowStore(schema, fullSchema, predicate, storage)
+	if err != nil {
+		return err
+	}
+	// create a table and store it in the map
+	t := NewTable(schema, rowStore)
+	t.fullSchema = fullSchema
+	t.predicate = predicate
+	t.storage = storage
+	n.TableMap[schema.TableName] = t
+	n.persist()
+	return nil
+}
+
+// newRowStore creates an empty RowStore of the given kind for a table, and the files of a durable one.
+func (n *Node) newRowStore(schema *TableSchema, fullSchema *TableSchema, predicate *Predicate,
+	storage int) (RowStore, error) {
+	if storage != StorageDurable {
+		return newVolatileRowStore(schema, storage)
+	}
+	if n.dataDir == "" {
+		return nil, errors.New("node has no data directory for durable tables")
+	}
+	dir := tableDir(n.dataDir, schema.TableName)
+	meta := &tableMeta{Schema: *schema, FullSchema: fullSchema, Predicate: predicate, Storage: storage}
+	if err := writeTableMeta(dir, meta); err != nil {
+		return nil, err
+	}
+	return OpenDurableRowStore(dir)
+}
+
+// alterTable replaces the schema and the full schema of a table, and rewrites its rows: the values of the columns that
+// are still in the schema are kept, the columns that are new take their defaults, and the others are dropped. The
+// indexes on the remaining columns are rebuilt. Altering a table to the schemas it already has rewrites it to the same
+// rows, so that the alteration can be retried. The table is left as it is if it cannot be rewritten.
+func (n *Node) alterTable(schema *TableSchema, fullSchema *TableSchema) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	old, ok := n.TableMap[schema.TableName]
+	if !ok {
+		return errors.New("no such table")
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	rows := make([]Row, 0, old.rowStore.count())
+	iterator := old.rowStore.iterator()
+	for iterator.HasNext() {
+		oldRow := *iterator.Next()
+		row := make(Row, len(schema.ColumnSchemas))
+		for i, cs := range schema.ColumnSchemas {
+			if position := old.schema.ColumnIndex(cs.Name); position >= 0 {
+				row[i] = oldRow[position]
+			} else {
+				row[i] = cs.Default
+			}
+		}
+		rows = append(rows, row)
+	}
+	return n.replaceTable(old, schema, fullSchema, rows)
+}
+
+// truncateTable removes all the rows of a table, and keeps its definition and indexes.
+func (n *Node) truncateTable(tableName string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	old, ok := n.TableMap[tableName]
+	if !ok {
+		return errors.New("no such table")
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	return n.replaceTable(old, old.schema, old.fullSchema, nil)
+}
+
+// replaceTable replaces a table with a new one having the given schemas and rows, the predicate and the storage of the
+// old table, and its indexes on the columns that are still in the schema. The old table is kept if the new one cannot
+// be created. The caller must hold n.mu and the lock of the old table.
+func (n *Node) replaceTable(old *Table, schema *TableSchema, fullSchema *TableSchema, rows []Row) error {
+	if old.storage == StorageDurable {
+		return n.replaceDurableTable(old, schema, fullSchema, rows)
+	}
+	rowStore, err := newVolatileRowStore(schema, old.storage)
+	if err != nil {
+		return err
+	}
+	t, err := fillTable(old, schema, fullSchema, rowStore, rows)
+	if err != nil {
+		return err
+	}
+	n.TableMap[schema.TableName] = t
+	n.persist()
+	return nil
+}
+
+// replaceDurableTable replaces a durable table like replaceTable does. The new table is written into a directory of its
+// own, which then takes the place of the directory of the old table, so that a crash leaves either of them, see
+// finishReplace.
+func (n *Node) replaceDurableTable(old *Table, schema *TableSchema, fullSchema *TableSchema, rows []Row) error {
+	dir := tableDir(n.dataDir, schema.TableName)
+	newDir, oldDir := dir+newTableDirSuffix, dir+oldTableDirSuffix
+	if err := os.RemoveAll(newDir); err != nil {
+		return err
+	}
+	err := writeTableMeta(newDir, &tableMeta{Schema: *schema, FullSchema: fullSchema, Predicate: old.predicate,
+		Storage: old.storage})
+	var rowStore *DurableRowStore
+	if err == nil {
+		rowStore, err = OpenDurableRowStore(newDir)
+	}
+	if err == nil {
+		var t *Table
+		if t, err = fillTable(old, schema, fullSchema, rowStore, rows); err == nil {
+			// the indexes are recovered with the table
+			meta := t.meta()
+			err = writeTableMeta(newDir, &meta)
+		}
+		if closeErr := rowStore.close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = os.Rename(dir, oldDir)
+	}
+	if err != nil {
+		os.RemoveAll(newDir)
+		return err
+	}
+	if err := os.Rename(newDir, dir); err != nil {
+		if os.Rename(oldDir, dir) == nil {
+			os.RemoveAll(newDir)
+		}
+		return err
+	}
+	t, err := recoverTable(dir)
+	if err != nil {
+		// the old table is put back
+		if os.Rename(dir, newDir) == nil && os.Rename(oldDir, dir) == nil {
+			os.RemoveAll(newDir)
+		}
+		return err
+	}
+	n.TableMap[schema.TableName] = t
+	n.persist()
+	old.rowStore.close()
+	return os.RemoveAll(oldDir)
+}
+
+// fillTable creates a table replacing the old one, see replaceTable, with the given store and rows.
+func fillTable(old *Table, schema *TableSchema, fullSchema *TableSchema, rowStore RowStore, rows []Row) (*Table,
+	error) {
+	t := NewTable(schema, rowStore)
+	t.fullSchema = fullSchema
+	t.predicate = old.predicate
+	t.storage = old.storage
+	for i := range rows {
+		if err := t.insertLocked(&rows[i]); err != nil {
+			return nil, err
+		}
+	}
+	for column, index := range old.indexes {
+		if column != idColumnName && schema.ColumnIndex(column) >= 0 {
+			if err := t.createIndexLocked(column, index.kind); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// dropTable removes a table from this node together with its rows, and the files of a durable table. Dropping a table
+// that does not exist does nothing, so that the removal can be retried.
+func (n *Node) dropTable(tableName string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t, ok := n.TableMap[tableName]
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	err := t.rowStore.close()
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	delete(n.TableMap, tableName)
+	n.persist()
+	if t.storage == StorageDurable {
+		return os.RemoveAll(tableDir(n.dataDir, tableName))
+	}
+	return nil
+}
+
+// getTable returns the table with the given name and whether it exists.
+func (n *Node) getTable(tableName string) (*Table, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	t, ok := n.TableMap[tableName]
+	return t, ok
+}
+
+// Insert inserts a row into the specified table, and returns nil if succeeds or an error if the table does not exist.
+func (n *Node) Insert(tableName string, row *Row) error {
+	if t, ok := n.getTable(tableName); ok {
+		return t.Insert(row)
+	} else {
+		return errors.New("no such table")
+	}
+}
+
+// Remove removes a row from the specified table, and returns nil if succeeds or an error if the table does not exist.
+// It does not concern whether the provided row exists in the table.
+func (n *Node) Remove(tableName string, row *Row) error {
+	if t, ok := n.getTable(tableName); ok {
+		return t.Remove(row)
+	} else {
+		return errors.New("no such table")
+	}
+}
+
+// IterateTable returns an iterator of the table through which the caller can retrieve all rows in the table in the
+// order they are inserted. It returns (iterator, nil) if the Table can be found, or (nil, err) if the Table does not
+// exist.
+func (n *Node) IterateTable(tableName string) (RowIterator, error) {
+	if t, ok := n.getTable(tableName); ok {
+		return t.RowIterator(), nil
+	} else {
+		return nil, errors.New("no such table")
+	}
+}
+
+// IterateTable returns the count of rows in a table. It returns (cnt, nil) if the Table can be found, or (-1, err)
+// if the Table does not exist.
+func (n *Node) count(tableName string) (int, error) {
+	if t, ok := n.getTable(tableName); ok {
+		return t.Count(), nil
+	} else {
+		return -1, errors.New("no such table")
+	}
+}
+
+// ScanTable returns all rows in a table by the specified name or nothing if it does not exist.
+// This method is recommended only to be used for TEST PURPOSE, and try not to use this method in your implementation,
+// but you can use it in your own test cases.
+// The reason why we deprecate this method is that in practice, every table is so large that you cannot transfer a whole
+// table through network all at once, so sending a whole table in one RPC is very impractical. One recommended way is to
+// fetch a batch of Rows a time.
+func (n *Node) ScanTable(tableName string, dataset *Dataset) {
+	if t, ok := n.getTable(tableName); ok {
+		resultSet := Dataset{}
+
+		// the row count may change between Count() and the iteration under concurrent writes, so rows are appended
+		// rather than written into a preallocated slice
+		tableRows := make([]Row, 0, t.Count())
+		iterator := t.RowIterator()
+		for iterator.HasNext() {
+			tableRows = append(tableRows, *iterator.Next())
+		}
+
+		resultSet.Rows = tableRows
+		resultSet.Schema = *t.schema
+		*dataset = resultSet
+	}
+}
+
+// return a row which has id in tableName
+// args: tableName string, id int64
+func (n *Node) ScanLineData(args []interface{}, dataset *Dataset) {
+	tableName := args[0].(string)
+	id := args[1]
+
+	if t, ok := n.getTable(tableName); ok {
+		resultSet := Dataset{}
+
+		tableRows := make([]Row, 1)
+
+		// the id is the first column of a fragment and is looked up in its primary index
+		if rows, err := t.Lookup(t.GetColumnName(0), id); err == nil && len(rows) > 0 {
+			tableRows[0] = rows[0]
+		}
+
+		resultSet.Rows = tableRows
+		resultSet.Schema = *t.schema
+		*dataset = resultSet
+
+	}
+}
+
+// GetFullSchema returns the columns of the distributed table a fragment on this node belongs to, without the id, or
+// nothing if the table does not exist or is not a fragment.
+func (n *Node) GetFullSchema(tableName string, schema *[]ColumnSchema) {
+	res := make([]ColumnSchema, 0)
+	if t, ok := n.getTable(tableName); ok && t.fullSchema != nil {
+		for _, cs := range t.fullSchema.ColumnSchemas {
+			if cs.Name != idColumnName {
+				res = append(res, cs)
+			}
+		}
+	}
+	*schema = res
+}
+
+// RPCCountRows returns the number of rows in a table on this node, or -1 if the table does not exist.
+func (n *Node) RPCCountRows(tableName string, reply *int) {
+	count, _ := n.count(tableName)
+	*reply = count
+}
+
+// GetSchema returns the schema of a table on this node, or an empty schema if the table does not exist.
+func (n *Node) GetSchema(tableName string, schema *TableSchema) {
+	if t, ok := n.getTable(tableName); ok {
+		*schema = *t.schema
+	}
+}
+
+// RPCTableMeta returns the definition of a table on this node, so that the table can be created on another node, or an
+// empty definition if the table does not exist.
+func (n *Node) RPCTableMeta(tableName string, meta *tableMeta) {
+	if t, ok := n.getTable(tableName); ok {
+		*meta = t.meta()
+	}
+}
+
+func (n *Node) RPCCreateTable(args []interface{}, reply *string) {
+	schema := args[0].(TableSchema)
+	predicate := args[1].(Predicate)
+	fullSchema := args[2].(TableSchema)
+	if err := predicate.bind(fullSchema.ColumnSchemas); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	storage := StorageMemory
+	if len(args) > 3 {
+		storage = args[3].(int)
+	}
+	if err := n.createTable(&schema, &fullSchema, &predicate, storage); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+	} else {
+		*reply = "0 OK"
+	}
+}
+
+// RPCInsert inserts a row into a fragment on this node. The row has the columns of the fragment, the id first, as the
+// coordinator routes the rows of the distributed table and sends each fragment only the columns it holds. The row is
+// checked against the fragment nonetheless, see checkFragmentRow. The insertion may be retried, see Table.InsertOnce.
+// args: tableName string, row Row
+func (n *Node) RPCInsert(args []interface{}, reply *string) {
+	tableName := args[0].(string)
+	row := args[1].(Row)
+	t, ok := n.getTable(tableName)
+	if !ok {
+		*reply = "1 no such table"
+		return
+	}
+	if err := checkFragmentRow(t, row); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	if err := t.InsertOnce(&row); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// RPCInsertBatch inserts rows into several fragments on this node at once, each row as RPCInsert does. The reply has
+// an entry per fragment and per row, which is empty if the row is inserted, or the reason it is not.
+// args: tableNames []string, rows [][]Row (the rows of each table)
+func (n *Node) RPCInsertBatch(args []interface{}, reply *[][]string) {
+	tableNames := args[0].([]string)
+	rows := args[1].([][]Row)
+	results := make([][]string, len(tableNames))
+	for i, tableName := range tableNames {
+		results[i] = make([]string, len(rows[i]))
+		t, ok := n.getTable(tableName)
+		if !ok {
+			for j := range results[i] {
+				results[i][j] = "no such table"
+			}
+			continue
+		}
+		t.mu.Lock()
+		for j := range rows[i] {
+			if err := checkFragmentRow(t, rows[i][j]); err != nil {
+				results[i][j] = err.Error()
+			} else if err := t.insertOnceLocked(&rows[i][j]); err != nil {
+				results[i][j] = err.Error()
+			}
+		}
+		t.mu.Unlock()
+	}
+	*reply = results
+}
+
+// checkFragmentRow checks a row sent to a fragment against the types of its columns and the predicate of the fragment,
+// the atoms on the columns the fragment does not hold being ignored, so that a row routed to the wrong fragment is
+// rejected.
+func checkFragmentRow(t *Table, row Row) error {
+	if len(row) != len(t.schema.ColumnSchemas) {
+		return fmt.Errorf("expected %d values, actual %d", len(t.schema.ColumnSchemas), len(row))
+	}
+	for i, cs := range t.schema.ColumnSchemas {
+		if !CheckType(row[i], cs.DataType) {
+			return fmt.Errorf("%s's value doesn't conform its type", cs.Name)
+		}
+	}
+	if t.predicate != nil && !matchRow(t.schema.ColumnSchemas, row, *t.predicate) {
+		return errors.New("the row does not satisfy the predicate of the fragment")
+	}
+	return nil
+}
+
+func OpIsEqualOrNotEqual(op string) bool {
+	return op == "==" || op == "=" || op == "!=" || op == "<>"
+}
+
+func (n *Node) RPCJoin(args []interface{}, reply *string) {
+	tableName := args[0].(string)
+	if t, ok := n.getTable(tableName); ok {
+		row := args[1].(Row)
+		var subRow Row
+		for i, v := range row {
+			if !CheckType(v, t.fullSchema.ColumnSchemas[i].DataType) {
+				*reply = fmt.Sprintf("1 %v's value doesn't conform its type", t.fullSchema.ColumnSchemas[i].Name)
+				return
+			}
+		}
+		if !matchRow(t.fullSchema.ColumnSchemas, row, *t.predicate) {
+			*reply = "1 Predicate Check Fail"
+			return
+		}
+		for _, v := range t.schema.ColumnSchemas {
+			for i, cs := range t.fullSchema.ColumnSchemas {
+				if cs.Name == v.Name {
+					subRow = append(subRow, row[i])
+					break
+				}
+			}
+		}
+		if err := n.Insert(tableName, &subRow); err != nil {
+			*reply = fmt.Sprintf("1 %v", err)
+			return
+		}
+	}
+	*reply = "0 OK"
+}
+
+// RPCDropTable removes a table from this node, see dropTable.
+// args: tableName string
+func (n *Node) RPCDropTable(args []interface{}, reply *string) {
+	if err := n.dropTable(args[0].(string)); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// RPCTruncateTable removes all the rows of a table on this node, see truncateTable.
+// args: tableName string
+func (n *Node) RPCTruncateTable(args []interface{}, reply *string) {
+	if err := n.truncateTable(args[0].(string)); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// RPCAlterTable replaces the schema and the full schema of a table and rewrites its rows, see alterTable.
+// args: schema TableSchema, fullSchema TableSchema
+func (n *Node) RPCAlterTable(args []interface{}, reply *string) {
+	schema := args[0].(TableSchema)
+	fullSchema := args[1].(TableSchema)
+	if err := n.alterTable(&schema, &fullSchema); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// ListTables returns the names of all tables on this node.
+func (n *Node) ListTables(args interface{}, reply *[]string) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	names := make([]string, 0, len(n.TableMap))
+	for name := range n.TableMap {
+		names = append(names, name)
+	}
+	*reply = names
+}
+
+// RPCScanBatch returns at most limit rows of a fragment whose ids, which are the first column of every fragment, are
+// greater than the given one, in the order of their ids, so that a fragment can be transferred through several RPCs
+// instead of one, each reading a range of its id index. The schema of the returned dataset is empty if the table does
+// not exist or is not a fragment.
+// args: tableName string, after int64, limit int
+func (n *Node) RPCScanBatch(args []interface{}, dataset *Dataset) {
+	tableName := args[0].(string)
+	after := args[1].(int64)
+	limit := args[2].(int)
+	if t, ok := n.getTable(tableName); ok {
+		if rows, ok := t.ScanIds(after, limit); ok {
+			*dataset = Dataset{Schema: *t.schema, Rows: rows}
+		}
+	}
+}
+
+// RPCMergeRows inserts the given rows of a fragment, replacing the rows with the same ids, which are the first column
+// of every fragment, that differ from them. It is used to copy the rows a replica missed from another replica, and is
+// idempotent.
+// args: tableName string, rows []Row
+func (n *Node) RPCMergeRows(args []interface{}, reply *string) {
+	tableName := args[0].(string)
+	rows := args[1].([]Row)
+	t, ok := n.getTable(tableName)
+	if !ok {
+		*reply = "1 no such table"
+		return
+	}
+	if err := t.MergeRows(rows); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	*reply = "0 OK"
+}
+
+// RPCDeleteRows removes the rows of a fragment whose ids, which are the first column of every fragment, are in the
+// given list. Ids that are not in the fragment are ignored, so that the deletion can be retried.
+// args: tableName string, ids []int64
+func (n *Node) RPCDeleteRows(args []interface{}, reply *string) {
+	tableName := args[0].(string)
+	ids := args[1].([]int64)
+	t, ok := n.getTable(tableName)
+	if !ok {
+		*reply = "1 no such table"
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index, ok := t.indexes[idColumnName]
+	if !ok {
+		*reply = "1 " + tableName + " is not a fragment"
+		return
+	}
+	for _, id := range ids {
+		// the rows are copied as the index is modified by the removal
+		for _, row := range append([]Row(nil), index.index.get(id)...) {
+			if err := t.removeLocked(&row); err != nil {
+				*reply = fmt.Sprintf("1 %v", err)
+				return
+			}
+		}
+	}
+	*reply = "0 OK"
+}
+
+// RPCSelect returns the rows of a table satisfying the given predicate, atoms on the columns that are not in the table
+// are ignored. The schema of the returned dataset is empty if the table does not exist or the predicate does not fit
+// the columns.
+// args: tableName string, predicate Predicate
+func (n *Node) RPCSelect(args []interface{}, dataset *Dataset) {
+	tableName := args[0].(string)
+	predicate := args[1].(Predicate)
+	if t, ok := n.getTable(tableName); ok {
+		rows, err := t.Select(predicate)
+		if err != nil {
+			return
+		}
+		*dataset = Dataset{Schema: *t.schema, Rows: rows}
+	}
+}
+
+// RPCCreateIndex creates an index on a column of a table, which is maintained on every insertion and removal and used
+// by RPCSelect and ScanLineData.
+// args: tableName string, column string, kind int (see index.go)
+func (n *Node) RPCCreateIndex(args []interface{}, reply *string) {
+	tableName := args[0].(string)
+	column := args[1].(string)
+	kind := args[2].(int)
+	// hold the node lock so that the index is persisted together with the table definitions
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t, ok := n.TableMap[tableName]
+	if !ok {
+		*reply = "1 no such table"
+		return
+	}
+	if err := t.CreateIndex(column, kind); err != nil {
+		*reply = fmt.Sprintf("1 %v", err)
+		return
+	}
+	if t.storage == StorageDurable {
+		meta := t.meta()
+		if err := writeTableMeta(tableDir(n.dataDir, tableName), &meta); err != nil {
+			*reply = fmt.Sprintf("1 %v", err)
+			return
+		}
+	}
+	n.persist()
+	*reply = "0 OK"
+}
@@ -0,0 +1,46 @@
+package models
+
+import "sync"
+
+// Persister keeps the state a Node must not lose when it crashes, which is the definition (schema, full schema,
+// predicate and storage) of each of its tables. It plays the role of the disk of a machine in the simulator: a Cluster
+// keeps one Persister per node, and when a node is restarted, the new incarnation of the node is created with a copy
+// of the Persister of the old one, so that the old incarnation, which may still be running an RPC, can no longer
+// modify the state of the new one.
+// The rows of in-memory tables are not persisted, they are copied back from the other replicas after a restart, while
+// the rows of durable tables are recovered from their own files, see DurableRowStore.
+type Persister struct {
+	mu        sync.Mutex
+	nodeState []byte
+}
+
+func MakePersister() *Persister {
+	return &Persister{}
+}
+
+// Copy returns a new Persister holding the same state.
+func (ps *Persister) Copy() *Persister {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	np := MakePersister()
+	np.nodeState = ps.nodeState
+	return np
+}
+
+func (ps *Persister) SaveNodeState(state []byte) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.nodeState = state
+}
+
+func (ps *Persister) ReadNodeState() []byte {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.nodeState
+}
+
+func (ps *Persister) NodeStateSize() int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.nodeState)
+}
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// operators of atoms besides the comparisons, and logical operators. The logical operators are used as the keys of a
+// Predicate instead of column names, each of their atoms holding the operands in Args, e.g., the JSON
+//
+//	{"OR": [{"grade": [{"op": "<", "val": 2}]}, {"name": [{"op": "LIKE", "val": "A%"}]}]}
+//
+// means grade < 2 OR name LIKE 'A%'. So AND, OR and NOT cannot be used as column names in predicates.
+const (
+	// the value of the column is in the list of values given as Val
+	OpIn    = "IN"
+	OpNotIn = "NOT IN"
+	// the value of the column is between the two values given as Val, inclusively
+	OpBetween    = "BETWEEN"
+	OpNotBetween = "NOT BETWEEN"
+	// the value of the column matches the pattern given as Val, where % matches any string and _ any character
+	OpLike    = "LIKE"
+	OpNotLike = "NOT LIKE"
+	// the value of the column is null, the atom has no Val
+	OpIsNull    = "IS NULL"
+	OpIsNotNull = "IS NOT NULL"
+
+	// all the operands are satisfied
+	OpAnd = "AND"
+	// any of the operands is satisfied
+	OpOr = "OR"
+	// the operands are not all satisfied
+	OpNot = "NOT"
+)
+
+// And returns the predicate satisfied by the rows satisfying all the given predicates.
+func And(predicates ...Predicate) Predicate {
+	return Predicate{OpAnd: {{Op: OpAnd, Args: predicates}}}
+}
+
+// Or returns the predicate satisfied by the rows satisfying any of the given predicates.
+func Or(predicates ...Predicate) Predicate {
+	return Predicate{OpOr: {{Op: OpOr, Args: predicates}}}
+}
+
+// Not returns the predicate satisfied by the rows not satisfying the given one.
+func Not(predicate Predicate) Predicate {
+	return Predicate{OpNot: {{Op: OpNot, Args: []Predicate{predicate}}}}
+}
+
+func isLogicalOp(key string) bool {
+	return key == OpAnd || key == OpOr || key == OpNot
+}
+
+// isComparisonOp tells whether an operator compares the value of a column with the single value of an atom.
+func isComparisonOp(op string) bool {
+	switch op {
+	case "==", "=", "!=", "<>", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// normalizeOp makes the operators case insensitive, e.g., "is not  null" is IS NOT NULL.
+func normalizeOp(op string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(op), " "))
+}
+
+// UnmarshalJSON decodes a predicate, the keys being either column names mapped to lists of atoms, or logical
+// operators mapped to lists of predicates. Numbers are kept as json.Number.
+func (p *Predicate) UnmarshalJSON(data []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := decodeNumbers(data, &raw); err != nil {
+		return err
+	}
+	*p = make(Predicate, len(raw))
+	for key, value := range raw {
+		if op := normalizeOp(key); isLogicalOp(op) {
+			args := make([]Predicate, 0)
+			if err := decodeNumbers(value, &args); err != nil {
+				return err
+			}
+			(*p)[op] = append((*p)[op], Atom{Op: op, Args: args})
+			continue
+		}
+		atoms := make([]Atom, 0)
+		if err := decodeNumbers(value, &atoms); err != nil {
+			return err
+		}
+		(*p)[key] = atoms
+	}
+	return nil
+}
+
+// decodeNumbers decodes JSON like json.Unmarshal, but keeps the numbers as json.Number.
+func decodeNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// columns returns the names of the columns restricted by the predicate, including those in the operands of logical
+// operators.
+func (p Predicate) columns() []string {
+	names := make([]string, 0, len(p))
+	for key, atoms := range p {
+		if !isLogicalOp(key) {
+			names = append(names, key)
+			continue
+		}
+		for _, atom := range atoms {
+			for _, arg := range atom.Args {
+				names = append(names, arg.columns()...)
+			}
+		}
+	}
+	return names
+}
+
+// truth is the result of evaluating a predicate on a row: the set of the values of the three-valued logic of SQL the
+// predicate may have. It is a single value unless the predicate cannot be decided.
+type truth int
+
+const (
+	truthFalse truth = 1 << iota
+	truthTrue
+	// e.g., a comparison with null
+	truthUnknown
+	// the predicate restricts columns that are not in the row, so that the row can only be decided elsewhere, e.g.,
+	// with another vertical fragment
+	truthUndecided = truthFalse | truthTrue | truthUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// eval evaluates the predicate on a row with the given columns, the predicate must be bound to them.
+func (p Predicate) eval(columns []ColumnSchema, row Row) truth {
+	result := truthTrue
+	for key, atoms := range p {
+		var t truth
+		if isLogicalOp(key) {
+			t = truthTrue
+			for i := range atoms {
+				t = t.and(atoms[i].evalLogical(columns, row))
+			}
+		} else {
+			t = truthUndecided
+			for i, cs := range columns {
+				if cs.Name == key {
+					t = truthTrue
+					for j := range atoms {
+						t = t.and(atoms[j].eval(row[i]))
+					}
+					break
+				}
+			}
+		}
+		result = result.and(t)
+		if result == truthFalse {
+			return truthFalse
+		}
+	}
+	return result
+}
+
+func (n *Atom) evalLogical(columns []ColumnSchema, row Row) truth {
+	switch n.Op {
+	case OpOr:
+		result := truthFalse
+		for _, arg := range n.Args {
+			result = result.or(arg.eval(columns, row))
+		}
+		return result
+	case OpNot:
+		result := truthTrue
+		for _, arg := range n.Args {
+			result = result.and(arg.eval(columns, row))
+		}
+		return result.not()
+	}
+	result := truthTrue
+	for _, arg := range n.Args {
+		result = result.and(arg.eval(columns, row))
+	}
+	return result
+}
+
+func (a truth) and(b truth) truth {
+	return a.combine(b, func(x, y truth) truth {
+		if x == truthFalse || y == truthFalse {
+			return truthFalse
+		}
+		if x == truthUnknown || y == truthUnknown {
+			return truthUnknown
+		}
+		return truthTrue
+	})
+}
+
+func (a truth) or(b truth) truth {
+	return a.combine(b, func(x, y truth) truth {
+		if x == truthTrue || y == truthTrue {
+			return truthTrue
+		}
+		if x == truthUnknown || y == truthUnknown {
+			return truthUnknown
+		}
+		return truthFalse
+	})
+}
+
+func (a truth) not() truth {
+	var result truth
+	if a&truthTrue != 0 {
+		result |= truthFalse
+	}
+	if a&truthFalse != 0 {
+		result |= truthTrue
+	}
+	return result | a&truthUnknown
+}
+
+// combine applies a binary operator of the three-valued logic to all the values of two sets.
+func (a truth) combine(b truth, op func(x, y truth) truth) truth {
+	var result truth
+	for x := truthFalse; x <= truthUnknown; x <<= 1 {
+		for y := truthFalse; y <= truthUnknown; y <<= 1 {
+			if a&x != 0 && b&y != 0 {
+				result |= op(x, y)
+			}
+		}
+	}
+	return result
+}
+
+// matchLike tells whether a string matches a LIKE pattern, where % matches any string and _ any character. A
+// backslash escapes the next character of the pattern.
+func matchLike(s, pattern string) bool {
+	// the positions to retry from when a % has to match a longer string
+	star, retry := -1, 0
+	i, j := 0, 0
+	for i < len(s) {
+		if j < len(pattern) {
+			c, size := utf8.DecodeRuneInString(pattern[j:])
+			switch {
+			case c == '%':
+				star, retry = j+size, i
+				j += size
+				continue
+			case c == '_':
+				_, n := utf8.DecodeRuneInString(s[i:])
+				i, j = i+n, j+size
+				continue
+			case c == '\\' && j+size < len(pattern):
+				j += size
+				c, size = utf8.DecodeRuneInString(pattern[j:])
+			}
+			if r, n := utf8.DecodeRuneInString(s[i:]); r == c {
+				i, j = i+n, j+size
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// let the last % match one more character
+		_, n := utf8.DecodeRuneInString(s[retry:])
+		retry += n
+		i, j = retry, star
+	}
+	for j < len(pattern) && pattern[j] == '%' {
+		j++
+	}
+	return j == len(pattern)
+}
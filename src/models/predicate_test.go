@@ -0,0 +1,215 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"../labrpc"
+)
+
+func TestMatchLike(t *testing.T) {
+	cases := []struct {
+		s, pattern string
+		expected   bool
+	}{
+		{"Alice", "A%", true},
+		{"Alice", "a%", false},
+		{"Alice", "%ce", true},
+		{"Alice", "_lic_", true},
+		{"Alice", "_lic", false},
+		{"Alice", "%l%c%", true},
+		{"Alice", "%x%", false},
+		{"", "%", true},
+		{"", "_", false},
+		{"100%", "100\\%", true},
+		{"1000", "100\\%", false},
+		{"a_b", "a\\_b", true},
+		{"axb", "a\\_b", false},
+		{"héllo", "h_llo", true},
+		{"aaab", "%aab", true},
+	}
+	for _, c := range cases {
+		if matchLike(c.s, c.pattern) != c.expected {
+			t.Errorf("%q LIKE %q should be %v", c.s, c.pattern, c.expected)
+		}
+	}
+}
+
+func TestDecodePredicate(t *testing.T) {
+	var rule Rule
+	data := `{"predicate": {"or": [{"grade": [{"op": "<", "val": 2}]}, {"name": [{"op": "like", "val": "A%"}]}],
+		"sid": [{"op": "not in", "val": [1, 2]}]}, "column": ["sid"]}`
+	if err := json.Unmarshal([]byte(data), &rule); err != nil {
+		t.Fatalf("cannot decode %s: %v", data, err)
+	}
+	if len(rule.Predicate[OpOr]) != 1 || len(rule.Predicate[OpOr][0].Args) != 2 || len(rule.Predicate["sid"]) != 1 {
+		t.Errorf("unexpected predicate %v", rule.Predicate)
+	}
+	if _, ok := rule.Predicate["sid"][0].Val.([]interface{})[0].(json.Number); !ok {
+		t.Errorf("numbers should be decoded as json.Number, actual %v", rule.Predicate["sid"][0].Val)
+	}
+	invalid := `{"predicate": {"not": {"grade": [{"op": "<", "val": 2}]}}}`
+	if err := json.Unmarshal([]byte(invalid), &rule); err == nil {
+		t.Errorf("%s should not be decoded", invalid)
+	}
+	// unknown fields are ignored, a misspelled value leaves the atom without one
+	misspelled := `{"predicate": {"or": [{"grade": [{"op": "<", "value": 2}]}]}}`
+	if err := json.Unmarshal([]byte(misspelled), &rule); err != nil ||
+		rule.Predicate[OpOr][0].Args[0]["grade"][0].Val != nil {
+		t.Errorf("%s should be decoded without a value: %v", misspelled, err)
+	}
+}
+
+func TestPredicateExpressions(t *testing.T) {
+	schema := &TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	rows := []Row{
+		{int32(0), "Alice", 1.5},
+		{int32(1), "Bob", 3.95},
+		{int32(2), "Anna", 3.0},
+		{int32(3), "Carl", nil},
+		{int32(4), nil, 2.0},
+		{int32(5), "Al_x", 4.0},
+	}
+	cases := []struct {
+		predicate string
+		expected  []int32
+	}{
+		{`{"OR": [{"grade": [{"op": "<", "val": 2}]}, {"grade": [{"op": ">", "val": 3.9}]}]}`, []int32{0, 1, 5}},
+		{`{"name": [{"op": "LIKE", "val": "A%"}]}`, []int32{0, 2, 5}},
+		{`{"name": [{"op": "LIKE", "val": "Al\\_%"}]}`, []int32{5}},
+		{`{"name": [{"op": "NOT LIKE", "val": "A%"}]}`, []int32{1, 3}},
+		{`{"sid": [{"op": "IN", "val": [1, 3, 7]}]}`, []int32{1, 3}},
+		{`{"sid": [{"op": "NOT IN", "val": [1, 3]}]}`, []int32{0, 2, 4, 5}},
+		{`{"grade": [{"op": "BETWEEN", "val": [2, 3.95]}]}`, []int32{1, 2, 4}},
+		{`{"grade": [{"op": "NOT BETWEEN", "val": [2, 3.95]}]}`, []int32{0, 5}},
+		{`{"grade": [{"op": "IS NULL"}]}`, []int32{3}},
+		{`{"name": [{"op": "is not null"}], "grade": [{"op": "IS NOT NULL"}]}`, []int32{0, 1, 2, 5}},
+		{`{"NOT": [{"name": [{"op": "LIKE", "val": "A%"}], "sid": [{"op": "<", "val": 2}]}]}`, []int32{1, 2, 3, 4, 5}},
+		{`{"AND": [{"OR": [{"sid": [{"op": "<", "val": 1}]}, {"sid": [{"op": ">=", "val": 4}]}]},
+			{"NOT": [{"grade": [{"op": "IN", "val": [2]}]}]}]}`, []int32{0, 5}},
+	}
+	store := NewColumnarRowStore(schema)
+	columnar := NewTable(schema, store)
+	memory := NewTable(schema, NewMemoryListRowStore())
+	indexed := NewTable(schema, NewMemoryListRowStore())
+	if err := indexed.CreateIndex("sid", IndexHash); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := indexed.CreateIndex("grade", IndexBTree); err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := range rows {
+		columnar.Insert(&rows[i])
+		memory.Insert(&rows[i])
+		indexed.Insert(&rows[i])
+	}
+
+	for _, c := range cases {
+		var predicate Predicate
+		if err := json.Unmarshal([]byte(c.predicate), &predicate); err != nil {
+			t.Fatalf("cannot decode %s: %v", c.predicate, err)
+		}
+		for name, table := range map[string]*Table{"columnar": columnar, "memory": memory, "indexed": indexed} {
+			selected, err := table.Select(predicate)
+			if err != nil {
+				t.Fatalf("%s: cannot select %s: %v", name, c.predicate, err)
+			}
+			sids := make([]int32, 0)
+			for _, row := range selected {
+				sids = append(sids, row[0].(int32))
+			}
+			if !sameInt32s(sids, c.expected) {
+				t.Errorf("%s: %s expected %v, actual %v", name, c.predicate, c.expected, sids)
+			}
+		}
+		// the predicate of the caller is not bound by the selections
+		var decoded Predicate
+		if json.Unmarshal([]byte(c.predicate), &decoded); !reflect.DeepEqual(predicate, decoded) {
+			t.Errorf("%s: the predicate should not be modified, actual %v", c.predicate, predicate)
+		}
+	}
+
+	// the parts of a predicate on other columns do not exclude rows
+	predicate := Not(Predicate{"age": {{Op: ">", Val: 20}}})
+	if selected, _ := memory.Select(predicate); len(selected) != len(rows) {
+		t.Errorf("expected all rows, actual %v", selected)
+	}
+	for _, invalid := range []Predicate{
+		{"sid": {{Op: "BETWEEN", Val: []interface{}{1}}}},
+		{"sid": {{Op: "IN", Val: []interface{}{"1"}}}},
+		{"sid": {{Op: "LIKE", Val: "1%"}}},
+		{"grade": {{Op: "IS NULL", Val: 1.0}}},
+		{"grade": {{Op: "~", Val: 1.0}}},
+		Or(Predicate{"grade": {{Op: "IN", Val: 1.0}}}),
+	} {
+		if _, err := memory.Select(invalid); err == nil {
+			t.Errorf("%v should be rejected", invalid)
+		}
+	}
+}
+
+func sameInt32s(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[int32]int)
+	for _, x := range a {
+		count[x]++
+	}
+	for _, x := range b {
+		count[x]--
+		if count[x] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPartitionByExpression(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(2, network, "ExpressionCluster")
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	rules := []byte(`{
+		"0": {"predicate": {"OR": [{"grade": [{"op": "<", "val": 2}]}, {"grade": [{"op": ">", "val": 3.9}]}]},
+			"column": ["sid", "grade"]},
+		"1": {"predicate": {"grade": [{"op": "BETWEEN", "val": [2, 3.9]}]}, "column": ["sid", "grade"]}
+	}`)
+	report := PartitionReport{}
+	c.ValidateRules([]interface{}{schema, rules}, &report)
+	if !report.OK() || len(report.Warnings) != 2 || !strings.Contains(report.Warnings[0], "may overlap") {
+		t.Errorf("expected warnings on the overlaps and the coverage, actual %v", &report)
+	}
+	reply := ""
+	c.BuildTable([]interface{}{schema, rules}, &reply)
+	if reply != "0 OK" {
+		t.Fatalf("cannot build table: %v", reply)
+	}
+	for _, row := range []Row{{0, 1.0}, {1, 4.0}, {2, 2.0}, {3, 3.9}} {
+		if err := c.Insert("student", row); err != nil {
+			t.Errorf("cannot insert %v: %v", row, err)
+		}
+	}
+	for i, nodeId := range []string{"Node0", "Node1"} {
+		dataset := Dataset{}
+		for _, f := range c.tableFragments["student"] {
+			if f.nodes[0] == nodeId {
+				c.nodes[nodeId].ScanTable(f.name, &dataset)
+			}
+		}
+		if len(dataset.Rows) != 2 {
+			t.Errorf("expected 2 rows on node %d, actual %v", i, dataset.Rows)
+		}
+	}
+	if err := c.Insert("student", Row{4, nil}); err == nil {
+		t.Errorf("a row matching no rule should be rejected")
+	}
+}
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fragment is a fragment of a distributed table, defined by one of the partition rules given to BuildTable.
+type fragment struct {
+	// the name of the fragment on the nodes, e.g., "student|0", numbered in the order of the keys of the rules
+	name string
+	// the key of the partition rule defining the fragment, e.g., "0|1", or the nodes first holding the bucket of a
+	// table partitioned by hash. The nodes holding the fragment may differ after its replicas are moved
+	key string
+	// the nodes holding a replica of the fragment, e.g., "Node0"
+	nodes []string
+	// the columns of the table held by the fragment, without the id
+	columns []string
+	// the rows held by the fragment, bound to the columns of the table
+	predicate Predicate
+	// whether the fragment receives the rows matching no other fragment, see Rule.Default
+	isDefault bool
+	// the bucket held by the fragment of a table partitioned by hash, or -1
+	bucket int
+}
+
+// RoutingError is returned when a row cannot be routed to the fragments of a table, because no fragment holds some of
+// its columns or several fragments would hold the same column.
+type RoutingError struct {
+	Table string
+	Row   Row
+	// the fragments whose rules the row matches
+	Fragments []string
+	Reason    string
+}
+
+func (e *RoutingError) Error() string {
+	return fmt.Sprintf("row %v of %s %s (matched fragments: [%s])", e.Row, e.Table, e.Reason,
+		strings.Join(e.Fragments, ", "))
+}
+
+// Route returns the names of the fragments a row of the table is routed to, i.e., the fragments whose rules the row
+// satisfies, or the default fragments if it satisfies none, or the bucket of the row if the table is partitioned by
+// hash. Each column of the table must be held by one of them, and only by the vertical fragments of the same rows,
+// otherwise a *RoutingError is returned. The row must be complete and normalized, see Insert.
+func (c *Cluster) Route(tableName string, row Row) ([]string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	fragments, err := c.routeLocked(tableName, row)
+	if err != nil {
+		return nil, err
+	}
+	return namesOf(fragments), nil
+}
+
+// FragmentsOf reports the fragments a row of a table would be written to by FragmentWrite, or nothing if the row
+// cannot be routed.
+// params: tableName string, row Row
+func (c *Cluster) FragmentsOf(params []interface{}, reply *[]string) {
+	tableName := params[0].(string)
+	row := params[1].(Row)
+	c.mu.RLock()
+	schema, ok := c.tableSchemas[tableName]
+	c.mu.RUnlock()
+	if !ok {
+		return
+	}
+	row, err := schema.completeRow(row)
+	if err == nil {
+		row, err = schema.normalizeRow(row)
+	}
+	if err != nil {
+		return
+	}
+	if names, err := c.Route(tableName, row); err == nil {
+		*reply = names
+	}
+}
+
+// routeLocked returns the fragments a row is routed to, see Route. The caller must hold c.mu.
+func (c *Cluster) routeLocked(tableName string, row Row) ([]fragment, error) {
+	return route(c.tableSchemas[tableName], c.tableFragments[tableName], c.tableHashing[tableName], row)
+}
+
+// route returns the fragments among all the fragments of a table a row is routed to, with the hash partitioning of the
+// table, or nil if it is partitioned by rules.
+func route(schema *TableSchema, all []fragment, hashing *HashPartitioning, row Row) ([]fragment, error) {
+	tableName := schema.TableName
+	if hashing != nil {
+		return []fragment{all[hashing.bucketOf(hashing.keyValues(schema, row))]}, nil
+	}
+	matched := make([]fragment, 0)
+	for _, f := range all {
+		if !f.isDefault && matchRow(schema.ColumnSchemas, row, f.predicate) {
+			matched = append(matched, f)
+		}
+	}
+	if len(matched) == 0 {
+		for _, f := range all {
+			if f.isDefault {
+				matched = append(matched, f)
+			}
+		}
+	}
+	names := make([]string, len(matched))
+	for i := range matched {
+		names[i] = matched[i].name
+	}
+	if len(matched) == 0 {
+		return nil, &RoutingError{Table: tableName, Row: row, Fragments: names, Reason: "matches no partition rule"}
+	}
+
+	// the vertical fragments of the same rows may share columns, the fragments of different rows may not
+	for _, cs := range schema.ColumnSchemas {
+		holders := make([]fragment, 0, 1)
+		for _, f := range matched {
+			for _, column := range f.columns {
+				if column == cs.Name {
+					holders = append(holders, f)
+					break
+				}
+			}
+		}
+		if len(holders) == 0 {
+			return nil, &RoutingError{Table: tableName, Row: row, Fragments: names,
+				Reason: "has column " + cs.Name + " held by none of the matched fragments"}
+		}
+		for _, f := range holders[1:] {
+			if !f.sameRows(&holders[0]) {
+				return nil, &RoutingError{Table: tableName, Row: row, Fragments: names,
+					Reason: "is ambiguous as column " + cs.Name + " is held by " +
+						strings.Join(namesOf(holders), " and ")}
+			}
+		}
+	}
+	return matched, nil
+}
+
+// sameRows tells whether two fragments of a table hold the same rows, i.e., they are both default fragments or their
+// rules have the same predicate, as the vertical fragments of a horizontal partition do.
+func (f *fragment) sameRows(another *fragment) bool {
+	if f.isDefault || another.isDefault {
+		return f.isDefault && another.isDefault
+	}
+	return samePredicate(f.predicate, another.predicate)
+}
+
+// samePredicate tells whether two predicates are written alike, and then hold for the same rows.
+func samePredicate(a, b Predicate) bool {
+	return (len(a) == 0 && len(b) == 0) || reflect.DeepEqual(a, b)
+}
+
+// project returns the row of the fragment holding the given row of the table with the given id, i.e., the id followed
+// by the values of the columns of the fragment.
+func (f *fragment) project(schema *TableSchema, row Row, id int64) Row {
+	fragmentRow := make(Row, 0, len(f.columns)+1)
+	fragmentRow = append(fragmentRow, id)
+	for _, column := range f.columns {
+		fragmentRow = append(fragmentRow, row[schema.ColumnIndex(column)])
+	}
+	return fragmentRow
+}
+
+// fragmentNames returns the names of the fragments of a table in the order of their numbers.
+func (c *Cluster) fragmentNames(tableName string) []string {
+	return namesOf(c.fragmentsOf(tableName, false))
+}
+
+// fragmentsOf returns the fragments of a table in the order of their numbers, followed by the fragments being filled
+// by AlterPartitioning if withPending is true.
+func (c *Cluster) fragmentsOf(tableName string, withPending bool) []fragment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	fragments := append([]fragment(nil), c.tableFragments[tableName]...)
+	if pending, ok := c.pendingLayouts[tableName]; ok && withPending {
+		fragments = append(fragments, pending.fragments...)
+	}
+	return fragments
+}
+
+// assignedFragments returns every fragment in the catalog by name, including the fragments being filled by
+// AlterPartitioning.
+func (c *Cluster) assignedFragments() map[string]fragment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	assigned := make(map[string]fragment)
+	for _, fragments := range c.tableFragments {
+		for _, f := range fragments {
+			assigned[f.name] = f
+		}
+	}
+	for _, pending := range c.pendingLayouts {
+		for _, f := range pending.fragments {
+			assigned[f.name] = f
+		}
+	}
+	return assigned
+}
+
+func namesOf(fragments []fragment) []string {
+	names := make([]string, len(fragments))
+	for i := range fragments {
+		names[i] = fragments[i].name
+	}
+	return names
+}
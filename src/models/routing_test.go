@@ -0,0 +1,222 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"../labrpc"
+)
+
+func setupRoutingCluster(t *testing.T, m map[string]interface{}) (*Cluster, *labrpc.Network, *labrpc.ClientEnd) {
+	c, network, cli := newTestCluster(3, "Routing")
+
+	schema := &TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	rules, _ := json.Marshal(m)
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	return c, network, cli
+}
+
+func gradeRule(op string, val float64, columns ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"predicate": map[string]interface{}{"grade": [...]map[string]interface{}{{"op": op, "val": val}}},
+		"column":    columns,
+	}
+}
+
+func TestRouteHorizontal(t *testing.T) {
+	c, _, cli := setupRoutingCluster(t, map[string]interface{}{
+		"0":   gradeRule("<=", 3.6, "sid", "grade"),
+		"1|2": gradeRule(">", 3.6, "sid", "grade"),
+	})
+	fragments := make([]string, 0)
+	cli.Call("Cluster.FragmentsOf", []interface{}{"student", Row{0, 4.0}}, &fragments)
+	for _, f := range c.tableFragments["student"] {
+		if f.nodes[0] == "Node1" && (len(fragments) != 1 || fragments[0] != f.name) {
+			t.Errorf("expected %s on Node1 and Node2, actual %v", f.name, fragments)
+		}
+	}
+
+	// no rule holds for a null grade
+	var routingErr *RoutingError
+	if _, err := c.Route("student", Row{int32(1), nil}); !errors.As(err, &routingErr) ||
+		len(routingErr.Fragments) != 0 {
+		t.Errorf("expected a row matching no rule, actual %v", err)
+	}
+	reply := ""
+	cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{2, nil}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a row matching no rule should be rejected")
+	}
+	if err := c.Insert("student", Row{3, 2.0}); err != nil {
+		t.Errorf("cannot insert a row matching one rule: %v", err)
+	}
+	if c.rowCount("student") != 1 {
+		t.Errorf("only the stored row should be counted, actual %d", c.rowCount("student"))
+	}
+}
+
+func TestRouteDefaultFragment(t *testing.T) {
+	c, _, _ := setupRoutingCluster(t, map[string]interface{}{
+		"0": gradeRule(">", 3.6, "sid", "grade"),
+		"1": map[string]interface{}{"default": true, "column": []string{"sid", "grade"}},
+	})
+	for _, row := range []Row{{0, 4.0}, {1, 2.0}, {2, nil}} {
+		if err := c.Insert("student", row); err != nil {
+			t.Errorf("cannot insert %v: %v", row, err)
+		}
+	}
+	dataset := Dataset{}
+	c.nodes["Node1"].ScanTable(c.tableFragments["student"][0].name, &dataset)
+	if len(dataset.Rows) == 0 {
+		c.nodes["Node1"].ScanTable(c.tableFragments["student"][1].name, &dataset)
+	}
+	if len(dataset.Rows) != 2 {
+		t.Errorf("expected 2 rows in the default fragment, actual %v", dataset.Rows)
+	}
+}
+
+func TestRouteVertical(t *testing.T) {
+	c, network, _ := setupRoutingCluster(t, map[string]interface{}{
+		"0|1": gradeRule(">=", 0, "sid"),
+		"2":   gradeRule(">=", 0, "grade"),
+	})
+	fragments, err := c.Route("student", Row{int32(0), 4.0})
+	if err != nil || len(fragments) != 2 {
+		t.Errorf("expected both vertical fragments, actual %v, %v", fragments, err)
+	}
+	if err := c.Insert("student", Row{0, 4.0}); err != nil {
+		t.Fatalf("cannot insert a row: %v", err)
+	}
+
+	// vertical fragments of the same rows may share a column, e.g., the key of the rows
+	shared, _, _ := setupRoutingCluster(t, map[string]interface{}{
+		"0|1": gradeRule(">=", 0, "sid"),
+		"2":   gradeRule(">=", 0, "sid", "grade"),
+	})
+	if err := shared.Insert("student", Row{0, 4.0}); err != nil {
+		t.Errorf("cannot insert into vertical fragments sharing a column: %v", err)
+	}
+	if selected, err := shared.Select("student", Predicate{}); err != nil || len(selected.Rows) != 1 ||
+		selected.Rows[0][1] != 4.0 {
+		t.Errorf("unexpected rows %v %v", selected.Rows, err)
+	}
+
+	// the only replica of the grade fragment is down, the row must not be left in the sid fragment
+	network.DeleteServer("Node2")
+	if err := c.Insert("student", Row{1, 3.0}); err == nil {
+		t.Errorf("a row missing a fragment should not be inserted")
+	}
+	if c.rowCount("student") != 1 {
+		t.Errorf("the missed row should not be counted, actual %d", c.rowCount("student"))
+	}
+	for _, nodeId := range []string{"Node0", "Node1"} {
+		for _, f := range c.tableFragments["student"] {
+			dataset := Dataset{}
+			c.nodes[nodeId].ScanTable(f.name, &dataset)
+			if dataset.Schema.TableName != "" && len(dataset.Rows) != 1 {
+				t.Errorf("expected 1 row in %s on %s, actual %v", f.name, nodeId, dataset.Rows)
+			}
+		}
+	}
+}
+
+func TestFragmentCatalog(t *testing.T) {
+	c, network, cli := setupRoutingCluster(t, map[string]interface{}{
+		"2":   gradeRule(">=", 3, "grade"),
+		"0|1": gradeRule("<", 3, "sid", "grade"),
+		"1":   gradeRule(">=", 3, "sid"),
+	})
+	// the fragments are numbered in the order of the keys of the rules
+	for i, expected := range []fragment{
+		{name: "student|0", key: "0|1", nodes: []string{"Node0", "Node1"}, columns: []string{"sid", "grade"}},
+		{name: "student|1", key: "1", nodes: []string{"Node1"}, columns: []string{"sid"}},
+		{name: "student|2", key: "2", nodes: []string{"Node2"}, columns: []string{"grade"}},
+	} {
+		f := c.tableFragments["student"][i]
+		if f.name != expected.name || f.key != expected.key || strings.Join(f.nodes, ",") !=
+			strings.Join(expected.nodes, ",") || strings.Join(f.columns, ",") != strings.Join(expected.columns, ",") {
+			t.Errorf("expected %+v, actual %+v", expected, f)
+		}
+	}
+	description := TableDescription{}
+	if cli.Call("Cluster.DescribeTable", "student", &description); description.Fragments[1].Key != "1" {
+		t.Errorf("expected the key 1, actual %+v", description.Fragments[1])
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := c.Insert("student", Row{i, float64(i)}); err != nil {
+			t.Fatalf("cannot insert row %d: %v", i, err)
+		}
+	}
+	// a scan reads each of the 4 replicas once rather than each fragment name on each node
+	before := network.GetTotalCount()
+	selected, err := c.Select("student", Predicate{})
+	if err != nil || len(selected.Rows) != 4 {
+		t.Errorf("expected 4 rows, actual %v (%v)", selected.Rows, err)
+	}
+	if count := network.GetTotalCount() - before; count != 4 {
+		t.Errorf("expected 4 RPCs, actual %d", count)
+	}
+}
+
+func TestInsertTargetsReplicas(t *testing.T) {
+	c, network, _ := setupRoutingCluster(t, map[string]interface{}{
+		"0|1": gradeRule("<", 3, "sid"),
+		"2":   gradeRule("<", 3, "grade"),
+		"1|2": gradeRule(">=", 3, "sid", "grade"),
+	})
+	// each insertion costs an RPC per replica of the fragments the row is routed to
+	for _, test := range []struct {
+		row      Row
+		replicas int
+	}{{Row{0, 1.0}, 3}, {Row{1, 4.0}, 2}, {Row{2, 2.0}, 3}} {
+		before := network.GetTotalCount()
+		if err := c.Insert("student", test.row); err != nil {
+			t.Fatalf("cannot insert %v: %v", test.row, err)
+		}
+		if count := network.GetTotalCount() - before; count != test.replicas {
+			t.Errorf("expected %d RPCs to insert %v, actual %d", test.replicas, test.row, count)
+		}
+	}
+
+	// each replica receives the columns of its fragment only
+	for _, f := range c.tableFragments["student"] {
+		for _, nodeId := range f.nodes {
+			dataset := Dataset{}
+			c.nodes[nodeId].ScanTable(f.name, &dataset)
+			for _, row := range dataset.Rows {
+				if len(row) != len(f.columns)+1 {
+					t.Errorf("expected the columns %v in %s on %s, actual %v", f.columns, f.name, nodeId, row)
+				}
+			}
+		}
+	}
+	selected, err := c.Select("student", Predicate{})
+	if err != nil || len(selected.Rows) != 3 || selected.Rows[2][0] != int32(2) || selected.Rows[2][1] != 2.0 {
+		t.Errorf("unexpected rows %v (%v)", selected.Rows, err)
+	}
+
+	// a replica checks the rows sent to it on the columns it holds
+	for _, f := range c.tableFragments["student"] {
+		if f.nodes[0] != "Node2" || len(f.columns) != 1 {
+			continue
+		}
+		for _, test := range []struct {
+			row Row
+			ok  bool
+		}{{Row{int64(9), 1.0}, true}, {Row{int64(10), 4.0}, false}, {Row{int64(11), "low"}, false}} {
+			reply := ""
+			c.nodes["Node2"].RPCInsert([]interface{}{f.name, test.row}, &reply)
+			if (reply == "0 OK") != test.ok {
+				t.Errorf("expected %v inserted into %s: %v, actual %s", test.row, f.name, test.ok, reply)
+			}
+		}
+	}
+}
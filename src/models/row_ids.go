@@ -0,0 +1,240 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// rowIds is what the coordinator knows about the ids of the rows of a distributed table. The ids are assigned from a
+// sequence of the table in the order the rows are inserted, and the rows are found through the id index of each
+// fragment on the nodes, so that the coordinator keeps a few counters per table rather than the id of every row.
+type rowIds struct {
+	// the id of the next row inserted, the first row of a table has id 1
+	next int64
+	// the rows with smaller ids were removed by TruncateTable, they are ignored on the replicas that missed it
+	floor int64
+	// the number of rows in the table
+	count int
+	// the ids of the rows being inserted, which are read once all their fragments store them
+	pending map[int64]bool
+	// the ids of the removed rows -> the replicas that may still hold them, see markRemoved
+	removed map[int64][]replica
+	// the tokens of the last writes -> the ids of the rows they inserted, or 0 if they failed, see Cluster.InsertOnce
+	tokens map[string]int64
+	// the tokens in the order they were given, the oldest being forgotten after maxWriteTokens
+	tokenOrder []string
+}
+
+// how many tokens of writes a table remembers, i.e., how many writes may happen before a retried one is applied again
+const maxWriteTokens = 1 << 16
+
+// replica is a fragment on a node.
+type replica struct {
+	fragment string
+	nodeId   string
+}
+
+func newRowIds() *rowIds {
+	return &rowIds{next: 1, floor: 1, pending: make(map[int64]bool), removed: make(map[int64][]replica),
+		tokens: make(map[string]int64)}
+}
+
+// visible tells whether the row with the given id, found on a replica, is a row of the table the replica still holds.
+func (ids *rowIds) visible(id int64, r replica) bool {
+	if id < ids.floor || ids.pending[id] {
+		return false
+	}
+	for _, other := range ids.removed[id] {
+		if other == r {
+			return false
+		}
+	}
+	return true
+}
+
+// remember records the id of the row inserted by the write with the given token, see Cluster.InsertOnce.
+func (ids *rowIds) remember(token string, id int64) {
+	if _, ok := ids.tokens[token]; !ok {
+		if len(ids.tokenOrder) == maxWriteTokens {
+			delete(ids.tokens, ids.tokenOrder[0])
+			ids.tokenOrder = ids.tokenOrder[1:]
+		}
+		ids.tokenOrder = append(ids.tokenOrder, token)
+	}
+	ids.tokens[token] = id
+}
+
+// rowId returns the id of a row, which ends with it, or of a fragment row, which starts with it.
+func rowId(value interface{}) int64 {
+	id, _ := toInt64(value)
+	return id
+}
+
+// sortByIds orders rows ending with their ids by their ids, i.e., in the order they are inserted.
+func sortByIds(rows []Row) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rowId(rows[i][len(rows[i])-1]) < rowId(rows[j][len(rows[j])-1])
+	})
+}
+
+// tableOf returns the name of the distributed table a fragment belongs to, e.g., "student" for "student|0".
+func tableOf(fragment string) string {
+	return fragment[:strings.LastIndex(fragment, "|")]
+}
+
+// reserveId assigns the next id of a table to a row being inserted. The row is not read until commitId is called. The
+// caller must hold c.mu exclusively.
+func (c *Cluster) reserveId(tableName string) int64 {
+	ids := c.tableIds[tableName]
+	id := ids.next
+	ids.next++
+	ids.pending[id] = true
+	return id
+}
+
+// releaseId gives back the id of a row rejected before it is sent to any node, so that the next row takes it if no
+// other id was assigned since. The caller must hold c.mu exclusively, and must not have released it since reserveId.
+func (c *Cluster) releaseId(tableName string, id int64) {
+	ids := c.tableIds[tableName]
+	delete(ids.pending, id)
+	if id == ids.next-1 {
+		ids.next--
+	}
+}
+
+// commitId makes a row being inserted visible, once all its fragments store it, or forgets its id if it is not
+// stored. The caller must hold c.mu exclusively.
+func (c *Cluster) commitId(tableName string, id int64, stored bool) {
+	ids, ok := c.tableIds[tableName]
+	if !ok {
+		return
+	}
+	delete(ids.pending, id)
+	if stored {
+		ids.count++
+	}
+}
+
+// checkToken tells whether the write with the given token is done or in progress, see InsertOnce, in which case an
+// error is returned if it is in progress. The caller must hold c.mu.
+func (c *Cluster) checkToken(tableName string, token string) (bool, error) {
+	id := c.tableIds[tableName].tokens[token]
+	if token == "" || id == 0 {
+		return false, nil
+	}
+	if c.tableIds[tableName].pending[id] {
+		return true, fmt.Errorf("the write %s is in progress", token)
+	}
+	return true, nil
+}
+
+// markRemoved records that a replica may still hold removed rows, e.g., as it missed their removal, so that they are
+// no longer read from it, nor copied from it to the other replicas, until the removal is replayed on it, see
+// replayRemovals, or the replica is moved to another node, see forgetReplica. The caller must hold c.mu exclusively.
+func (c *Cluster) markRemoved(tableName string, removed []int64, r replica) {
+	ids, ok := c.tableIds[tableName]
+	if !ok {
+		return
+	}
+	for _, id := range removed {
+		ids.removed[id] = append(ids.removed[id], r)
+	}
+}
+
+// replayRemovals removes from the replicas on a node the rows whose removal they missed, see markRemoved. A replica
+// that no longer exists has no rows to remove. The caller must hold c.writeMu exclusively.
+func (c *Cluster) replayRemovals(nodeId string) {
+	c.mu.RLock()
+	missed := make(map[string][]int64)
+	for _, ids := range c.tableIds {
+		for id, replicas := range ids.removed {
+			for _, r := range replicas {
+				if r.nodeId == nodeId {
+					missed[r.fragment] = append(missed[r.fragment], id)
+				}
+			}
+		}
+	}
+	c.mu.RUnlock()
+
+	for fragment, removed := range missed {
+		replyMsg := ""
+		if !c.nodeEnd(nodeId).Call("Node.RPCDeleteRows", []interface{}{fragment, removed}, &replyMsg) ||
+			(replyMsg[0] != '0' && replyMsg != "1 no such table") {
+			continue
+		}
+		c.mu.Lock()
+		c.unmarkRemoved(tableOf(fragment), removed, replica{fragment, nodeId})
+		c.mu.Unlock()
+	}
+}
+
+// unmarkRemoved records that a replica no longer holds the removed rows, see markRemoved. The caller must hold c.mu
+// exclusively.
+func (c *Cluster) unmarkRemoved(tableName string, removed []int64, r replica) {
+	ids, ok := c.tableIds[tableName]
+	if !ok {
+		return
+	}
+	for _, id := range removed {
+		replicas := make([]replica, 0, len(ids.removed[id]))
+		for _, other := range ids.removed[id] {
+			if other != r {
+				replicas = append(replicas, other)
+			}
+		}
+		if len(replicas) > 0 {
+			ids.removed[id] = replicas
+		} else {
+			delete(ids.removed, id)
+		}
+	}
+}
+
+// forgetReplica forgets the removed rows a replica may still hold, see markRemoved, once the replica no longer belongs
+// to its fragment. The caller must hold c.mu exclusively.
+func (c *Cluster) forgetReplica(tableName string, r replica) {
+	ids, ok := c.tableIds[tableName]
+	if !ok {
+		return
+	}
+	removed := make([]int64, 0)
+	for id, replicas := range ids.removed {
+		for _, other := range replicas {
+			if other == r {
+				removed = append(removed, id)
+				break
+			}
+		}
+	}
+	c.unmarkRemoved(tableName, removed, r)
+}
+
+// visibleRows returns the fragment rows, starting with their ids, read from a replica that are rows of the table, see
+// rowIds.visible.
+func (c *Cluster) visibleRows(r replica, fragmentRows []Row) []Row {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ids, ok := c.tableIds[tableOf(r.fragment)]
+	if !ok {
+		return nil
+	}
+	visible := make([]Row, 0, len(fragmentRows))
+	for _, row := range fragmentRows {
+		if ids.visible(rowId(row[0]), r) {
+			visible = append(visible, row)
+		}
+	}
+	return visible
+}
+
+// rowCount returns the number of rows of a table.
+func (c *Cluster) rowCount(tableName string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if ids, ok := c.tableIds[tableName]; ok {
+		return ids.count
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"testing"
+
+	"../labrpc"
+)
+
+func TestRowIds(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c, err := NewClusterWithDataDir(2, network, "RowIdCluster", t.TempDir())
+	if err != nil {
+		t.Fatalf("cannot create cluster: %v", err)
+	}
+	cli := connectEnd(network, "RowIdClient", c.Name)
+
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+	}, PrimaryKey: []string{"sid"}}
+	rules := []byte(`{"0|1": {"predicate": {}, "column": ["sid", "name"], "storage": "durable"}}`)
+	reply := ""
+	if err := buildTestTable(cli, schema, rules); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"John", "Mary", "Lucy"} {
+		if cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{i, name}}, &reply); reply != "0 OK" {
+			t.Fatalf("cannot insert row %d: %v", i, reply)
+		}
+	}
+
+	// the rows are numbered from 1 in the order they are inserted, in a typed column
+	dataset := Dataset{}
+	c.nodes["Node0"].ScanTable("student|0", &dataset)
+	if dataset.Schema.ColumnSchemas[0].DataType != idDataType || len(dataset.Rows) != 3 {
+		t.Fatalf("unexpected fragment %v", dataset)
+	}
+	for i, row := range dataset.Rows {
+		if row[0] != int64(i+1) {
+			t.Errorf("expected id %d, actual %v", i+1, row[0])
+		}
+	}
+
+	// a write retried by the client with its token is not duplicated, but a row with the key of another is rejected,
+	// even with the same values
+	for i := 0; i < 2; i++ {
+		cli.Call("Cluster.FragmentWrite", []interface{}{"student", Row{3, "Anna"}, "write-3"}, &reply)
+		if reply != "0 OK" {
+			t.Errorf("a retried write should succeed, actual %v", reply)
+		}
+	}
+	for _, row := range []Row{{1, "Mary"}, {1, "Jack"}} {
+		if cli.Call("Cluster.FragmentWrite", []interface{}{"student", row}, &reply); reply == "0 OK" {
+			t.Errorf("the duplicate primary key of %v should be rejected", row)
+		}
+	}
+	if c.rowCount("student") != 4 {
+		t.Errorf("expected 4 rows, actual %d", c.rowCount("student"))
+	}
+
+	// so is an insertion retried by the coordinator on a node
+	c.nodes["Node0"].RPCInsert([]interface{}{"student|0", Row{int64(2), int32(1), "Mary"}}, &reply)
+	if reply != "0 OK" {
+		t.Errorf("a retried insertion should succeed, actual %v", reply)
+	}
+	c.nodes["Node0"].RPCInsert([]interface{}{"student|0", Row{int64(2), int32(1), "Jack"}}, &reply)
+	if reply == "0 OK" {
+		t.Errorf("a row with the id of another should be rejected")
+	}
+	count := 0
+	if c.nodes["Node0"].RPCCountRows("student|0", &count); count != 4 {
+		t.Errorf("expected 4 rows on Node0, actual %d", count)
+	}
+
+	// a replica missing a deletion does not serve the deleted row, and removes it once it is back
+	network.DeleteServer("Node1")
+	if deleted, err := c.Delete("student", Predicate{"sid": {{Op: "=", Val: 0}}}); err != nil || deleted != 1 {
+		t.Fatalf("cannot delete: %v %v", deleted, err)
+	}
+	if len(c.tableIds["student"].removed) != 1 {
+		t.Errorf("the deletion missed by Node1 should be recorded, actual %v", c.tableIds["student"].removed)
+	}
+	if cli.Call("Cluster.RestartNode", "Node1", &reply); reply != "0 OK" {
+		t.Fatalf("cannot restart Node1: %v", reply)
+	}
+	if c.nodes["Node1"].RPCCountRows("student|0", &count); count != 3 || len(c.tableIds["student"].removed) != 0 {
+		t.Errorf("expected the deletion replayed on Node1, actual %d rows %v", count, c.tableIds["student"].removed)
+	}
+	selected, err := c.Select("student", Predicate{})
+	if err != nil || len(selected.Rows) != 3 || selected.Rows[0][0] != int32(1) {
+		t.Errorf("unexpected rows %v %v", selected.Rows, err)
+	}
+
+	// the sequence goes on after a truncation
+	if cli.Call("Cluster.TruncateTable", "student", &reply); reply != "0 OK" {
+		t.Fatalf("cannot truncate table: %v", reply)
+	}
+	if err := c.Insert("student", Row{0, "John"}); err != nil {
+		t.Fatalf("cannot insert after the truncation: %v", err)
+	}
+	if c.nodes["Node1"].ScanTable("student|0", &dataset); len(dataset.Rows) != 1 || dataset.Rows[0][0] != int64(5) {
+		t.Errorf("expected the row with id 5, actual %v", dataset.Rows)
+	}
+
+	// the deletions missed by a node are forgotten once its replicas are gone
+	network.DeleteServer("Node1")
+	if _, err := c.Delete("student", Predicate{}); err != nil || len(c.tableIds["student"].removed) != 1 {
+		t.Fatalf("expected the deletion missed by Node1 recorded, actual %v %v", c.tableIds["student"].removed, err)
+	}
+	if cli.Call("Cluster.DecommissionNode", "Node1", &reply); reply != "0 OK" {
+		t.Fatalf("cannot decommission Node1: %v", reply)
+	}
+	if len(c.tableIds["student"].removed) != 0 {
+		t.Errorf("expected the deletions of Node1 forgotten, actual %v", c.tableIds["student"].removed)
+	}
+}
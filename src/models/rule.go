@@ -2,13 +2,35 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strconv"
+	"strings"
 )
 
 type Rule struct {
 	Predicate
 	Column []string
+	// the kind of RowStore holding the fragment, e.g., "memory" (the default) or "durable", see ParseStorage
+	Storage string
+	// whether the fragment receives the rows matching none of the other rules of the table, its predicate is ignored
+	Default bool
+}
+
+// UnmarshalJSON decodes a rule, it is needed as the embedded Predicate has its own UnmarshalJSON.
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var fields struct {
+		Predicate Predicate
+		Column    []string
+		Storage   string
+		Default   bool
+	}
+	if err := decodeNumbers(data, &fields); err != nil {
+		return err
+	}
+	*r = Rule{Predicate: fields.Predicate, Column: fields.Column, Storage: fields.Storage, Default: fields.Default}
+	return nil
 }
 
 type Predicate map[string][]Atom
@@ -17,6 +39,8 @@ type Atom struct {
 	Op  string
 	Val interface{}
 	RealValue
+	// the operands of a logical operator, see OpAnd
+	Args []Predicate `json:"-"`
 }
 
 type RealValue struct {
@@ -26,96 +50,269 @@ type RealValue struct {
 	RealType    int
 }
 
-func (n *Atom) Check(value interface{}) bool {
-	if value == nil {
-		return (n.Val == nil && (n.Op == "==" || n.Op == "=" || n.Op == ">=" || n.Op == "<=")) || (n.Val != nil && (n.Op == "!=" || n.Op == "<>"))
-	}
-	if n.Val == nil {
-		return n.Op == "!=" || n.Op == "<>"
-	}
-
-	var b RealValue
-	b.filledWith(value, n.RealType)
-	if n.Op == "==" || n.Op == "=" {
-		return n.Val == value
-	}
-	if n.Op == "!=" || n.Op == "<>" {
-		return n.Val != value
-	}
-	switch n.RealType {
-	case TypeInt32, TypeInt64:
-		if a, err1 := b.NumberValue.Int64(); err1 == nil {
-			if v1, err2 := n.NumberValue.Float64(); err2 == nil {
-				t := float64(a)
-				switch n.Op {
-				case "<":
-					return t < v1
-				case "<=":
-					return t <= v1
-				case ">":
-					return t > v1
-				case ">=":
-					return t >= v1
+// clone returns a copy of the predicate sharing none of its atoms, so that it can be bound without modifying the
+// predicate given by the caller.
+func (p Predicate) clone() Predicate {
+	copied := make(Predicate, len(p))
+	for k, v := range p {
+		atoms := make([]Atom, len(v))
+		for i, atom := range v {
+			if atom.Args != nil {
+				args := make([]Predicate, len(atom.Args))
+				for j, arg := range atom.Args {
+					args[j] = arg.clone()
 				}
-			} else if v2, err3 := n.NumberValue.Int64(); err3 == nil {
-				switch n.Op {
-				case "<":
-					return a < v2
-				case "<=":
-					return a <= v2
-				case ">":
-					return a > v2
-				case ">=":
-					return a >= v2
+				atom.Args = args
+			}
+			atoms[i] = atom
+		}
+		copied[k] = atoms
+	}
+	return copied
+}
+
+// bind resolves the type of the value in each atom with the column it restricts, so that the atoms can be checked.
+// Columns that are not in the given schema are left untouched.
+func (p Predicate) bind(columns []ColumnSchema) error {
+	for k, v := range p {
+		if isLogicalOp(k) {
+			for _, atom := range v {
+				for _, arg := range atom.Args {
+					if err := arg.bind(columns); err != nil {
+						return err
+					}
 				}
 			}
+			continue
 		}
-	case TypeFloat, TypeDouble:
-		if a, err1 := b.NumberValue.Float64(); err1 == nil {
-			if v1, err2 := n.NumberValue.Float64(); err2 == nil {
-				switch n.Op {
-				case "<":
-					return a < v1
-				case "<=":
-					return a <= v1
-				case ">":
-					return a > v1
-				case ">=":
-					return a >= v1
+		for _, cs := range columns {
+			if cs.Name == k {
+				for i := range v {
+					if err := p[k][i].bind(cs.DataType); err != nil {
+						return err
+					}
 				}
-			} else if v2, err3 := n.NumberValue.Int64(); err3 == nil {
-				t := float64(v2)
-				switch n.Op {
-				case "<":
-					return a < t
-				case "<=":
-					return a <= t
-				case ">":
-					return a > t
-				case ">=":
-					return a >= t
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// bind resolves the type of the value in the atom with the type of the column it restricts.
+func (n *Atom) bind(dataType int) error {
+	n.Op = normalizeOp(n.Op)
+	switch n.Op {
+	case "==", "=", "!=", "<>", "<", "<=", ">", ">=":
+	case OpIsNull, OpIsNotNull:
+		if n.Val != nil {
+			return fmt.Errorf("operator %s takes no value", n.Op)
+		}
+		n.RealType = dataType
+		return nil
+	case OpIn, OpNotIn, OpBetween, OpNotBetween:
+		values, ok := n.Val.([]interface{})
+		if !ok || ((n.Op == OpBetween || n.Op == OpNotBetween) && len(values) != 2) {
+			return fmt.Errorf("operator %s takes a list of values", n.Op)
+		}
+		for _, value := range values {
+			if value == nil {
+				return errors.New("Operator Not Suitable For null")
+			}
+			element := Atom{Op: "=", Val: value}
+			if err := element.bind(dataType); err != nil {
+				return err
+			}
+		}
+		n.RealType = dataType
+		return nil
+	case OpLike, OpNotLike:
+		var ok bool
+		if n.StringValue, ok = n.Val.(string); !ok || dataType != TypeString {
+			return errors.New("TypeError")
+		}
+		n.RealType = dataType
+		return nil
+	default:
+		return fmt.Errorf("unknown operator %s", n.Op)
+	}
+	if n.Val == nil {
+		if OpIsEqualOrNotEqual(n.Op) {
+			n.RealType = dataType
+			return nil
+		} else {
+			return errors.New("Operator Not Suitable For null")
+		}
+	}
+	var ok bool
+	switch dataType {
+	case TypeInt32, TypeInt64, TypeFloat, TypeDouble:
+		if n.NumberValue, ok = n.Val.(json.Number); !ok {
+			// predicates built in Go rather than decoded from JSON carry Go numbers
+			if ok = CheckType(n.Val, dataType) || CheckType(n.Val, TypeDouble); ok {
+				n.filledWith(n.Val, TypeDouble)
+			}
+		}
+		if ok {
+			if _, err1 := n.NumberValue.Float64(); err1 != nil {
+				if _, err2 := n.NumberValue.Int64(); err2 != nil {
+					ok = false
 				}
 			}
 		}
 	case TypeBoolean:
-		if n.Op == "<=" || n.Op == ">=" {
-			return b.BoolValue == n.BoolValue
-		}
+		n.BoolValue, ok = n.Val.(bool)
 	case TypeString:
+		n.StringValue, ok = n.Val.(string)
+	}
+	if !ok {
+		return errors.New("TypeError")
+	}
+	n.RealType = dataType
+	return nil
+}
+
+// Check tells whether a value satisfies the atom, i.e., whether the atom evaluates to true, see eval.
+func (n *Atom) Check(value interface{}) bool {
+	return n.eval(value) == truthTrue
+}
+
+// eval evaluates the atom on a value of the column it restricts with the three-valued logic of SQL: a comparison with
+// null is unknown. As JSON has no way to spell IS NULL in a comparison, "= null" and "!= null" are shorthands for IS
+// NULL and IS NOT NULL. The atom must be bound, see Predicate.bind.
+func (n *Atom) eval(value interface{}) truth {
+	switch n.Op {
+	case OpIsNull:
+		return truthOf(value == nil)
+	case OpIsNotNull:
+		return truthOf(value != nil)
+	}
+	if n.Val == nil {
 		switch n.Op {
-		case "<":
-			return b.StringValue < n.StringValue
-		case "<=":
-			return b.StringValue <= n.StringValue
-		case ">":
-			return b.StringValue > n.StringValue
-		case ">=":
-			return b.StringValue >= n.StringValue
+		case "==", "=":
+			return truthOf(value == nil)
+		case "!=", "<>":
+			return truthOf(value != nil)
+		}
+		return truthUnknown
+	}
+	if value == nil {
+		return truthUnknown
+	}
+
+	switch n.Op {
+	case OpIn, OpNotIn:
+		values, _ := n.Val.([]interface{})
+		for _, v := range values {
+			if c, ok := compareValues(value, v); ok && c == 0 {
+				return truthOf(n.Op == OpIn)
+			}
+		}
+		return truthOf(n.Op == OpNotIn)
+	case OpBetween, OpNotBetween:
+		bounds, _ := n.Val.([]interface{})
+		if len(bounds) != 2 {
+			return truthUnknown
+		}
+		lower, ok1 := compareValues(value, bounds[0])
+		upper, ok2 := compareValues(value, bounds[1])
+		if !ok1 || !ok2 {
+			return truthUnknown
+		}
+		return truthOf((lower >= 0 && upper <= 0) == (n.Op == OpBetween))
+	case OpLike, OpNotLike:
+		s, ok1 := value.(string)
+		pattern, ok2 := n.Val.(string)
+		if !ok1 || !ok2 {
+			return truthUnknown
 		}
+		return truthOf(matchLike(s, pattern) == (n.Op == OpLike))
+	}
+	c, ok := compareValues(value, n.Val)
+	if !ok {
+		return truthUnknown
+	}
+	return truthOf(compareResult(c, n.Op))
+}
+
+// compareValues is the comparison kernel of predicates. It compares two non-null values with a three-way result:
+// numbers are compared by their values regardless of their Go types, e.g., int32(3) equals json.Number("3"), exactly if
+// both are integers and at the precision of float32 if either is a float32; false is less than true; strings are
+// compared bytewise. It returns false if the values are not comparable, e.g., a number and a string.
+func compareValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		return compareBool(x, y), ok
+	}
+	x, ok1 := toFloat64(a)
+	y, ok2 := toFloat64(b)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	// integers beyond the precision of float64 are compared exactly
+	if isInteger(a) && isInteger(b) {
+		i, _ := toInt64(a)
+		j, _ := toInt64(b)
+		return compareInt64(i, j), true
+	}
+	_, float32A := a.(float32)
+	_, float32B := b.(float32)
+	if float32A || float32B {
+		return compareFloat64(float64(float32(x)), float64(float32(y))), true
+	}
+	return compareFloat64(x, y), true
+}
+
+// compareResult tells whether the result of a three-way comparison satisfies a comparison operator.
+func compareResult(c int, op string) bool {
+	switch op {
+	case "==", "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
 	}
 	return false
 }
 
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareBool(a, b bool) int {
+	if a == b {
+		return 0
+	} else if !a {
+		return -1
+	}
+	return 1
+}
+
 func CheckType(value interface{}, typeName int) bool {
 	if value == nil {
 		return true
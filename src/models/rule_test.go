@@ -0,0 +1,178 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// matrixOperator is an operator applied to a value v in TestAtomMatrix, or to the list of values built from v, with the
+// results for a value less than, equal to and greater than v, and for null.
+type matrixOperator struct {
+	op       string
+	list     func(v interface{}) interface{}
+	expected [4]truth
+}
+
+func TestAtomMatrix(t *testing.T) {
+	const F, T, U = truthFalse, truthTrue, truthUnknown
+	operators := []matrixOperator{
+		{"=", nil, [4]truth{F, T, F, U}},
+		{"==", nil, [4]truth{F, T, F, U}},
+		{"!=", nil, [4]truth{T, F, T, U}},
+		{"<>", nil, [4]truth{T, F, T, U}},
+		{"<", nil, [4]truth{T, F, F, U}},
+		{"<=", nil, [4]truth{T, T, F, U}},
+		{">", nil, [4]truth{F, F, T, U}},
+		{">=", nil, [4]truth{F, T, T, U}},
+		{OpIn, func(v interface{}) interface{} { return []interface{}{v} }, [4]truth{F, T, F, U}},
+		{OpNotIn, func(v interface{}) interface{} { return []interface{}{v} }, [4]truth{T, F, T, U}},
+		{OpBetween, func(v interface{}) interface{} { return []interface{}{v, v} }, [4]truth{F, T, F, U}},
+		{OpNotBetween, func(v interface{}) interface{} { return []interface{}{v, v} }, [4]truth{T, F, T, U}},
+		{OpIsNull, func(interface{}) interface{} { return nil }, [4]truth{F, F, F, T}},
+		{OpIsNotNull, func(interface{}) interface{} { return nil }, [4]truth{T, T, T, F}},
+	}
+	// the values of each datatype in ascending order, in their canonical Go types, and the ways to write them in atoms
+	dataTypes := []struct {
+		dataType int
+		values   []interface{}
+		literals func(v interface{}) []interface{}
+	}{
+		{TypeInt32, []interface{}{int32(-3), int32(0), int32(7)}, func(v interface{}) []interface{} {
+			i := v.(int32)
+			s := strconv.Itoa(int(i))
+			return []interface{}{i, int(i), int64(i), float64(i), float32(i), json.Number(s),
+				json.Number(s + ".0")}
+		}},
+		{TypeInt64, []interface{}{int64(-1e18), int64(1 << 60), int64(1<<60 + 1)}, func(v interface{}) []interface{} {
+			i := v.(int64)
+			return []interface{}{i, int(i), json.Number(strconv.FormatInt(i, 10))}
+		}},
+		{TypeFloat, []interface{}{float32(-1.5), float32(3.6), float32(3.7)}, func(v interface{}) []interface{} {
+			x := v.(float32)
+			return []interface{}{x, float64(x), json.Number(strconv.FormatFloat(float64(x), 'f', -1, 32))}
+		}},
+		{TypeDouble, []interface{}{-2.0, 0.1, 1e300}, func(v interface{}) []interface{} {
+			x := v.(float64)
+			return []interface{}{x, json.Number(strconv.FormatFloat(x, 'g', -1, 64))}
+		}},
+		{TypeBoolean, []interface{}{false, true}, func(v interface{}) []interface{} { return []interface{}{v} }},
+		{TypeString, []interface{}{"", "Bob", "bob"}, func(v interface{}) []interface{} { return []interface{}{v} }},
+	}
+
+	for _, dt := range dataTypes {
+		schema := &TableSchema{TableName: "matrix", ColumnSchemas: []ColumnSchema{{Name: "c", DataType: dt.dataType}}}
+		store := NewColumnarRowStore(schema)
+		rows := make([]Row, 0, len(dt.values)+1)
+		for _, value := range append(append([]interface{}(nil), dt.values...), nil) {
+			row := Row{value}
+			store.insert(&row)
+			rows = append(rows, row)
+		}
+		columnar := NewTable(schema, store)
+
+		operatorsOfType := operators
+		if dt.dataType == TypeString {
+			operatorsOfType = append(append([]matrixOperator(nil), operators...),
+				matrixOperator{OpLike, nil, [4]truth{F, T, F, U}}, matrixOperator{OpNotLike, nil, [4]truth{T, F, T, U}})
+		}
+
+		for j, target := range dt.values {
+			for _, literal := range dt.literals(target) {
+				for _, o := range operatorsOfType {
+					val := literal
+					if o.list != nil {
+						val = o.list(literal)
+					}
+					atom := Atom{Op: o.op, Val: val}
+					if err := atom.bind(dt.dataType); err != nil {
+						t.Fatalf("%s: cannot bind %s %v (%T): %v", DataTypeName(dt.dataType), o.op, val, literal, err)
+					}
+					for i, row := range rows {
+						var expected truth
+						switch {
+						case row[0] == nil:
+							expected = o.expected[3]
+						case i < j:
+							expected = o.expected[0]
+						case i == j:
+							expected = o.expected[1]
+						default:
+							expected = o.expected[2]
+						}
+						if actual := atom.eval(row[0]); actual != expected {
+							t.Errorf("%s: %v %s %v (%T) should be %v, actual %v", DataTypeName(dt.dataType), row[0],
+								o.op, val, literal, expected, actual)
+						}
+						if atom.Check(row[0]) != (expected == truthTrue) {
+							t.Errorf("%s: Check(%v) %s %v (%T) should be %v", DataTypeName(dt.dataType), row[0], o.op,
+								val, literal, expected == truthTrue)
+						}
+					}
+
+					// the vectors of the columnar store agree with the atoms
+					selected, err := columnar.Select(Predicate{"c": {atom}})
+					if err != nil {
+						t.Fatalf("cannot select: %v", err)
+					}
+					expected := make([]Row, 0)
+					for _, row := range rows {
+						if atom.Check(row[0]) {
+							expected = append(expected, row)
+						}
+					}
+					if !compareRows(selected, expected, []int{0}) {
+						t.Errorf("%s: columnar %s %v (%T): expected %v, actual %v", DataTypeName(dt.dataType), o.op,
+							val, literal, expected, selected)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	const F, T, U = truthFalse, truthTrue, truthUnknown
+	columns := []ColumnSchema{{Name: "grade", DataType: TypeDouble}, {Name: "name", DataType: TypeString}}
+	greater := Predicate{"grade": {{Op: ">", Val: 3.0}}}
+	named := Predicate{"name": {{Op: "=", Val: "Bob"}}}
+	other := Predicate{"age": {{Op: ">", Val: 20}}}
+	cases := []struct {
+		predicate Predicate
+		row       Row
+		expected  truth
+	}{
+		{greater, Row{nil, "Bob"}, U},
+		{Not(greater), Row{nil, "Bob"}, U},
+		{Or(greater, named), Row{nil, "Bob"}, T},
+		{Or(greater, named), Row{nil, "Al"}, U},
+		{And(greater, named), Row{nil, "Al"}, F},
+		{And(greater, named), Row{4.0, "Bob"}, T},
+		{Not(And(greater, named)), Row{nil, "Bob"}, U},
+		{Not(Or(greater, named)), Row{2.0, "Al"}, T},
+		{other, Row{4.0, "Bob"}, truthUndecided},
+		{Not(other), Row{4.0, "Bob"}, truthUndecided},
+		{And(other, greater), Row{2.0, "Bob"}, F},
+		{And(other, greater), Row{nil, "Bob"}, F | U},
+		{Or(other, named), Row{2.0, "Bob"}, T},
+	}
+	for i, c := range cases {
+		if err := c.predicate.bind(columns); err != nil {
+			t.Fatalf("case %d: cannot bind: %v", i, err)
+		}
+		if actual := c.predicate.eval(columns, c.row); actual != c.expected {
+			t.Errorf("case %d: expected %v, actual %v", i, c.expected, actual)
+		}
+		if matchRow(columns, c.row, c.predicate) != (c.expected&truthTrue != 0) {
+			t.Errorf("case %d: the row should match only if the predicate may be true", i)
+		}
+	}
+
+	// comparisons with null are rejected, except the shorthands of IS [NOT] NULL
+	for _, op := range []string{"<", "<=", ">", ">=", OpIn, OpBetween, OpLike} {
+		atom := Atom{Op: op, Val: nil}
+		if err := atom.bind(TypeString); err == nil {
+			t.Errorf("%s null should be rejected", op)
+		}
+	}
+}
@@ -0,0 +1,704 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PartitionReport is the result of validating the partition rules of a table, see ValidatePartitionRules.
+type PartitionReport struct {
+	// the problems that make the rules inconsistent, a table is not created if there is any
+	Errors []string
+	// the problems that do not prevent the table from being created, e.g., the ranges of values held by no fragment
+	// unless they are rejected, as the rows in them are simply rejected when they are written
+	Warnings []string
+}
+
+// OK tells whether the rules are consistent.
+func (r *PartitionReport) OK() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *PartitionReport) String() string {
+	lines := make([]string, 0, len(r.Errors)+len(r.Warnings))
+	for _, e := range r.Errors {
+		lines = append(lines, "error: "+e)
+	}
+	for _, w := range r.Warnings {
+		lines = append(lines, "warning: "+w)
+	}
+	return strings.Join(lines, "; ")
+}
+
+func (r *PartitionReport) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *PartitionReport) warnf(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// DecodeRules decodes the partition rules of a table from JSON, keeping the numbers as json.Number.
+func DecodeRules(data []byte) (map[string]Rule, error) {
+	rules := make(map[string]Rule)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("cannot decode the partition rules: %v", err)
+	}
+	return rules, nil
+}
+
+// ValidateRules decodes and validates the partition rules of a table without creating it, and replies the report.
+// params: schema TableSchema, rules []byte
+func (c *Cluster) ValidateRules(params []interface{}, reply *PartitionReport) {
+	schema := params[0].(TableSchema)
+	rules, err := DecodeRules(params[1].([]byte))
+	if err != nil {
+		*reply = PartitionReport{Errors: []string{err.Error()}}
+		return
+	}
+	*reply = *validatePartitionRules(&schema, rules, c.hasNode, c.RejectGaps)
+}
+
+// ValidatePartitionRules checks the partition rules of a table whose schema is given without the id column, for a
+// cluster of nodeNum nodes. It reports as errors:
+//   - node lists that are empty or name nodes that are not in the cluster;
+//   - unknown columns, unknown operators and values that do not fit their columns;
+//   - columns held by no fragment;
+//   - fragments holding the same column for some rows, i.e., rules whose predicates differ but overlap. Rules with the
+//     same predicate are the vertical fragments of the same rows, and may share columns.
+//
+// and reports the values of the partitioning columns that no fragment holds, as errors if rejectGaps is true and as
+// warnings otherwise, the rows holding them being rejected when they are written. Overlaps and gaps are found
+// by reducing the atoms on each column to an interval, which is exact for conjunctions of comparisons, BETWEEN, NOT IN
+// and IS [NOT] NULL. The other predicates, e.g., with OR or LIKE, are reduced to larger intervals, so that their
+// overlaps are only warnings and their gaps are not analyzed. Gaps are only analyzed for the columns whose fragments
+// are all restricted by the same single column.
+func ValidatePartitionRules(schema *TableSchema, rules map[string]Rule, nodeNum int, rejectGaps bool) *PartitionReport {
+	return validatePartitionRules(schema, rules, func(i int) bool { return i < nodeNum }, rejectGaps)
+}
+
+// validatePartitionRules validates the rules like ValidatePartitionRules, for a cluster holding the nodes whose numbers
+// satisfy isNode, e.g., after some nodes are decommissioned.
+func validatePartitionRules(schema *TableSchema, rules map[string]Rule, isNode func(int) bool,
+	rejectGaps bool) *PartitionReport {
+	report := &PartitionReport{}
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	regions := make(map[string]map[string]*valueRange, len(rules))
+	// the rules whose regions are larger than their predicates
+	approximate := make(map[string]bool)
+	for _, key := range keys {
+		rule := rules[key]
+		validateNodeList(report, key, isNode)
+		if len(rule.Column) == 0 {
+			report.errorf("rule %q holds no column", key)
+		}
+		held := make(map[string]bool)
+		for _, column := range rule.Column {
+			if schema.ColumnIndex(column) < 0 {
+				report.errorf("rule %q holds unknown column %s", key, column)
+			} else if held[column] {
+				report.errorf("rule %q holds column %s twice", key, column)
+			}
+			held[column] = true
+		}
+		if _, err := ParseStorage(rule.Storage); err != nil {
+			report.errorf("rule %q: %v", key, err)
+		}
+		if rule.Default {
+			if len(rule.Predicate) > 0 {
+				report.warnf("the predicate of default rule %q is ignored", key)
+			}
+			continue
+		}
+		region, exact, ok := ruleRegion(report, key, schema, rule.Predicate)
+		if ok {
+			regions[key] = region
+			approximate[key] = !exact
+		}
+	}
+
+	// vertical coverage and overlaps
+	for _, cs := range schema.ColumnSchemas {
+		holders := make([]string, 0)
+		for _, key := range keys {
+			for _, column := range rules[key].Column {
+				if column == cs.Name {
+					holders = append(holders, key)
+					break
+				}
+			}
+		}
+		if len(holders) == 0 {
+			report.errorf("column %s is held by no rule", cs.Name)
+		}
+	}
+	for i, a := range keys {
+		for _, b := range keys[i+1:] {
+			shared := sharedColumns(rules[a].Column, rules[b].Column)
+			if len(shared) == 0 {
+				continue
+			}
+			// vertical fragments of the same rows may share columns
+			if rules[a].Default != rules[b].Default || rules[a].Default ||
+				samePredicate(rules[a].Predicate, rules[b].Predicate) {
+				continue
+			}
+			switch {
+			case !rules[a].Default && !regionsOverlap(schema, regions[a], regions[b]):
+			case approximate[a] || approximate[b]:
+				report.warnf("rules %q and %q may overlap on columns [%s]", a, b, strings.Join(shared, " "))
+			default:
+				report.errorf("rules %q and %q overlap on columns [%s]", a, b, strings.Join(shared, " "))
+			}
+		}
+	}
+
+	validateCoverage(report, schema, rules, keys, regions, approximate, rejectGaps)
+	return report
+}
+
+func validateNodeList(report *PartitionReport, key string, isNode func(int) bool) {
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(key, "|") {
+		if part == "" {
+			report.errorf("rule %q has an empty node in its node list", key)
+			continue
+		}
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || !isNode(i) {
+			report.errorf("rule %q refers to unknown node %s", key, part)
+			continue
+		}
+		if seen[i] {
+			report.errorf("rule %q lists node %d twice", key, i)
+		}
+		seen[i] = true
+	}
+}
+
+func sharedColumns(a, b []string) []string {
+	shared := make([]string, 0)
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				shared = append(shared, x)
+				break
+			}
+		}
+	}
+	return shared
+}
+
+// ruleRegion reduces the atoms of a predicate to a range of values for each column it restricts, and tells whether the
+// ranges are exactly the rows satisfying the predicate.
+func ruleRegion(report *PartitionReport, key string, schema *TableSchema, predicate Predicate) (map[string]*valueRange,
+	bool, bool) {
+	region := make(map[string]*valueRange)
+	exact, ok := true, true
+	for column, atoms := range predicate {
+		if isLogicalOp(column) {
+			// the operands restrict the rows in ways intervals cannot describe, only their validity is checked
+			exact = false
+			logical := Predicate{column: atoms}
+			for _, name := range logical.columns() {
+				if schema.ColumnIndex(name) < 0 {
+					report.errorf("the predicate of rule %q restricts unknown column %s", key, name)
+					ok = false
+				}
+			}
+			if err := logical.bind(schema.ColumnSchemas); err != nil {
+				report.errorf("the predicate of rule %q: %v", key, err)
+				ok = false
+			}
+			continue
+		}
+		position := schema.ColumnIndex(column)
+		if position < 0 {
+			report.errorf("the predicate of rule %q restricts unknown column %s", key, column)
+			ok = false
+			continue
+		}
+		dataType := schema.ColumnSchemas[position].DataType
+		r := fullRange(dataType)
+		for _, atom := range atoms {
+			if approximated, err := r.apply(atom); err != nil {
+				report.errorf("the predicate of rule %q on %s: %v", key, column, err)
+				ok = false
+			} else if approximated {
+				exact = false
+			}
+		}
+		region[column] = r
+	}
+	return region, exact, ok
+}
+
+func regionsOverlap(schema *TableSchema, a, b map[string]*valueRange) bool {
+	if a == nil || b == nil {
+		// a rule with an invalid predicate is already reported
+		return false
+	}
+	for _, cs := range schema.ColumnSchemas {
+		ra, ok := a[cs.Name]
+		if !ok {
+			ra = fullRange(cs.DataType)
+		}
+		rb, ok := b[cs.Name]
+		if !ok {
+			rb = fullRange(cs.DataType)
+		}
+		if !ra.intersect(rb).satisfiable() {
+			return false
+		}
+	}
+	return true
+}
+
+// validateCoverage reports, for each group of columns held by the same rules, the values of the partitioning column
+// that none of the rules holds, as errors if rejectGaps is true.
+func validateCoverage(report *PartitionReport, schema *TableSchema, rules map[string]Rule, keys []string,
+	regions map[string]map[string]*valueRange, approximate map[string]bool, rejectGaps bool) {
+	reportGap := report.warnf
+	if rejectGaps {
+		reportGap = report.errorf
+	}
+	groups := make(map[string][]string)
+	order := make([]string, 0)
+	for _, cs := range schema.ColumnSchemas {
+		holders := make([]string, 0)
+		for _, key := range keys {
+			for _, column := range rules[key].Column {
+				if column == cs.Name {
+					holders = append(holders, key)
+					break
+				}
+			}
+		}
+		group := strings.Join(holders, ",")
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+		groups[group] = append(groups[group], cs.Name)
+	}
+
+	for _, group := range order {
+		columns := groups[group]
+		if group == "" {
+			continue
+		}
+		holders := strings.Split(group, ",")
+		partitioning := ""
+		ranges := make([]*valueRange, 0, len(holders))
+		covered, analyzable, exact := false, true, true
+		for _, key := range holders {
+			region, ok := regions[key]
+			// a rule with an invalid predicate is already reported
+			if rules[key].Default || !ok || (len(region) == 0 && !approximate[key]) {
+				covered = true
+				break
+			}
+			exact = exact && !approximate[key]
+			for column, r := range region {
+				if len(region) > 1 || (partitioning != "" && partitioning != column) {
+					analyzable = false
+				}
+				partitioning = column
+				ranges = append(ranges, r)
+			}
+		}
+		if covered {
+			continue
+		}
+		if !analyzable {
+			report.warnf("the coverage of columns [%s] is not analyzed as their rules restrict several columns",
+				strings.Join(columns, " "))
+			continue
+		}
+		if !exact {
+			report.warnf("the coverage of columns [%s] is not analyzed as their rules are not intervals",
+				strings.Join(columns, " "))
+			continue
+		}
+		dataType := schema.ColumnSchemas[schema.ColumnIndex(partitioning)].DataType
+		for _, gap := range findGaps(ranges, dataType) {
+			reportGap("columns [%s] of the rows with %s are held by no fragment", strings.Join(columns, " "),
+				gap.format(partitioning, dataType))
+		}
+	}
+}
+
+// valueRange is the set of values of a column satisfying some atoms: an interval of non-null values minus some points,
+// and possibly null. The values are float64 for numbers and booleans (false is 0 and true is 1), or strings.
+// For integral columns the bounds are kept inclusive and integral, see normalize.
+type valueRange struct {
+	dataType     int
+	lo, hi       interface{} // nil if unbounded
+	loInc, hiInc bool
+	excluded     []interface{}
+	// whether null satisfies the atoms, i.e., they are all IS NULL or IS NOT NULL, see Atom.eval
+	nulls bool
+	// whether non-null values may satisfy the atoms
+	values bool
+}
+
+func fullRange(dataType int) *valueRange {
+	r := &valueRange{dataType: dataType, nulls: true, values: true}
+	if dataType == TypeBoolean {
+		r.lo, r.hi, r.loInc, r.hiInc = 0.0, 1.0, true, true
+	}
+	return r
+}
+
+func isIntegral(dataType int) bool {
+	return dataType == TypeInt32 || dataType == TypeInt64 || dataType == TypeBoolean
+}
+
+// rangeValue converts the value of an atom into the representation used by valueRange.
+func rangeValue(value interface{}, dataType int) (interface{}, error) {
+	switch dataType {
+	case TypeInt32, TypeInt64, TypeFloat, TypeDouble:
+		if f, ok := toFloat64(value); ok {
+			return f, nil
+		}
+	case TypeBoolean:
+		if b, ok := value.(bool); ok {
+			if b {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		}
+	case TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%v (%T) does not conform to %s", value, value, DataTypeName(dataType))
+}
+
+// apply restricts the range with an atom, and tells whether the range is left larger than the values satisfying it,
+// e.g., IN keeps all the values between the smallest and the largest of its list.
+func (r *valueRange) apply(atom Atom) (bool, error) {
+	op := normalizeOp(atom.Op)
+	switch op {
+	case OpIsNull:
+		r.values = false
+		return false, nil
+	case OpIsNotNull:
+		r.nulls = false
+		return false, nil
+	case OpIn, OpNotIn, OpBetween, OpNotBetween:
+		list, ok := atom.Val.([]interface{})
+		if !ok || len(list) == 0 || ((op == OpBetween || op == OpNotBetween) && len(list) != 2) {
+			return false, fmt.Errorf("operator %s takes a list of values", op)
+		}
+		values := make([]interface{}, len(list))
+		for i := range list {
+			v, err := rangeValue(list[i], r.dataType)
+			if err != nil {
+				return false, err
+			}
+			values[i] = v
+		}
+		r.nulls = false
+		switch op {
+		case OpNotIn:
+			r.excluded = append(r.excluded, values...)
+			return false, nil
+		case OpNotBetween:
+			return true, nil
+		}
+		sort.Slice(values, func(i, j int) bool { return compareKeys(values[i], values[j]) < 0 })
+		r.setLo(values[0], true)
+		r.setHi(values[len(values)-1], true)
+		r.normalize()
+		return op == OpIn && compareKeys(values[0], values[len(values)-1]) != 0, nil
+	case OpLike, OpNotLike:
+		if _, ok := atom.Val.(string); !ok || r.dataType != TypeString {
+			return false, fmt.Errorf("operator %s takes a pattern for a string", op)
+		}
+		r.nulls = false
+		return true, nil
+	}
+	if atom.Val == nil {
+		switch op {
+		case "=", "==":
+			r.values = false
+		case "!=", "<>":
+			r.nulls = false
+		default:
+			return false, fmt.Errorf("operator %s is not suitable for null", atom.Op)
+		}
+		return false, nil
+	}
+	v, err := rangeValue(atom.Val, r.dataType)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "!=", "<>":
+		r.excluded = append(r.excluded, v)
+	case "=", "==":
+		r.setLo(v, true)
+		r.setHi(v, true)
+	case "<":
+		r.setHi(v, false)
+	case "<=":
+		r.setHi(v, true)
+	case ">":
+		r.setLo(v, false)
+	case ">=":
+		r.setLo(v, true)
+	default:
+		return false, fmt.Errorf("unknown operator %s", atom.Op)
+	}
+	r.nulls = false
+	r.normalize()
+	return false, nil
+}
+
+func (r *valueRange) setLo(v interface{}, inclusive bool) {
+	if r.lo == nil || compareKeys(v, r.lo) > 0 || (compareKeys(v, r.lo) == 0 && !inclusive) {
+		r.lo, r.loInc = v, inclusive
+	}
+}
+
+func (r *valueRange) setHi(v interface{}, inclusive bool) {
+	if r.hi == nil || compareKeys(v, r.hi) < 0 || (compareKeys(v, r.hi) == 0 && !inclusive) {
+		r.hi, r.hiInc = v, inclusive
+	}
+}
+
+// normalize makes the bounds of an integral range inclusive integers, e.g., (1.5, 4) becomes [2, 3].
+func (r *valueRange) normalize() {
+	if !isIntegral(r.dataType) {
+		return
+	}
+	if lo, ok := r.lo.(float64); ok {
+		if r.loInc {
+			r.lo = math.Ceil(lo)
+		} else {
+			r.lo = math.Floor(lo) + 1
+		}
+		r.loInc = true
+	}
+	if hi, ok := r.hi.(float64); ok {
+		if r.hiInc {
+			r.hi = math.Floor(hi)
+		} else {
+			r.hi = math.Ceil(hi) - 1
+		}
+		r.hiInc = true
+	}
+}
+
+func (r *valueRange) contains(v interface{}) bool {
+	if r.lo != nil {
+		if c := compareKeys(v, r.lo); c < 0 || (c == 0 && !r.loInc) {
+			return false
+		}
+	}
+	if r.hi != nil {
+		if c := compareKeys(v, r.hi); c > 0 || (c == 0 && !r.hiInc) {
+			return false
+		}
+	}
+	for _, e := range r.excluded {
+		if compareKeys(v, e) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hasValues tells whether some non-null value is in the range.
+func (r *valueRange) hasValues() bool {
+	if !r.values {
+		return false
+	}
+	if r.lo == nil || r.hi == nil {
+		return true
+	}
+	c := compareKeys(r.lo, r.hi)
+	if c > 0 || (c == 0 && !(r.loInc && r.hiInc)) {
+		return false
+	}
+	if c == 0 {
+		return r.contains(r.lo)
+	}
+	if isIntegral(r.dataType) {
+		// the integers in the range may all be excluded
+		if r.hi.(float64)-r.lo.(float64) < float64(len(r.excluded))+1 {
+			for v := r.lo.(float64); v <= r.hi.(float64); v++ {
+				if r.contains(v) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (r *valueRange) satisfiable() bool {
+	return r.nulls || r.hasValues()
+}
+
+func (r *valueRange) intersect(another *valueRange) *valueRange {
+	i := &valueRange{dataType: r.dataType, lo: r.lo, hi: r.hi, loInc: r.loInc, hiInc: r.hiInc,
+		nulls: r.nulls && another.nulls, values: r.values && another.values}
+	i.excluded = append(append([]interface{}(nil), r.excluded...), another.excluded...)
+	if another.lo != nil {
+		i.setLo(another.lo, another.loInc)
+	}
+	if another.hi != nil {
+		i.setHi(another.hi, another.hiInc)
+	}
+	return i
+}
+
+// gap is a range of values held by no fragment, or null.
+type gap struct {
+	null         bool
+	lo, hi       interface{}
+	loInc, hiInc bool
+}
+
+// findGaps returns the values of a column that are in none of the ranges.
+func findGaps(ranges []*valueRange, dataType int) []gap {
+	gaps := make([]gap, 0)
+	nulls := false
+	sorted := make([]*valueRange, 0, len(ranges))
+	for _, r := range ranges {
+		nulls = nulls || r.nulls
+		if r.hasValues() {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].lo == nil || sorted[j].lo == nil {
+			return sorted[i].lo == nil && sorted[j].lo != nil
+		}
+		c := compareKeys(sorted[i].lo, sorted[j].lo)
+		return c < 0 || (c == 0 && sorted[i].loInc && !sorted[j].loInc)
+	})
+
+	integral := isIntegral(dataType)
+	// the values up to cur are covered, cur is nil at the beginning
+	var cur interface{}
+	curInc, started, unbounded := false, false, false
+	for _, r := range sorted {
+		if r.lo != nil {
+			switch {
+			case !started && integral:
+				gaps = append(gaps, gap{hi: r.lo.(float64) - 1, hiInc: true})
+			case !started:
+				gaps = append(gaps, gap{hi: r.lo, hiInc: !r.loInc})
+			case integral && r.lo.(float64) > cur.(float64)+1:
+				gaps = append(gaps, gap{lo: cur.(float64) + 1, loInc: true, hi: r.lo.(float64) - 1, hiInc: true})
+			case !integral && compareKeys(r.lo, cur) > 0:
+				gaps = append(gaps, gap{lo: cur, loInc: !curInc, hi: r.lo, hiInc: !r.loInc})
+			case !integral && compareKeys(r.lo, cur) == 0 && !curInc && !r.loInc:
+				gaps = append(gaps, gap{lo: cur, loInc: true, hi: cur, hiInc: true})
+			}
+		}
+		started = true
+		if r.hi == nil {
+			unbounded = true
+			break
+		}
+		if cur == nil || compareKeys(r.hi, cur) > 0 || (compareKeys(r.hi, cur) == 0 && r.hiInc) {
+			cur, curInc = r.hi, r.hiInc
+		}
+	}
+	switch {
+	case !started:
+		gaps = append(gaps, gap{})
+	case !unbounded && integral:
+		gaps = append(gaps, gap{lo: cur.(float64) + 1, loInc: true})
+	case !unbounded:
+		gaps = append(gaps, gap{lo: cur, loInc: !curInc})
+	}
+	if dataType == TypeBoolean {
+		// the domain of booleans is [0, 1]
+		bounded := make([]gap, 0, len(gaps))
+		for _, g := range gaps {
+			if g.lo == nil {
+				g.lo, g.loInc = 0.0, true
+			}
+			if g.hi == nil {
+				g.hi, g.hiInc = 1.0, true
+			}
+			if compareKeys(g.lo, g.hi) <= 0 {
+				bounded = append(bounded, g)
+			}
+		}
+		gaps = bounded
+	}
+
+	// the points excluded from a range may be held by another one
+	for _, r := range sorted {
+		for _, e := range r.excluded {
+			held := false
+			for _, another := range sorted {
+				held = held || another.contains(e)
+			}
+			if !held && (r.lo == nil || compareKeys(e, r.lo) >= 0) && (r.hi == nil || compareKeys(e, r.hi) <= 0) {
+				gaps = append(gaps, gap{lo: e, loInc: true, hi: e, hiInc: true})
+			}
+		}
+	}
+	if !nulls {
+		gaps = append(gaps, gap{null: true})
+	}
+	return gaps
+}
+
+func formatRangeValue(v interface{}, dataType int) string {
+	switch dataType {
+	case TypeBoolean:
+		return strconv.FormatBool(v.(float64) == 1)
+	case TypeString:
+		return strconv.Quote(v.(string))
+	}
+	return strconv.FormatFloat(v.(float64), 'f', -1, 64)
+}
+
+func (g gap) format(column string, dataType int) string {
+	if g.null {
+		return column + " null"
+	}
+	if g.lo == nil && g.hi == nil {
+		return "any " + column
+	}
+	if g.lo != nil && g.hi != nil && compareKeys(g.lo, g.hi) == 0 {
+		return column + " = " + formatRangeValue(g.lo, dataType)
+	}
+	parts := make([]string, 0, 2)
+	if g.lo != nil {
+		op := " > "
+		if g.loInc {
+			op = " >= "
+		}
+		parts = append(parts, column+op+formatRangeValue(g.lo, dataType))
+	}
+	if g.hi != nil {
+		op := " < "
+		if g.hiInc {
+			op = " <= "
+		}
+		parts = append(parts, column+op+formatRangeValue(g.hi, dataType))
+	}
+	return strings.Join(parts, " and ")
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"../labrpc"
+)
+
+func validateRules(t *testing.T, rules string, rejectGaps bool) *PartitionReport {
+	schema := &TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{
+		{Name: "sid", DataType: TypeInt32},
+		{Name: "name", DataType: TypeString},
+		{Name: "grade", DataType: TypeDouble},
+	}}
+	decoded, err := DecodeRules([]byte(rules))
+	if err != nil {
+		t.Fatalf("cannot decode %s: %v", rules, err)
+	}
+	return ValidatePartitionRules(schema, decoded, 3, rejectGaps)
+}
+
+func expectReport(t *testing.T, report *PartitionReport, errors []string, warnings []string) {
+	if len(report.Errors) != len(errors) || len(report.Warnings) != len(warnings) {
+		t.Errorf("expected %d errors and %d warnings, actual %v", len(errors), len(warnings), report)
+		return
+	}
+	for i, e := range errors {
+		if !strings.Contains(report.Errors[i], e) {
+			t.Errorf("expected error %q, actual %q", e, report.Errors[i])
+		}
+	}
+	for i, w := range warnings {
+		if !strings.Contains(report.Warnings[i], w) {
+			t.Errorf("expected warning %q, actual %q", w, report.Warnings[i])
+		}
+	}
+}
+
+func TestValidateHorizontalRules(t *testing.T) {
+	// integers are adjacent, sid <= 9 and sid >= 10 leave no gap
+	rules := `{
+		"0": {"predicate": {"sid": [{"op": ">=", "val": 0}, {"op": "<=", "val": 9}]},
+			"column": ["sid", "name", "grade"]},
+		"1": {"predicate": {"sid": [{"op": ">=", "val": 10}, {"op": "<", "val": 20}]},
+			"column": ["sid", "name", "grade"]},
+		"2": {"predicate": {"sid": [{"op": ">", "val": 30}, {"op": "!=", "val": 40}]},
+			"column": ["sid", "name", "grade"]}
+	}`
+	report := validateRules(t, rules, false)
+	expectReport(t, report, nil, []string{"sid <= -1", "sid >= 20 and sid <= 30", "sid = 40", "sid null"})
+	// the gaps are errors if they are rejected
+	report = validateRules(t, rules, true)
+	expectReport(t, report, []string{"sid <= -1", "sid >= 20 and sid <= 30", "sid = 40", "sid null"}, nil)
+
+	// doubles are not, grade < 2 and grade > 2 leave 2
+	report = validateRules(t, `{
+		"0": {"predicate": {"grade": [{"op": "<", "val": 2}]}, "column": ["sid", "name", "grade"]},
+		"1": {"predicate": {"grade": [{"op": ">", "val": 2}]}, "column": ["sid", "name", "grade"]},
+		"2": {"predicate": {"grade": [{"op": "=", "val": null}]}, "column": ["sid", "name", "grade"]}
+	}`, false)
+	expectReport(t, report, nil, []string{"grade = 2"})
+
+	report = validateRules(t, `{
+		"0": {"predicate": {"grade": [{"op": "<=", "val": 2}]}, "column": ["sid", "name", "grade"]},
+		"1": {"predicate": {"grade": [{"op": ">=", "val": 2}]}, "column": ["sid", "name", "grade"]}
+	}`, false)
+	expectReport(t, report, []string{`rules "0" and "1" overlap on columns [sid name grade]`}, []string{"grade null"})
+
+	// rules restricting different columns may overlap, x = 1 and x != 1 may not
+	report = validateRules(t, `{
+		"0": {"predicate": {"sid": [{"op": "=", "val": 1}]}, "column": ["sid", "name", "grade"]},
+		"1": {"predicate": {"sid": [{"op": "!=", "val": 1}], "grade": [{"op": ">", "val": 3}]},
+			"column": ["sid", "name", "grade"]},
+		"2": {"predicate": {"grade": [{"op": "<", "val": 4}]}, "column": ["sid", "name", "grade"]}
+	}`, false)
+	expectReport(t, report, []string{`rules "0" and "2" overlap`, `rules "1" and "2" overlap`},
+		[]string{"not analyzed"})
+
+	// a default rule covers the gaps
+	report = validateRules(t, `{
+		"0": {"predicate": {"name": [{"op": ">=", "val": "m"}]}, "column": ["sid", "name", "grade"]},
+		"1": {"default": true, "column": ["sid", "name", "grade"]}
+	}`, false)
+	expectReport(t, report, nil, nil)
+}
+
+func TestValidateVerticalRules(t *testing.T) {
+	report := validateRules(t, `{
+		"0|1": {"predicate": {"sid": [{"op": ">=", "val": 0}]}, "column": ["sid", "name"]},
+		"2":   {"predicate": {"sid": [{"op": "<", "val": 0}]}, "column": ["sid", "name"]}
+	}`, false)
+	expectReport(t, report, []string{"column grade is held by no rule"},
+		[]string{"columns [sid name] of the rows with sid null"})
+
+	report = validateRules(t, `{
+		"0":    {"predicate": {"age": [{"op": ">=", "val": 0}]}, "column": ["sid", "age"]},
+		"1|":   {"predicate": {"sid": [{"op": ">=", "val": "0"}]}, "column": ["name", "name"]},
+		"3":    {"predicate": {"grade": [{"op": "~", "val": 0}]}, "column": ["grade"], "storage": "tape"},
+		"2|2":  {"column": []}
+	}`, false)
+	expectReport(t, report, []string{
+		`rule "0" holds unknown column age`,
+		`the predicate of rule "0" restricts unknown column age`,
+		`rule "1|" has an empty node`,
+		`rule "1|" holds column name twice`,
+		`the predicate of rule "1|" on sid: 0 (string) does not conform to int32`,
+		`rule "2|2" lists node 2 twice`,
+		`rule "2|2" holds no column`,
+		`rule "3" refers to unknown node 3`,
+		`rule "3": unknown storage tape`,
+		`the predicate of rule "3" on grade: unknown operator ~`,
+	}, nil)
+}
+
+func TestBuildTableRejectsInvalidRules(t *testing.T) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(2, network, "InvalidRulesCluster")
+	schema := TableSchema{TableName: "student", ColumnSchemas: []ColumnSchema{{Name: "sid", DataType: TypeInt32}}}
+	for _, rules := range []string{
+		`{"0": {"columns": ["sid"]}}`,
+		`{"0": {"predicate": {"sid": [{"op": ">=", "val": 0}]}, "column": ["sid"]}, "1": {"column": ["sid"]}}`,
+		`[]`,
+	} {
+		reply := ""
+		c.BuildTable([]interface{}{schema, []byte(rules)}, &reply)
+		if reply == "0 OK" {
+			t.Errorf("%s should be rejected", rules)
+		}
+	}
+	if _, ok := c.tableSchemas["student"]; ok {
+		t.Errorf("a rejected table should not be registered")
+	}
+
+	// the fields that are not in Rule are ignored
+	report := PartitionReport{}
+	c.ValidateRules([]interface{}{schema, []byte(`{"0": {"column": ["sid"], "comment": "all rows"}}`)}, &report)
+	if !report.OK() || len(report.Warnings) != 0 {
+		t.Errorf("expected a valid rule, actual %v", &report)
+	}
+
+	// the values held by no fragment are only refused if the cluster rejects gaps
+	gapped := []byte(`{"0": {"predicate": {"sid": [{"op": ">=", "val": 0}]}, "column": ["sid"]}}`)
+	c.RejectGaps = true
+	reply := ""
+	if c.BuildTable([]interface{}{schema, gapped}, &reply); reply == "0 OK" {
+		t.Errorf("rules leaving gaps should be rejected")
+	}
+	c.RejectGaps = false
+	if c.BuildTable([]interface{}{schema, gapped}, &reply); reply != "0 OK" {
+		t.Errorf("rules leaving gaps should be accepted, actual %v", reply)
+	}
+}
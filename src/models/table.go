@@ -1,53 +1,346 @@
-package models
-
-// Table is an in-memory two-dimensional table which consists of a table schema and a row store
-// it is not yet a relational table as it does not support primary keys or other constraints.
-type Table struct {
-	schema, fullSchema *TableSchema
-	rowStore           RowStore
-	predicate          *Predicate
-}
-
-func NewTable(schema *TableSchema, rowStore RowStore) *Table {
-	return &Table{schema: schema, rowStore: rowStore}
-}
-
-// GetColumnCount returns the number of columns in the table.
-func (t *Table) GetColumnCount() int {
-	return len(t.schema.ColumnSchemas)
-}
-
-// GetColumnName returns the name of the ith column, or an empty string if the index is invalid.
-func (t *Table) GetColumnName(i int) string {
-	if i < 0 || i >= len(t.schema.ColumnSchemas) {
-		return ""
-	}
-	return t.schema.ColumnSchemas[i].Name
-}
-
-// GetColumnType the return value is one in datatype.go, or -1 if the index is invalid.
-func (t *Table) GetColumnType(i int) int {
-	if i < 0 || i >= len(t.schema.ColumnSchemas) {
-		return -1
-	}
-	return t.schema.ColumnSchemas[i].DataType
-}
-
-func (t *Table) RowIterator() RowIterator {
-	return t.rowStore.iterator()
-}
-
-// Insert inserts a row into the store. The row will be copied by the store.
-func (t *Table) Insert(row *Row) {
-	t.rowStore.insert(row)
-}
-
-// Remove removes a row from the store, and does not concern whether it exists.
-func (t *Table) Remove(row *Row) {
-	t.rowStore.remove(row)
-}
-
-// Count returns how many rows are in the table.
-func (t *Table) Count() int {
-	return t.rowStore.count()
-}
+package models
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Table is an in-memory two-dimensional table which consists of a table schema and a row store
+// it does not check the constraints declared in its schema itself, as a fragment only holds part of the table; they
+// are enforced by the coordinator of the cluster before the rows are sent to the fragments.
+// A Table is safe for concurrent use: readers share mu while Insert and Remove hold it exclusively.
+type Table struct {
+	schema, fullSchema *TableSchema
+	rowStore           RowStore
+	predicate          *Predicate
+	// the kind of rowStore, one of the constants in row_store.go
+	storage int
+	// column name -> the index on the column, maintained on every insertion and removal
+	indexes map[string]*columnIndex
+	mu      sync.RWMutex
+}
+
+// the name of the hidden column identifying the rows of a distributed table, which is the first column of every
+// fragment and is always indexed
+const idColumnName = "id"
+
+// the type of the ids, which are assigned from a sequence of each distributed table, see rowIds
+const idDataType = TypeInt64
+
+func NewTable(schema *TableSchema, rowStore RowStore) *Table {
+	t := &Table{schema: schema, rowStore: rowStore, indexes: make(map[string]*columnIndex)}
+	if len(schema.ColumnSchemas) > 0 && schema.ColumnSchemas[0].Name == idColumnName {
+		// ordered, so that the rows of a range of ids are read at once
+		t.createIndexLocked(idColumnName, IndexBTree)
+	}
+	return t
+}
+
+// GetColumnCount returns the number of columns in the table.
+func (t *Table) GetColumnCount() int {
+	return len(t.schema.ColumnSchemas)
+}
+
+// GetColumnName returns the name of the ith column, or an empty string if the index is invalid.
+func (t *Table) GetColumnName(i int) string {
+	if i < 0 || i >= len(t.schema.ColumnSchemas) {
+		return ""
+	}
+	return t.schema.ColumnSchemas[i].Name
+}
+
+// GetColumnType the return value is one in datatype.go, or -1 if the index is invalid.
+func (t *Table) GetColumnType(i int) int {
+	if i < 0 || i >= len(t.schema.ColumnSchemas) {
+		return -1
+	}
+	return t.schema.ColumnSchemas[i].DataType
+}
+
+// RowIterator returns an iterator over a snapshot of the rows taken under the read lock, so that the caller can
+// iterate at its own pace while other requests keep modifying the table.
+func (t *Table) RowIterator() RowIterator {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rows := make([]Row, 0, t.rowStore.count())
+	iterator := t.rowStore.iterator()
+	for iterator.HasNext() {
+		rows = append(rows, *iterator.Next())
+	}
+	return NewSliceRowIterator(rows)
+}
+
+// Insert inserts a row into the store. The row will be copied by the store. An error is returned if the store
+// cannot persist the row.
+func (t *Table) Insert(row *Row) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.insertLocked(row)
+}
+
+// insertLocked inserts a row into the store and the indexes, the caller must hold t.mu exclusively.
+func (t *Table) insertLocked(row *Row) error {
+	if err := t.rowStore.insert(row); err != nil {
+		return err
+	}
+	stored := t.stored(*row)
+	for _, index := range t.indexes {
+		index.insert(stored)
+	}
+	return nil
+}
+
+// InsertOnce inserts a row of a fragment like Insert does, unless a row with the same id is already in the table, so
+// that the insertion of a row may be retried: the row is then ignored if it equals the stored one, and rejected
+// otherwise.
+func (t *Table) InsertOnce(row *Row) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.insertOnceLocked(row)
+}
+
+// insertOnceLocked inserts a row like InsertOnce does, the caller must hold t.mu exclusively.
+func (t *Table) insertOnceLocked(row *Row) error {
+	if index, ok := t.indexes[idColumnName]; ok && len(*row) > 0 {
+		if id, err := NormalizeValue((*row)[0], index.dataType); err == nil && id != nil {
+			for _, stored := range index.index.get(id) {
+				if stored.Equals(row) {
+					return nil
+				}
+				return fmt.Errorf("duplicate id %v", id)
+			}
+		}
+	}
+	return t.insertLocked(row)
+}
+
+// ScanIds returns at most limit rows of a fragment whose ids are greater than the given one, in the order of their
+// ids, so that a fragment is read range by range through its id index. It returns false if the table is not a
+// fragment.
+func (t *Table) ScanIds(after int64, limit int) ([]Row, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	index, ok := t.indexes[idColumnName]
+	if !ok {
+		return nil, false
+	}
+	rows := index.index.(*bTreeIndex).scanAfter(after, limit)
+	copied := make([]Row, len(rows))
+	for i, row := range rows {
+		copied[i] = append(Row(nil), row...)
+	}
+	return copied, true
+}
+
+// MergeRows inserts the given rows of a fragment, replacing the rows with the same ids that differ from them, so that
+// a replica holds the same rows as the one they are copied from.
+func (t *Table) MergeRows(rows []Row) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	index, ok := t.indexes[idColumnName]
+	if !ok {
+		return fmt.Errorf("%s is not a fragment", t.schema.TableName)
+	}
+	for i := range rows {
+		id, err := NormalizeValue(rows[i][0], index.dataType)
+		if err != nil || id == nil {
+			return fmt.Errorf("invalid id %v", rows[i][0])
+		}
+		same := false
+		// the rows are copied as the index is modified by the removal
+		for _, stored := range append([]Row(nil), index.index.get(id)...) {
+			if stored.Equals(&rows[i]) {
+				same = true
+			} else if err := t.removeLocked(&stored); err != nil {
+				return err
+			}
+		}
+		if same {
+			continue
+		}
+		if err := t.insertLocked(&rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes a row from the store, and does not concern whether it exists.
+func (t *Table) Remove(row *Row) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.removeLocked(row)
+}
+
+// removeLocked removes a row from the store and the indexes, the caller must hold t.mu exclusively.
+func (t *Table) removeLocked(row *Row) error {
+	if err := t.rowStore.remove(row); err != nil {
+		return err
+	}
+	stored := t.stored(*row)
+	for _, index := range t.indexes {
+		index.remove(stored)
+	}
+	return nil
+}
+
+// stored returns the row as it is kept by the store, the indexes keep the same values so that a lookup returns the
+// same rows as a scan.
+func (t *Table) stored(row Row) Row {
+	if store, ok := t.rowStore.(*ColumnarRowStore); ok {
+		if normalized, err := store.normalize(&row); err == nil {
+			return normalized
+		}
+	}
+	return row
+}
+
+// CreateIndex creates an index of the given kind (see index.go) on a column and fills it with the existing rows. It
+// does nothing if the column already has an index of the same kind.
+func (t *Table) CreateIndex(column string, kind int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.createIndexLocked(column, kind)
+}
+
+func (t *Table) createIndexLocked(column string, kind int) error {
+	if index, ok := t.indexes[column]; ok {
+		if index.kind == kind {
+			return nil
+		}
+		return fmt.Errorf("column %s already has an index of another kind", column)
+	}
+	for i, cs := range t.schema.ColumnSchemas {
+		if cs.Name == column {
+			index := newColumnIndex(i, cs.DataType, kind)
+			iterator := t.rowStore.iterator()
+			for iterator.HasNext() {
+				index.insert(*iterator.Next())
+			}
+			t.indexes[column] = index
+			return nil
+		}
+	}
+	return fmt.Errorf("no such column %s", column)
+}
+
+// Lookup returns the rows whose value of the given column equals the value, using the index on the column if there is
+// one. It is mainly used to find a row by its id.
+func (t *Table) Lookup(column string, value interface{}) ([]Row, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if index, ok := t.indexes[column]; ok {
+		if key, err := NormalizeValue(value, index.dataType); err == nil {
+			return append([]Row(nil), index.index.get(key)...), nil
+		}
+	}
+	for i, cs := range t.schema.ColumnSchemas {
+		if cs.Name == column {
+			rows := make([]Row, 0)
+			iterator := t.rowStore.iterator()
+			for iterator.HasNext() {
+				row := iterator.Next()
+				if (*row)[i] == value {
+					rows = append(rows, *row)
+				}
+			}
+			return rows, nil
+		}
+	}
+	return nil, fmt.Errorf("no such column %s", column)
+}
+
+// Count returns how many rows are in the table.
+func (t *Table) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.rowStore.count()
+}
+
+// Select returns the rows satisfying the given predicate, ignoring the atoms on the columns that are not in the table.
+// The predicate is evaluated on whole columns at once if the store supports it, e.g., ColumnarRowStore.
+func (t *Table) Select(predicate Predicate) ([]Row, error) {
+	predicate = predicate.clone()
+	if err := predicate.bind(t.schema.ColumnSchemas); err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if candidates, ok := t.indexCandidates(predicate); ok {
+		rows := make([]Row, 0, len(candidates))
+		for _, row := range candidates {
+			if t.matches(row, predicate) {
+				rows = append(rows, row)
+			}
+		}
+		return rows, nil
+	}
+	if store, ok := t.rowStore.(*ColumnarRowStore); ok {
+		return store.filter(predicate), nil
+	}
+	rows := make([]Row, 0)
+	iterator := t.rowStore.iterator()
+	for iterator.HasNext() {
+		row := iterator.Next()
+		if t.matches(*row, predicate) {
+			rows = append(rows, *row)
+		}
+	}
+	return rows, nil
+}
+
+// indexCandidates looks up the indexes with the atoms of the predicate on the indexed columns, the operands of the
+// logical operators being ignored. The first atom an index answers, in no particular order, gives the candidates,
+// which are then replaced by those of any equality atom returning fewer rows. It returns false if no atom can be
+// answered, otherwise the returned rows are a superset of the rows satisfying the predicate, in the order of the index
+// followed by the rows whose value the index cannot hold.
+func (t *Table) indexCandidates(predicate Predicate) ([]Row, bool) {
+	var best []Row
+	found := false
+	for column, index := range t.indexes {
+		for i := range predicate[column] {
+			atom := &predicate[column][i]
+			if found && atom.Op != "=" && atom.Op != "==" {
+				continue
+			}
+			if rows, ok := index.lookup(atom); ok && (!found || len(rows) < len(best)) {
+				best = rows
+				found = true
+			}
+		}
+	}
+	return best, found
+}
+
+// matches checks a row of this table against the atoms on the columns of this table.
+func (t *Table) matches(row Row, predicate Predicate) bool {
+	return matchRow(t.schema.ColumnSchemas, row, predicate)
+}
+
+// matchRow checks a row with the given columns against the atoms on these columns, the predicate must be bound to them.
+// A row matches if the predicate may be true, as the parts of the predicate on other columns cannot exclude it.
+func matchRow(columns []ColumnSchema, row Row, predicate Predicate) bool {
+	return predicate.eval(columns, row)&truthTrue != 0
+}
+
+// meta returns the definition of the table, which is enough to create an empty copy of it with the same indexes.
+func (t *Table) meta() tableMeta {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	indexes := make(map[string]int)
+	for column, index := range t.indexes {
+		indexes[column] = index.kind
+	}
+	return tableMeta{Schema: *t.schema, FullSchema: t.fullSchema, Predicate: t.predicate, Storage: t.storage,
+		Indexes: indexes}
+}
+
+// createIndexes creates the indexes recorded in the definition of the table, e.g., after it is recovered.
+func (t *Table) createIndexes(indexes map[string]int) error {
+	for column, kind := range indexes {
+		if err := t.CreateIndex(column, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,7 +1,43 @@
-package models
-
-// TableSchema contains the name of the table and the definition of each column
-type TableSchema struct {
-	TableName string
-	ColumnSchemas []ColumnSchema
-}
+package models
+
+// TableSchema contains the name of the table and the definition of each column
+type TableSchema struct {
+	TableName string
+	ColumnSchemas []ColumnSchema
+	// the columns of the primary key, empty if the table has none. No two rows may have the same values in them, and
+	// none of them may be null
+	PrimaryKey []string
+	// each element is a group of columns that no two rows may have the same values in, rows having a null in the
+	// group are not concerned
+	Unique [][]string
+	ForeignKeys []ForeignKey
+}
+
+// the actions taken on the rows of the child table when a referenced row of the parent table is deleted
+const (
+	// the deletion is rejected, which is the default
+	OnDeleteRestrict = "RESTRICT"
+	// the referencing rows are deleted as well
+	OnDeleteCascade = "CASCADE"
+)
+
+// ForeignKey declares that the values of some columns of a table, unless one of them is null, must be the values of
+// the primary key or a unique group of columns of a row in another table (or the same one)
+type ForeignKey struct {
+	Columns []string
+	RefTable string
+	// the referenced columns, the primary key of RefTable if empty
+	RefColumns []string
+	// OnDeleteRestrict if empty
+	OnDelete string
+}
+
+// ColumnIndex returns the position of the named column, or -1 if there is no such column.
+func (s *TableSchema) ColumnIndex(name string) int {
+	for i, cs := range s.ColumnSchemas {
+		if cs.Name == name {
+			return i
+		}
+	}
+	return -1
+}
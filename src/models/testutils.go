@@ -1,74 +1,108 @@
-package models
-
-// compare two datasets, ignoring the names of them and the order of columns and rows
-func compareDataset(a Dataset, b Dataset) bool {
-	columnMapping := compareDatasetSchema(a.Schema, b.Schema)
-	if columnMapping == nil {
-		return false
-	}
-
-	return compareRows(a.Rows, b.Rows, columnMapping)
-}
-
-func compareRows(a []Row, b []Row, columnMapping []int) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	// ensure that each row in a can be found in b, and each row in b can be found in a
-	for _, rowA := range a {
-		matched := false
-		for _, rowB := range b {
-			if rowA.EqualsWithColumnMapping(&rowB, columnMapping) {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return false
-		}
-	}
-
-	for _, rowB := range b {
-		matched := false
-		for _, rowA := range a {
-			if rowA.EqualsWithColumnMapping(&rowB, columnMapping) {
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return false
-		}
-	}
-
-	return true
-}
-
-// compare two TableSchemas, ignoring the names of them and the order of rows
-// if the two do not equal, nil will be returned;
-// otherwise, a column mapping will be returned,
-// for example, if TableSchema a contains 3 columns [c1, c2, c3], and TableSchema b contains 3 columns [c3, c2, c1],
-// then [3, 2, 1] will be returned,
-// indicating that the first column in TableSchema a is the third column in b and so on.
-func compareDatasetSchema(a TableSchema, b TableSchema) []int {
-	if len(a.ColumnSchemas) != len(b.ColumnSchemas) {
-		return nil
-	}
-
-	columnMapping := make([]int, len(a.ColumnSchemas))
-	// find the position of a's each column in b
-	for i, columnSchemaA := range a.ColumnSchemas {
-		matched := false
-		for j, columnSchemaB := range b.ColumnSchemas {
-			if columnSchemaA.Name == columnSchemaB.Name {
-				matched = true
-				columnMapping[i] = j
-				break
-			}
-		}
-		if !matched {
-			return nil
-		}
-	}
-	return columnMapping
+package models
+
+import (
+	"fmt"
+
+	"../labrpc"
+)
+
+// newTestCluster creates a cluster of in-memory nodes on a new network, named name+"Cluster", and a client end named
+// name+"Client" connected to its coordinator.
+func newTestCluster(nodeNum int, name string) (*Cluster, *labrpc.Network, *labrpc.ClientEnd) {
+	network := labrpc.MakeNetwork()
+	c := NewCluster(nodeNum, network, name+"Cluster")
+	return c, network, connectEnd(network, name+"Client", c.Name)
+}
+
+// connectEnd creates a client end of the given name connected to a server of the network.
+func connectEnd(network *labrpc.Network, endName string, serverName string) *labrpc.ClientEnd {
+	end := network.MakeEnd(endName)
+	network.Connect(endName, serverName)
+	network.Enable(endName, true)
+	return end
+}
+
+// buildTestTable builds a distributed table through a client end of the cluster, the params following the schema are
+// those of Cluster.BuildTable, i.e., the rules and the optional hash partitioning. The reply is returned as an error
+// unless it is "0 OK".
+func buildTestTable(cli *labrpc.ClientEnd, schema interface{}, params ...interface{}) error {
+	reply := ""
+	cli.Call("Cluster.BuildTable", append([]interface{}{schema}, params...), &reply)
+	if reply != "0 OK" {
+		return fmt.Errorf("cannot build table: %s", reply)
+	}
+	return nil
+}
+
+// compare two datasets, ignoring the names of them and the order of columns and rows
+func compareDataset(a Dataset, b Dataset) bool {
+	columnMapping := compareDatasetSchema(a.Schema, b.Schema)
+	if columnMapping == nil {
+		return false
+	}
+
+	return compareRows(a.Rows, b.Rows, columnMapping)
+}
+
+func compareRows(a []Row, b []Row, columnMapping []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	// ensure that each row in a can be found in b, and each row in b can be found in a
+	for _, rowA := range a {
+		matched := false
+		for _, rowB := range b {
+			if rowA.EqualsWithColumnMapping(&rowB, columnMapping) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, rowB := range b {
+		matched := false
+		for _, rowA := range a {
+			if rowA.EqualsWithColumnMapping(&rowB, columnMapping) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compare two TableSchemas, ignoring the names of them and the order of rows
+// if the two do not equal, nil will be returned;
+// otherwise, a column mapping will be returned,
+// for example, if TableSchema a contains 3 columns [c1, c2, c3], and TableSchema b contains 3 columns [c3, c2, c1],
+// then [3, 2, 1] will be returned,
+// indicating that the first column in TableSchema a is the third column in b and so on.
+func compareDatasetSchema(a TableSchema, b TableSchema) []int {
+	if len(a.ColumnSchemas) != len(b.ColumnSchemas) {
+		return nil
+	}
+
+	columnMapping := make([]int, len(a.ColumnSchemas))
+	// find the position of a's each column in b
+	for i, columnSchemaA := range a.ColumnSchemas {
+		matched := false
+		for j, columnSchemaB := range b.ColumnSchemas {
+			if columnSchemaA.Name == columnSchemaB.Name {
+				matched = true
+				columnMapping[i] = j
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+	return columnMapping
 }
\ No newline at end of file
@@ -1,89 +1,128 @@
-package models
-
-import "testing"
-
-func TestCompareDataset(t *testing.T) {
-	a := Dataset{
-		Schema: TableSchema{
-			"a",
-			[]ColumnSchema {
-				{"c1", TypeInt32},
-				{"c2", TypeFloat},
-				{"c3", TypeString},
-			},
-		},
-
-		Rows: []Row{
-			{1, 1.0, "1.0"},
-			{2, 2.0, "2.0"},
-			{3, 3.0, "3.0"},
-		},
-	}
-
-	b := Dataset{
-		Schema: TableSchema{
-			"b",
-			[]ColumnSchema {
-				{"c3", TypeString},
-				{"c2", TypeFloat},
-				{"c1", TypeInt32},
-			},
-		},
-
-		Rows: []Row{
-			{"3.0", 3.0, 3},
-			{"2.0", 2.0, 2},
-			{"1.0", 1.0, 1},
-		},
-	}
-
-	caseNum := 0
-	if !compareDataset(a, b) {
-		t.Errorf("Two datasets should be equal, caseNum: %d", caseNum)
-	}
-
-	// change a column
-	caseNum ++
-	b.Schema.ColumnSchemas[1].Name = "c4"
-	if compareDataset(a, b) {
-		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
-	}
-
-	// change a row
-	caseNum ++
-	b.Schema.ColumnSchemas[1].Name = "c2"
-	b.Rows[0][0] = "4.0"
-	if compareDataset(a, b) {
-		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
-	}
-
-	// add a column
-	caseNum ++
-	b.Rows[0][0] = "3.0"
-	b.Schema.ColumnSchemas = []ColumnSchema {
-		{"c3", TypeString},
-		{"c2", TypeFloat},
-		{"c1", TypeInt32},
-		{"c4", TypeBoolean},
-	}
-	if compareDataset(a, b) {
-		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
-	}
-
-	// add a row
-	caseNum ++
-	b.Schema.ColumnSchemas = []ColumnSchema {
-		{"c3", TypeString},
-		{"c2", TypeFloat},
-		{"c1", TypeInt32},
-	}
-	b.Rows = []Row{
-		{"4.0", 4.0, 4},
-		{"3.0", 3.0, 3},
-		{"2.0", 2.0, 2},
-		{"1.0", 1.0, 1},
-	}
-	if compareDataset(a, b) {
-		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
-	}
-}
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompareDataset(t *testing.T) {
+	a := Dataset{
+		Schema: TableSchema{
+			TableName: "a",
+			ColumnSchemas: []ColumnSchema {
+				{Name: "c1", DataType: TypeInt32},
+				{Name: "c2", DataType: TypeFloat},
+				{Name: "c3", DataType: TypeString},
+			},
+		},
+
+		Rows: []Row{
+			{1, 1.0, "1.0"},
+			{2, 2.0, "2.0"},
+			{3, 3.0, "3.0"},
+		},
+	}
+
+	b := Dataset{
+		Schema: TableSchema{
+			TableName: "b",
+			ColumnSchemas: []ColumnSchema {
+				{Name: "c3", DataType: TypeString},
+				{Name: "c2", DataType: TypeFloat},
+				{Name: "c1", DataType: TypeInt32},
+			},
+		},
+
+		Rows: []Row{
+			{"3.0", 3.0, 3},
+			{"2.0", 2.0, 2},
+			{"1.0", 1.0, 1},
+		},
+	}
+
+	caseNum := 0
+	if !compareDataset(a, b) {
+		t.Errorf("Two datasets should be equal, caseNum: %d", caseNum)
+	}
+
+	// change a column
+	caseNum ++
+	b.Schema.ColumnSchemas[1].Name = "c4"
+	if compareDataset(a, b) {
+		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
+	}
+
+	// change a row
+	caseNum ++
+	b.Schema.ColumnSchemas[1].Name = "c2"
+	b.Rows[0][0] = "4.0"
+	if compareDataset(a, b) {
+		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
+	}
+
+	// add a column
+	caseNum ++
+	b.Rows[0][0] = "3.0"
+	b.Schema.ColumnSchemas = []ColumnSchema {
+		{Name: "c3", DataType: TypeString},
+		{Name: "c2", DataType: TypeFloat},
+		{Name: "c1", DataType: TypeInt32},
+		{Name: "c4", DataType: TypeBoolean},
+	}
+	if compareDataset(a, b) {
+		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
+	}
+
+	// add a row
+	caseNum ++
+	b.Schema.ColumnSchemas = []ColumnSchema {
+		{Name: "c3", DataType: TypeString},
+		{Name: "c2", DataType: TypeFloat},
+		{Name: "c1", DataType: TypeInt32},
+	}
+	b.Rows = []Row{
+		{"4.0", 4.0, 4},
+		{"3.0", 3.0, 3},
+		{"2.0", 2.0, 2},
+		{"1.0", 1.0, 1},
+	}
+	if compareDataset(a, b) {
+		t.Errorf("Two datasets should not be equal, caseNum: %d", caseNum)
+	}
+}
+
+func TestRowEquals(t *testing.T) {
+	// rows are equal only if their values have the same Go types
+	a := Row{int32(1), int64(2), float32(3.6), 4.5, "5", true, nil}
+	b := Row{int32(1), int64(2), float32(3.6), 4.5, "5", true, nil}
+	if !a.Equals(&b) {
+		t.Errorf("%v should equal %v", a, b)
+	}
+	for i, value := range []interface{}{1, json.Number("2"), 3.6, float32(4.5), 5, false, 0} {
+		c := append(Row(nil), b...)
+		c[i] = value
+		if a.Equals(&c) {
+			t.Errorf("%v should not equal %v", a, c)
+		}
+	}
+}
+
+func TestValuesEqual(t *testing.T) {
+	// values are compared by value in predicates
+	a := Row{1, int64(2), float32(3.6), 4.5, "5", true, nil}
+	b := Row{int32(1), json.Number("2"), 3.6, float32(4.5), "5", true, nil}
+	for i := range a {
+		if !ValuesEqual(a[i], b[i]) {
+			t.Errorf("%v should equal %v", a[i], b[i])
+		}
+	}
+	for i, value := range []interface{}{2, 3, 3.7, 4.4, 5, false, 0} {
+		if ValuesEqual(a[i], value) {
+			t.Errorf("%v should not equal %v", a[i], value)
+		}
+	}
+	// integers beyond the precision of float64
+	if ValuesEqual(int64(1<<60), int64(1<<60+1)) {
+		t.Errorf("%d should not equal %d", int64(1<<60), int64(1<<60+1))
+	}
+}